@@ -0,0 +1,1080 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiFeed is a JSON representation of a podcast feed.
+type apiFeed struct {
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Link        string `json:"link,omitempty"`
+	Description string `json:"description,omitempty"`
+	IconURL     string `json:"icon_url,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Explicit    bool   `json:"explicit"`
+	Profile     string `json:"transcoding_profile,omitempty"`
+	URL         string `json:"url"`
+
+	PostProcessing apiPostProcessing `json:"post_processing"`
+}
+
+// apiPostProcessing is a JSON representation of audio post-processing settings.
+type apiPostProcessing struct {
+	Loudnorm        bool `json:"loudnorm"`
+	TrimSilence     bool `json:"trim_silence"`
+	CompressSilence bool `json:"compress_silence"`
+}
+
+func newAPIPostProcessing(pp PostProcessing) apiPostProcessing {
+	return apiPostProcessing{
+		Loudnorm:        pp.Loudnorm,
+		TrimSilence:     pp.TrimSilence,
+		CompressSilence: pp.CompressSilence,
+	}
+}
+
+// PostProcessing converts the JSON representation into PostProcessing.
+func (pp apiPostProcessing) PostProcessing() PostProcessing {
+	return PostProcessing{
+		Loudnorm:        pp.Loudnorm,
+		TrimSilence:     pp.TrimSilence,
+		CompressSilence: pp.CompressSilence,
+	}
+}
+
+func newAPIFeed(feed PodcastFeed, baseURL string) apiFeed {
+	return apiFeed{
+		Slug:        feed.Slug,
+		Title:       feed.Title,
+		Link:        feed.Link,
+		Description: feed.Description,
+		IconURL:     feed.IconURL,
+		Author:      feed.Author,
+		Owner:       feed.Owner,
+		Email:       feed.Email,
+		Language:    feed.Language,
+		Category:    feed.Category,
+		Explicit:    feed.Explicit,
+		Profile:     feed.TranscodingProfile,
+		URL:         baseURL + feed.Path(),
+
+		PostProcessing: newAPIPostProcessing(feed.PostProcessing),
+	}
+}
+
+// apiItem is a JSON representation of a podcast item.
+type apiItem struct {
+	ID            string    `json:"id"`
+	Feed          string    `json:"feed"`
+	Type          string    `json:"type"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description,omitempty"`
+	Author        string    `json:"author,omitempty"`
+	OriginalURL   string    `json:"original_url,omitempty"`
+	MediaURL      string    `json:"media_url,omitempty"`
+	ImageURL      string    `json:"image_url,omitempty"`
+	TranscriptURL string    `json:"transcript_url,omitempty"`
+	Duration      float64   `json:"duration"`
+	MIMEType      string    `json:"mime_type,omitempty"`
+	ContentLength int64     `json:"content_length,omitempty"`
+	BitRate       int64     `json:"bit_rate,omitempty"`
+	Codec         string    `json:"codec,omitempty"`
+	Channels      int       `json:"channels,omitempty"`
+	Profile       string    `json:"transcoding_profile,omitempty"`
+	AddedAt       time.Time `json:"added_at"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+
+	PostProcessing apiPostProcessing `json:"post_processing"`
+	Chapters       []apiChapter      `json:"chapters,omitempty"`
+}
+
+// apiChapter is a JSON representation of an item chapter.
+type apiChapter struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+func newAPIItem(feed string, item PodcastItem, baseURL string) apiItem {
+	it := apiItem{
+		ID:            item.ID(),
+		Feed:          feed,
+		Type:          item.Type.String(),
+		Title:         item.Title,
+		Description:   item.Body,
+		Author:        item.Author,
+		OriginalURL:   item.OriginalURL,
+		Duration:      item.Duration.Seconds(),
+		MIMEType:      item.MIMEType,
+		ContentLength: item.ContentLength,
+		BitRate:       item.BitRate,
+		Codec:         item.Codec,
+		Channels:      item.Channels,
+		Profile:       item.TranscodingProfile,
+		AddedAt:       item.AddedAt,
+		Status:        item.Status.String(),
+		Error:         item.Error,
+
+		PostProcessing: newAPIPostProcessing(item.PostProcessing),
+	}
+
+	for _, ch := range item.Chapters {
+		it.Chapters = append(it.Chapters, apiChapter{ch.Start.Seconds(), ch.Title})
+	}
+
+	if item.Playable() {
+		it.MediaURL = baseURL + "/downloads/" + item.FileName
+	}
+
+	if item.ImageFileName != "" {
+		it.ImageURL = baseURL + "/downloads/" + item.ImageFileName
+	}
+
+	if item.TranscriptFileName != "" {
+		it.TranscriptURL = baseURL + transcriptPath(feed, item.ID(), TranscriptFormats[0].Ext)
+	}
+
+	return it
+}
+
+// apiJob is a JSON representation of a download job.
+type apiJob struct {
+	Feed          string     `json:"feed"`
+	ItemID        string     `json:"item_id"`
+	Status        string     `json:"status"`
+	SourceURI     string     `json:"source_uri"`
+	TargetURI     string     `json:"target_uri"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+func newAPIJob(job DownloadJob) apiJob {
+	feed := job.Feed
+	if feed == "" {
+		feed = DefaultFeed
+	}
+
+	j := apiJob{
+		Feed:      feed,
+		ItemID:    job.ItemID,
+		Status:    job.Status.String(),
+		SourceURI: job.SourceURI,
+		TargetURI: job.TargetURI,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+	}
+
+	if !job.NextAttemptAt.IsZero() {
+		j.NextAttemptAt = &job.NextAttemptAt
+	}
+
+	return j
+}
+
+// apiToken is a JSON representation of an access token.
+type apiToken struct {
+	Token     string    `json:"token"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAPIToken(t AccessToken) apiToken {
+	return apiToken{
+		Token:     t.Token,
+		Name:      t.Name,
+		Kind:      t.Kind.String(),
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// apiSubscription is a JSON representation of a channel or playlist subscription, or a mirror.
+type apiSubscription struct {
+	ID                 string    `json:"id"`
+	Feed               string    `json:"feed"`
+	Kind               string    `json:"kind"`
+	URL                string    `json:"url"`
+	Title              string    `json:"title,omitempty"`
+	TitlePattern       string    `json:"title_pattern,omitempty"`
+	MinDuration        float64   `json:"min_duration,omitempty"`
+	MaxDuration        float64   `json:"max_duration,omitempty"`
+	ExcludeShorts      bool      `json:"exclude_shorts"`
+	KeepLatest         int       `json:"keep_latest,omitempty"`
+	MaxAge             float64   `json:"max_age,omitempty"`
+	TranscodingProfile string    `json:"transcoding_profile,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	CheckedAt          time.Time `json:"checked_at,omitzero"`
+	NextCheckAt        time.Time `json:"next_check_at,omitzero"`
+	LastError          string    `json:"last_error,omitempty"`
+}
+
+func newAPISubscription(sub Subscription) apiSubscription {
+	return apiSubscription{
+		ID:                 sub.ID,
+		Feed:               sub.Feed,
+		Kind:               sub.Kind.String(),
+		URL:                sub.URL(),
+		Title:              sub.Title,
+		TitlePattern:       sub.TitlePattern,
+		MinDuration:        sub.MinDuration.Seconds(),
+		MaxDuration:        sub.MaxDuration.Seconds(),
+		ExcludeShorts:      sub.ExcludeShorts,
+		KeepLatest:         sub.KeepLatest,
+		MaxAge:             sub.MaxAge.Seconds(),
+		TranscodingProfile: sub.TranscodingProfile,
+		CreatedAt:          sub.CreatedAt,
+		CheckedAt:          sub.CheckedAt,
+		NextCheckAt:        sub.NextCheckAt,
+		LastError:          sub.LastError,
+	}
+}
+
+// apiImport is a JSON representation of a list of items, i.e. a playlist, being added to a feed.
+type apiImport struct {
+	Feed        string `json:"feed"`
+	Title       string `json:"title"`
+	Author      string `json:"author,omitempty"`
+	OriginalURL string `json:"original_url"`
+	ItemsURL    string `json:"items_url"`
+}
+
+// apiError is a JSON representation of an error returned by API.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// APIMux returns a ServeMux instance that serves JSON API. The returned handler expects
+// the version prefix to be stripped from the request path.
+func (srv *FeedServer) APIMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /feeds", srv.APIListFeeds)
+	mux.HandleFunc("POST /feeds", srv.APICreateFeed)
+	mux.HandleFunc("GET /feeds/{feed}", srv.APIGetFeed)
+	mux.HandleFunc("PATCH /feeds/{feed}", srv.APIUpdateFeed)
+	mux.HandleFunc("DELETE /feeds/{feed}", srv.APIRemoveFeed)
+	mux.HandleFunc("GET /feeds/{feed}/items", srv.APIListItems)
+	mux.HandleFunc("POST /feeds/{feed}/items", srv.APICreateItem)
+	mux.HandleFunc("GET /feeds/{feed}/items/{id}", srv.APIGetItem)
+	mux.HandleFunc("PATCH /feeds/{feed}/items/{id}", srv.APIUpdateItem)
+	mux.HandleFunc("DELETE /feeds/{feed}/items/{id}", srv.APIRemoveItem)
+	mux.HandleFunc("POST /feeds/{feed}/items/{id}/retry", srv.APIRetryItem)
+	mux.HandleFunc("POST /feeds/{feed}/items/{id}/cancel", srv.APICancelItem)
+	mux.HandleFunc("GET /jobs", srv.APIListJobs)
+	mux.HandleFunc("GET /tokens", srv.APIListTokens)
+	mux.HandleFunc("POST /tokens", srv.APICreateToken)
+	mux.HandleFunc("DELETE /tokens/{token}", srv.APIRevokeToken)
+	mux.HandleFunc("GET /subscriptions", srv.APIListSubscriptions)
+	mux.HandleFunc("POST /subscriptions", srv.APICreateSubscription)
+	mux.HandleFunc("GET /subscriptions/{id}", srv.APIGetSubscription)
+	mux.HandleFunc("DELETE /subscriptions/{id}", srv.APIRemoveSubscription)
+	mux.HandleFunc("POST /subscriptions/{id}/check", srv.APICheckSubscription)
+	mux.HandleFunc("GET /export", srv.APIExport)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no such endpoint")
+	})
+
+	return mux
+}
+
+// APIListFeeds responds with the list of feeds.
+func (srv *FeedServer) APIListFeeds(w http.ResponseWriter, req *http.Request) {
+	feeds, err := srv.feeds.All()
+	if err != nil {
+		log.Println("failed to fetch feeds:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch feeds")
+		return
+	}
+
+	baseURL := reqBaseURL(req)
+
+	res := make([]apiFeed, 0, len(feeds))
+	for _, feed := range feeds {
+		res = append(res, newAPIFeed(feed, baseURL))
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
+
+// APICreateFeed creates a new feed.
+func (srv *FeedServer) APICreateFeed(w http.ResponseWriter, req *http.Request) {
+	var params apiFeed
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+
+	feed := PodcastFeed{
+		Slug: strings.ToLower(strings.TrimSpace(params.Slug)),
+		PodcastMetadata: PodcastMetadata{
+			Title:       strings.TrimSpace(params.Title),
+			Link:        strings.TrimSpace(params.Link),
+			Description: strings.TrimSpace(params.Description),
+			IconURL:     strings.TrimSpace(params.IconURL),
+			Author:      strings.TrimSpace(params.Author),
+			Owner:       strings.TrimSpace(params.Owner),
+			Email:       strings.TrimSpace(params.Email),
+			Language:    strings.TrimSpace(params.Language),
+			Category:    strings.TrimSpace(params.Category),
+			Explicit:    params.Explicit,
+		},
+		TranscodingProfile: strings.TrimSpace(params.Profile),
+		PostProcessing:     params.PostProcessing.PostProcessing(),
+	}
+
+	if feed.Title == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing title")
+		return
+	}
+
+	switch err := srv.feeds.Create(feed); {
+	case err == nil:
+	case err == ErrInvalidFeedSlug, errors.Is(err, ErrUnknownProfile):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	case err == ErrFeedExists:
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		log.Println("failed to create feed", feed.Slug, ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to create feed")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/feeds/"+feed.Slug)
+	writeAPIResponse(w, http.StatusCreated, newAPIFeed(feed, reqBaseURL(req)))
+}
+
+// APIGetFeed responds with the feed metadata.
+func (srv *FeedServer) APIGetFeed(w http.ResponseWriter, req *http.Request) {
+	feed, ok := srv.apiFeed(w, req)
+	if !ok {
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPIFeed(feed, reqBaseURL(req)))
+}
+
+// APIUpdateFeed updates the feed metadata. Only the fields present in the request body are updated.
+func (srv *FeedServer) APIUpdateFeed(w http.ResponseWriter, req *http.Request) {
+	feed, ok := srv.apiFeed(w, req)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Title       *string `json:"title"`
+		Link        *string `json:"link"`
+		Description *string `json:"description"`
+		IconURL     *string `json:"icon_url"`
+		Author      *string `json:"author"`
+		Owner       *string `json:"owner"`
+		Email       *string `json:"email"`
+		Language    *string `json:"language"`
+		Category    *string `json:"category"`
+		Explicit    *bool   `json:"explicit"`
+		Profile     *string `json:"transcoding_profile"`
+
+		PostProcessing *apiPostProcessing `json:"post_processing"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+
+	if params.Title != nil {
+		if feed.Title = strings.TrimSpace(*params.Title); feed.Title == "" {
+			writeAPIError(w, http.StatusBadRequest, "missing title")
+			return
+		}
+	}
+
+	if params.Link != nil {
+		feed.Link = strings.TrimSpace(*params.Link)
+	}
+
+	if params.Description != nil {
+		feed.Description = strings.TrimSpace(*params.Description)
+	}
+
+	if params.IconURL != nil {
+		feed.IconURL = strings.TrimSpace(*params.IconURL)
+	}
+
+	if params.Author != nil {
+		feed.Author = strings.TrimSpace(*params.Author)
+	}
+
+	if params.Owner != nil {
+		feed.Owner = strings.TrimSpace(*params.Owner)
+	}
+
+	if params.Email != nil {
+		feed.Email = strings.TrimSpace(*params.Email)
+	}
+
+	if params.Language != nil {
+		feed.Language = strings.TrimSpace(*params.Language)
+	}
+
+	if params.Category != nil {
+		feed.Category = strings.TrimSpace(*params.Category)
+	}
+
+	if params.Explicit != nil {
+		feed.Explicit = *params.Explicit
+	}
+
+	if params.Profile != nil {
+		feed.TranscodingProfile = strings.TrimSpace(*params.Profile)
+	}
+
+	if params.PostProcessing != nil {
+		feed.PostProcessing = params.PostProcessing.PostProcessing()
+	}
+
+	if err := srv.feeds.Update(feed); err != nil {
+		if errors.Is(err, ErrUnknownProfile) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Println("failed to update feed", feed.Slug, ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to update feed")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPIFeed(feed, reqBaseURL(req)))
+}
+
+// APIRemoveFeed removes the feed with all its items.
+func (srv *FeedServer) APIRemoveFeed(w http.ResponseWriter, req *http.Request) {
+	feed, ok := srv.apiFeed(w, req)
+	if !ok {
+		return
+	}
+
+	if feed.Slug == DefaultFeed {
+		writeAPIError(w, http.StatusBadRequest, "default feed cannot be removed")
+		return
+	}
+
+	if err := srv.feeds.Remove(feed.Slug); err != nil {
+		log.Println("failed to remove feed", feed.Slug, ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to remove feed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListItems responds with the list of feed items including the ones that are not downloaded yet. If the limit=
+// parameter is set, items are listed page by page, and the URL of the next page is sent in the Link header. Items
+// can be filtered by their original URL, status and type with url=, status= and type= parameters.
+func (srv *FeedServer) APIListItems(w http.ResponseWriter, req *http.Request) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	filter, err := apiItemFilter(req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter != (ItemFilter{}) {
+		items, err := svc.Find(filter)
+		if err != nil {
+			log.Println("failed to find podcast items:", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to fetch items")
+			return
+		}
+
+		res := make([]apiItem, 0, len(items))
+		for _, item := range items {
+			res = append(res, newAPIItem(svc.Feed(), item, reqBaseURL(req)))
+		}
+
+		writeAPIResponse(w, http.StatusOK, res)
+		return
+	}
+
+	limit := queryLimit(req)
+
+	items, next, err := svc.ItemsPage(req.FormValue("after"), limit)
+	if err != nil {
+		log.Println("failed to fetch podcast items:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch items")
+		return
+	}
+
+	baseURL := reqBaseURL(req)
+
+	if next != "" {
+		q := url.Values{"after": {next}, "limit": {strconv.Itoa(limit)}}
+		w.Header().Set("Link", "<"+baseURL+"/api/v1/feeds/"+svc.Feed()+"/items?"+q.Encode()+`>; rel="next"`)
+	}
+
+	res := make([]apiItem, 0, len(items))
+	for _, item := range items {
+		res = append(res, newAPIItem(svc.Feed(), item, baseURL))
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
+
+// apiItemFilter returns the item filter set with url=, status= and type= parameters. Statuses and types are
+// referenced by their names, i.e. status=ready or type=youtube.
+func apiItemFilter(req *http.Request) (ItemFilter, error) {
+	filter := ItemFilter{OriginalURL: req.FormValue("url")}
+
+	if s := req.FormValue("status"); s != "" {
+		for st := ItemAdded; st <= ItemCancelled; st++ {
+			if strings.EqualFold(st.String(), s) {
+				filter.Status = st
+			}
+		}
+
+		if filter.Status == 0 {
+			return filter, fmt.Errorf("unknown item status %q", s)
+		}
+	}
+
+	if s := req.FormValue("type"); s != "" {
+		for typ := YouTubeItem; typ <= ImportedItem; typ++ {
+			if strings.EqualFold(typ.String(), s) {
+				filter.Type = typ
+			}
+		}
+
+		if filter.Type == 0 {
+			return filter, fmt.Errorf("unknown item type %q", s)
+		}
+	}
+
+	return filter, nil
+}
+
+// APICreateItem adds a new item to the feed using the provider specified by the provider= parameter.
+// The rest of parameters are passed to the provider as is. Parameters can be sent either as a JSON
+// object or as a form, i.e. when uploading files.
+func (srv *FeedServer) APICreateItem(w http.ResponseWriter, req *http.Request) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	if ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); ct == "application/json" {
+		var params map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+			return
+		}
+
+		req.Form = make(url.Values, len(params))
+		for k, v := range params {
+			req.Form.Set(k, fmt.Sprint(v))
+		}
+	}
+
+	name := req.FormValue("provider")
+	if name == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing provider")
+		return
+	}
+
+	p, ok := srv.providers["/"+name].(audioSourceParser)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "unsupported provider "+name)
+		return
+	}
+
+	profile := req.FormValue("profile")
+	if err := ValidateTranscodingProfile(profile); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	src, err := p.ParseRequest(req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRequest) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("failed to handle %s request: %s", name, err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to create item")
+		return
+	}
+
+	src = withTranscodingProfile(src, profile)
+
+	if list, ok := src.(audioSourceList); ok {
+		srv.apiAddSourceList(w, req, svc, list)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+	defer cancel()
+
+	item, err := svc.AddSource(ctx, src)
+	if err != nil {
+		log.Printf("failed to add %s item to the feed: %s", name, err)
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/feeds/"+svc.Feed()+"/items/"+item.ID())
+	writeAPIResponse(w, http.StatusCreated, newAPIItem(svc.Feed(), item, reqBaseURL(req)))
+}
+
+// APIGetItem responds with a single feed item.
+func (srv *FeedServer) APIGetItem(w http.ResponseWriter, req *http.Request) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	item, err := svc.Item(req.PathValue("id"))
+	if err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPIItem(svc.Feed(), item, reqBaseURL(req)))
+}
+
+// apiAddSourceList validates the list and adds its entries to the feed in background, since fetching metadata
+// of each entry may take a while. It responds with 202 Accepted pointing to the list of feed items.
+func (srv *FeedServer) apiAddSourceList(w http.ResponseWriter, req *http.Request, svc *FeedService, list audioSourceList) {
+	ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+	defer cancel()
+
+	meta, err := list.Metadata(ctx)
+	if err != nil {
+		log.Printf("failed to fetch list metadata: %s", err)
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	go func() {
+		items, err := svc.AddSourceList(context.Background(), list)
+		if err != nil {
+			log.Printf("failed to add %s to the feed: %s", meta.OriginalURL, err)
+			return
+		}
+
+		log.Printf("added %d item(s) from %s to %s", len(items), meta.OriginalURL, svc.Feed())
+	}()
+
+	itemsURL := "/api/v1/feeds/" + svc.Feed() + "/items"
+
+	w.Header().Set("Location", itemsURL)
+	writeAPIResponse(w, http.StatusAccepted, apiImport{
+		Feed:        svc.Feed(),
+		Title:       meta.Title,
+		Author:      meta.Author,
+		OriginalURL: meta.OriginalURL,
+		ItemsURL:    reqBaseURL(req) + itemsURL,
+	})
+}
+
+// APIUpdateItem updates the title and the description of a feed item. Only the fields present
+// in the request body are updated.
+func (srv *FeedServer) APIUpdateItem(w http.ResponseWriter, req *http.Request) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	item, err := svc.Item(req.PathValue("id"))
+	if err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	var params struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+
+	desc := item.Description
+	if params.Title != nil {
+		desc.Title = strings.TrimSpace(*params.Title)
+	}
+
+	if params.Description != nil {
+		desc.Body = strings.TrimSpace(*params.Description)
+	}
+
+	if desc.Title == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing title")
+		return
+	}
+
+	item, err = svc.UpdateItem(item.ID(), desc)
+	if err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPIItem(svc.Feed(), item, reqBaseURL(req)))
+}
+
+// APIRemoveItem removes a feed item along with the downloaded file.
+func (srv *FeedServer) APIRemoveItem(w http.ResponseWriter, req *http.Request) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	if err := svc.RemoveItem(req.PathValue("id")); err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIRetryItem re-enqueues the download of a failed or cancelled feed item.
+func (srv *FeedServer) APIRetryItem(w http.ResponseWriter, req *http.Request) {
+	srv.apiItemAction(w, req, (*FeedService).RetryItem)
+}
+
+// APICancelItem cancels the download of a feed item.
+func (srv *FeedServer) APICancelItem(w http.ResponseWriter, req *http.Request) {
+	srv.apiItemAction(w, req, (*FeedService).CancelItem)
+}
+
+// apiItemAction performs an action on a feed item and responds with the updated item.
+func (srv *FeedServer) apiItemAction(w http.ResponseWriter, req *http.Request, action func(*FeedService, string) error) {
+	svc, ok := srv.apiFeedService(w, req)
+	if !ok {
+		return
+	}
+
+	itemID := req.PathValue("id")
+	if err := action(svc, itemID); err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	item, err := svc.Item(itemID)
+	if err != nil {
+		writeAPIItemError(w, err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPIItem(svc.Feed(), item, reqBaseURL(req)))
+}
+
+// APIListJobs responds with the list of pending download jobs.
+func (srv *FeedServer) APIListJobs(w http.ResponseWriter, req *http.Request) {
+	jobs, err := srv.q.All()
+	if err != nil {
+		log.Println("failed to fetch download jobs:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch jobs")
+		return
+	}
+
+	res := make([]apiJob, 0, len(jobs))
+	for _, job := range jobs {
+		res = append(res, newAPIJob(job))
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
+
+// APIExport responds with an archive containing all feeds, items, subscriptions and media files that can be
+// imported into another instance with the import command.
+func (srv *FeedServer) APIExport(w http.ResponseWriter, req *http.Request) {
+	if srv.archiver == nil {
+		writeAPIError(w, http.StatusNotImplemented, "export is not available")
+		return
+	}
+
+	fileName := "youcast-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	if err := srv.archiver.Export(w); err != nil {
+		// the response has been partially sent already, so the client receives a truncated archive
+		log.Println("failed to export instance data:", err)
+	}
+}
+
+// APIListTokens responds with the list of access tokens.
+func (srv *FeedServer) APIListTokens(w http.ResponseWriter, req *http.Request) {
+	tokens, err := srv.auth.Tokens.All()
+	if err != nil {
+		log.Println("failed to fetch access tokens:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch tokens")
+		return
+	}
+
+	res := make([]apiToken, 0, len(tokens))
+	for _, t := range tokens {
+		res = append(res, newAPIToken(t))
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
+
+// APICreateToken issues a new access token.
+func (srv *FeedServer) APICreateToken(w http.ResponseWriter, req *http.Request) {
+	var params struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+
+	if params.Name = strings.TrimSpace(params.Name); params.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing name")
+		return
+	}
+
+	kind, err := ParseTokenKind(params.Kind)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t, err := srv.auth.Tokens.Create(params.Name, kind)
+	if err != nil {
+		log.Println("failed to create access token:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusCreated, newAPIToken(t))
+}
+
+// APIRevokeToken revokes an access token.
+func (srv *FeedServer) APIRevokeToken(w http.ResponseWriter, req *http.Request) {
+	switch err := srv.auth.Tokens.Revoke(req.PathValue("token")); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case ErrTokenNotFound:
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Println("failed to revoke access token:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to revoke token")
+	}
+}
+
+// APIListSubscriptions responds with the list of subscriptions. The list can be narrowed down
+// to a single feed with the feed= parameter.
+func (srv *FeedServer) APIListSubscriptions(w http.ResponseWriter, req *http.Request) {
+	subs, err := srv.subs.All(req.FormValue("feed"))
+	if err != nil {
+		log.Println("failed to fetch subscriptions:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch subscriptions")
+		return
+	}
+
+	res := make([]apiSubscription, 0, len(subs))
+	for _, sub := range subs {
+		res = append(res, newAPISubscription(sub))
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
+
+// APICreateSubscription subscribes a feed to a YouTube channel or playlist, or sets up a mirror of a podcast feed.
+// Duration limits and the maximum age of mirrored episodes are set in seconds.
+func (srv *FeedServer) APICreateSubscription(w http.ResponseWriter, req *http.Request) {
+	var params struct {
+		Feed               string  `json:"feed"`
+		URL                string  `json:"url"`
+		TitlePattern       string  `json:"title_pattern"`
+		MinDuration        float64 `json:"min_duration"`
+		MaxDuration        float64 `json:"max_duration"`
+		ExcludeShorts      bool    `json:"exclude_shorts"`
+		KeepLatest         int     `json:"keep_latest"`
+		MaxAge             float64 `json:"max_age"`
+		TranscodingProfile string  `json:"transcoding_profile"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "malformed request body: "+err.Error())
+		return
+	}
+
+	feed, err := srv.feeds.Get(params.Feed)
+	if err != nil {
+		if err == ErrFeedNotFound {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Println("failed to fetch feed", params.Feed, ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch feed")
+		return
+	}
+
+	kind, sourceID, err := ParseSubscriptionSource(params.URL)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub, err := srv.subs.Create(Subscription{
+		Feed:     feed.Slug,
+		Kind:     kind,
+		SourceID: sourceID,
+		SubscriptionFilter: SubscriptionFilter{
+			TitlePattern:  strings.TrimSpace(params.TitlePattern),
+			MinDuration:   time.Duration(params.MinDuration * float64(time.Second)),
+			MaxDuration:   time.Duration(params.MaxDuration * float64(time.Second)),
+			ExcludeShorts: params.ExcludeShorts,
+		},
+		SubscriptionRetention: SubscriptionRetention{
+			KeepLatest: params.KeepLatest,
+			MaxAge:     time.Duration(params.MaxAge * float64(time.Second)),
+		},
+		TranscodingProfile: params.TranscodingProfile,
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidRequest) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Println("failed to create subscription:", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to create subscription")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/subscriptions/"+sub.ID)
+	writeAPIResponse(w, http.StatusCreated, newAPISubscription(sub))
+}
+
+// APIGetSubscription responds with a subscription.
+func (srv *FeedServer) APIGetSubscription(w http.ResponseWriter, req *http.Request) {
+	sub, err := srv.subs.Get(req.PathValue("id"))
+	if err != nil {
+		writeAPISubscriptionError(w, err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPISubscription(sub))
+}
+
+// APIRemoveSubscription unsubscribes a feed from a channel or playlist. Items that have already been added are kept.
+func (srv *FeedServer) APIRemoveSubscription(w http.ResponseWriter, req *http.Request) {
+	if err := srv.subs.Remove(req.PathValue("id")); err != nil {
+		writeAPISubscriptionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APICheckSubscription schedules a subscription to be checked for new videos right away.
+func (srv *FeedServer) APICheckSubscription(w http.ResponseWriter, req *http.Request) {
+	if err := srv.subs.Check(req.PathValue("id")); err != nil {
+		writeAPISubscriptionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (srv *FeedServer) apiFeed(w http.ResponseWriter, req *http.Request) (PodcastFeed, bool) {
+	feed, err := srv.feeds.Get(req.PathValue("feed"))
+	if err != nil {
+		if err == ErrFeedNotFound {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+			return feed, false
+		}
+
+		log.Println("failed to fetch feed", req.PathValue("feed"), ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch feed")
+
+		return feed, false
+	}
+
+	return feed, true
+}
+
+func (srv *FeedServer) apiFeedService(w http.ResponseWriter, req *http.Request) (*FeedService, bool) {
+	svc, err := srv.feeds.Service(req.PathValue("feed"))
+	if err != nil {
+		if err == ErrFeedNotFound {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+			return nil, false
+		}
+
+		log.Println("failed to fetch feed", req.PathValue("feed"), ":", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to fetch feed")
+
+		return nil, false
+	}
+
+	return svc, true
+}
+
+func writeAPIItemError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrItemNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrCannotRetry), errors.Is(err, ErrNotInProgress):
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	log.Println("failed to handle podcast item request:", err)
+	writeAPIError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func writeAPISubscriptionError(w http.ResponseWriter, err error) {
+	if err == ErrSubscriptionNotFound {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	log.Println("failed to handle subscription request:", err)
+	writeAPIError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(w, status, apiError{msg})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("failed to write API response:", err)
+	}
+}
+
+func reqBaseURL(req *http.Request) string {
+	return reqScheme(req) + "://" + req.Host
+}
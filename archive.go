@@ -0,0 +1,458 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Names of archive entries. The manifest goes first, so that the import knows which files to extract
+// while reading the archive.
+const (
+	archiveManifestName = "manifest.json"
+	archiveDBName       = "youcast.db"
+	archiveMediaDir     = "media/"
+)
+
+// archiveManifest lists feeds along with their items and subscriptions stored in the archive.
+type archiveManifest struct {
+	Version   string
+	CreatedAt time.Time
+	Feeds     []archiveFeed
+}
+
+type archiveFeed struct {
+	PodcastFeed
+	Items         []PodcastItem
+	Subscriptions []Subscription
+}
+
+// ImportStats summarizes the result of an archive import.
+type ImportStats struct {
+	Feeds, Items, Subscriptions, Files int
+	// Skipped is the number of items that already exist in the instance.
+	Skipped int
+}
+
+// Archiver exports the instance data into a .tar.gz archive and imports it into another instance.
+type Archiver struct {
+	db          *bolt.DB
+	feeds       *FeedRegistry
+	subs        *SubscriptionStore
+	storagePath string
+}
+
+// NewArchiver returns a new instance of Archiver.
+func NewArchiver(db *bolt.DB, feeds *FeedRegistry, subs *SubscriptionStore, storagePath string) *Archiver {
+	return &Archiver{
+		db:          db,
+		feeds:       feeds,
+		subs:        subs,
+		storagePath: storagePath,
+	}
+}
+
+// Export writes an archive containing the manifest with all feeds, items and subscriptions in JSON, a snapshot
+// of the BoltDB database, and media, artwork and transcript files of the items. The manifest and the snapshot
+// are taken within the same read transaction, so they match each other. Items stored in SQLite are read
+// separately and may include changes made after the snapshot has been taken.
+func (a *Archiver) Export(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	var files []string
+	err := a.db.View(func(tx *bolt.Tx) error {
+		manifest, fileNames, err := a.manifest(tx)
+		if err != nil {
+			return err
+		}
+
+		files = fileNames
+
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal manifest: %w", err)
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.CreatedAt}); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Name: archiveDBName, Mode: 0600, Size: tx.Size(), ModTime: manifest.CreatedAt}); err != nil {
+			return fmt.Errorf("failed to write database snapshot: %w", err)
+		}
+
+		if _, err := tx.WriteTo(tw); err != nil {
+			return fmt.Errorf("failed to write database snapshot: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err := addArchiveFile(tw, path.Join(a.storagePath, name), archiveMediaDir+name); err != nil {
+			if os.IsNotExist(err) {
+				log.Printf("skipping missing file %s", name)
+				continue
+			}
+
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return zw.Close()
+}
+
+// manifest returns the archive manifest read within the transaction along with the names of files stored
+// in the storage directory that should be added to the archive.
+func (a *Archiver) manifest(tx *bolt.Tx) (archiveManifest, []string, error) {
+	manifest := archiveManifest{
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	feeds, err := readFeeds(tx)
+	if err != nil {
+		return manifest, nil, fmt.Errorf("failed to fetch feeds: %w", err)
+	}
+
+	var (
+		files []string
+		seen  = make(map[string]struct{})
+	)
+
+	addFile := func(name string) {
+		if !isStorageFileName(name) {
+			return
+		}
+
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			files = append(files, name)
+		}
+	}
+
+	for _, feed := range feeds {
+		f := archiveFeed{PodcastFeed: feed}
+
+		st := a.feeds.itemStorage(feed.Slug)
+		if bst, ok := st.(*boltStorage); ok {
+			f.Items, err = bst.items(tx)
+		} else {
+			f.Items, err = st.Items()
+		}
+
+		if err != nil {
+			return manifest, nil, fmt.Errorf("failed to fetch items of %s: %w", feed.Slug, err)
+		}
+
+		if f.Subscriptions, err = readSubscriptions(tx, feed.Slug); err != nil {
+			return manifest, nil, fmt.Errorf("failed to fetch subscriptions of %s: %w", feed.Slug, err)
+		}
+
+		for _, item := range f.Items {
+			addFile(item.ImageFileName)
+
+			// files of items that are being processed are incomplete
+			if !item.Playable() {
+				continue
+			}
+
+			addFile(item.FileName)
+
+			if item.TranscriptFileName != "" {
+				stem := strings.TrimSuffix(item.TranscriptFileName, path.Ext(item.TranscriptFileName))
+				for _, tf := range TranscriptFormats {
+					addFile(stem + tf.Ext)
+				}
+			}
+		}
+
+		manifest.Feeds = append(manifest.Feeds, f)
+	}
+
+	return manifest, files, nil
+}
+
+// addArchiveFile writes the file at filePath into the archive under given name.
+func addArchiveFile(tw *tar.Writer, filePath, name string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tw, f, fi.Size())
+
+	return err
+}
+
+// Import merges the archive into the instance. Missing feeds and subscriptions are created, and items that don't
+// exist in the instance are added along with their files. Items are matched by their IDs, and existing ones are
+// left intact. Items that have not been downloaded before the export are imported as cancelled, so that they can
+// be retried.
+func (a *Archiver) Import(r io.Reader) (ImportStats, error) {
+	var stats ImportStats
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return stats, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if hdr.Name != archiveManifestName {
+		return stats, fmt.Errorf("malformed archive: expected %s, got %s", archiveManifestName, hdr.Name)
+	}
+
+	var manifest archiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return stats, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	// imported file names are later used to remove item files, so they must not point outside the storage directory
+	for _, f := range manifest.Feeds {
+		for _, item := range f.Items {
+			for _, name := range []string{item.FileName, item.ImageFileName, item.TranscriptFileName} {
+				if name != "" && !isStorageFileName(name) {
+					return stats, fmt.Errorf("malformed archive: item %s of %s refers to %q outside of the storage directory", item.ID(), f.Slug, name)
+				}
+			}
+		}
+	}
+
+	type pendingItem struct {
+		svc  *FeedService
+		item PodcastItem
+	}
+
+	var (
+		pending []pendingItem
+		wanted  = make(map[string]struct{})
+	)
+
+	for _, f := range manifest.Feeds {
+		switch _, err := a.feeds.Get(f.Slug); err {
+		case nil:
+		case ErrFeedNotFound:
+			if err := a.feeds.Create(f.PodcastFeed); err != nil {
+				return stats, fmt.Errorf("failed to create feed %s: %w", f.Slug, err)
+			}
+
+			stats.Feeds++
+		default:
+			return stats, fmt.Errorf("failed to fetch feed %s: %w", f.Slug, err)
+		}
+
+		svc, err := a.feeds.Service(f.Slug)
+		if err != nil {
+			return stats, fmt.Errorf("failed to fetch feed %s: %w", f.Slug, err)
+		}
+
+		for _, item := range f.Items {
+			switch _, err := svc.Item(item.ID()); err {
+			case nil:
+				stats.Skipped++
+				continue
+			case ErrItemNotFound:
+			default:
+				return stats, fmt.Errorf("failed to fetch item %s of %s: %w", item.ID(), f.Slug, err)
+			}
+
+			pending = append(pending, pendingItem{svc, item})
+
+			for _, name := range []string{item.FileName, item.ImageFileName} {
+				wanted[name] = struct{}{}
+			}
+
+			if item.TranscriptFileName != "" {
+				stem := strings.TrimSuffix(item.TranscriptFileName, path.Ext(item.TranscriptFileName))
+				for _, tf := range TranscriptFormats {
+					wanted[stem+tf.Ext] = struct{}{}
+				}
+			}
+		}
+	}
+
+	// extract files before adding items, so that they are never served without media
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return stats, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name, ok := strings.CutPrefix(hdr.Name, archiveMediaDir)
+		if !ok || !isStorageFileName(name) || hdr.Typeflag != tar.TypeReg {
+			continue // the database snapshot is only used to restore the instance manually
+		}
+
+		if _, ok := wanted[name]; !ok {
+			continue
+		}
+
+		extracted, err := extractArchiveFile(tr, path.Join(a.storagePath, name))
+		if err != nil {
+			return stats, fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+
+		if extracted {
+			stats.Files++
+		}
+	}
+
+	for _, p := range pending {
+		if err := p.svc.ImportItem(p.item); err != nil {
+			return stats, fmt.Errorf("failed to import item %s into %s: %w", p.item.ID(), p.svc.Feed(), err)
+		}
+
+		stats.Items++
+	}
+
+	for _, f := range manifest.Feeds {
+		for _, sub := range f.Subscriptions {
+			imported, err := a.subs.Import(sub)
+			if err != nil {
+				return stats, fmt.Errorf("failed to import subscription %s: %w", sub.ID, err)
+			}
+
+			if imported {
+				stats.Subscriptions++
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// isStorageFileName returns true if the name refers to a file located right in the storage directory.
+func isStorageFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == path.Base(name)
+}
+
+// extractArchiveFile writes the contents of the current archive entry to filePath unless the file already exists.
+func extractArchiveFile(r io.Reader, filePath string) (bool, error) {
+	if _, err := os.Stat(filePath); err == nil {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(filePath), "."+path.Base(filePath)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return false, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(tmp.Name(), filePath)
+}
+
+// runArchiveCommand runs the export or import command. The archive is written to or read from the file passed
+// as the first argument, stdout and stdin are used if it's missing or "-".
+func runArchiveCommand(cmd string, cmdArgs []string, a *Archiver) error {
+	fileName := "-"
+	if len(cmdArgs) > 0 {
+		fileName = cmdArgs[0]
+	}
+
+	switch cmd {
+	case "export":
+		w := os.Stdout
+		if fileName != "-" {
+			f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", fileName, err)
+			}
+			defer f.Close()
+
+			w = f
+		}
+
+		if err := a.Export(w); err != nil {
+			return err
+		}
+
+		if w != os.Stdout {
+			if err := w.Close(); err != nil {
+				return fmt.Errorf("failed to write %s: %w", fileName, err)
+			}
+		}
+
+		log.Println("exported instance data to", fileName)
+
+		return nil
+	case "import":
+		r := os.Stdin
+		if fileName != "-" {
+			f, err := os.Open(fileName)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", fileName, err)
+			}
+			defer f.Close()
+
+			r = f
+		}
+
+		stats, err := a.Import(r)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("imported %d feed(s), %d item(s), %d subscription(s) and %d file(s), skipped %d existing item(s)",
+			stats.Feeds, stats.Items, stats.Subscriptions, stats.Files, stats.Skipped)
+
+		return nil
+	default:
+		return errors.New("unknown command " + cmd)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type testInstance struct {
+	*Archiver
+	backend     storageBackend
+	storagePath string
+}
+
+func newTestInstance(t *testing.T) testInstance {
+	t.Helper()
+
+	db := openTestBoltDB(t)
+	backend := boltBackend{db}
+	storagePath := t.TempDir()
+
+	feeds := NewFeedRegistry(db, backend, storagePath, NewDownloadJobQueue(backend.Jobs()))
+
+	return testInstance{
+		Archiver:    NewArchiver(db, feeds, NewSubscriptionStore(db), storagePath),
+		backend:     backend,
+		storagePath: storagePath,
+	}
+}
+
+// addItem stores the item into the feed along with its files.
+func (inst testInstance) addItem(t *testing.T, feed string, item PodcastItem, files ...string) {
+	t.Helper()
+
+	if err := inst.backend.Items(feed).Add(item); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(inst.storagePath, name), []byte("contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (inst testInstance) files(t *testing.T) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(inst.storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestArchiver_ExportImport(t *testing.T) {
+	addedAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	ready := PodcastItem{
+		Description:        Description{Title: "Ready"},
+		Type:               UploadedItem,
+		FileName:           "ready.mp3",
+		ImageFileName:      "ready.jpg",
+		TranscriptFileName: "ready.vtt",
+		AddedAt:            addedAt,
+		Status:             ItemReady,
+	}
+	downloading := PodcastItem{
+		Description:   Description{Title: "Downloading"},
+		Type:          YouTubeItem,
+		OriginalURL:   "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		FileName:      "downloading.m4a",
+		ImageFileName: "downloading.jpg",
+		AddedAt:       addedAt.Add(time.Minute),
+		Status:        ItemDownloaded,
+	}
+	failed := PodcastItem{
+		Description: Description{Title: "Failed"},
+		Type:        UploadedItem,
+		AddedAt:     addedAt.Add(2 * time.Minute),
+		Status:      ItemDownloadFailed,
+		Error:       "no such file",
+	}
+	talk := PodcastItem{
+		Description: Description{Title: "Talk"},
+		Type:        UploadedItem,
+		FileName:    "talk.mp3",
+		AddedAt:     addedAt,
+		Status:      ItemReady,
+	}
+
+	src := newTestInstance(t)
+
+	for _, feed := range []PodcastFeed{
+		{Slug: "news", PodcastMetadata: PodcastMetadata{Title: "News"}},
+		{Slug: "talks", PodcastMetadata: PodcastMetadata{Title: "Talks"}},
+	} {
+		if err := src.feeds.Create(feed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src.addItem(t, "news", ready, "ready.mp3", "ready.jpg", "ready.vtt", "ready.srt", "ready.txt")
+	src.addItem(t, "news", downloading, "downloading.m4a", "downloading.jpg")
+	src.addItem(t, "news", failed)
+	src.addItem(t, "talks", talk, "talk.mp3")
+
+	sub, err := src.subs.Create(Subscription{Feed: "talks", Kind: ChannelSubscription, SourceID: "UCuAXFkgsw1L7xaCfnd5JJOw", Title: "Channel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := src.Export(&archive); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+
+	// the destination instance already has the news feed with an item that has the same ID as the ready one
+	dst := newTestInstance(t)
+
+	if err := dst.feeds.Create(PodcastFeed{Slug: "news", PodcastMetadata: PodcastMetadata{Title: "Local news"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	existing := ready
+	existing.Title, existing.FileName, existing.ImageFileName, existing.TranscriptFileName = "Existing", "existing.mp3", "", ""
+	dst.addItem(t, "news", existing, "existing.mp3")
+
+	stats, err := dst.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if expected := (ImportStats{Feeds: 1, Items: 3, Subscriptions: 1, Files: 2, Skipped: 1}); stats != expected {
+		t.Errorf("expected import stats %+v, got %+v", expected, stats)
+	}
+
+	// media of items that were being processed is not exported, so only artwork is restored
+	if expected := []string{"downloading.jpg", "existing.mp3", "talk.mp3"}; !reflect.DeepEqual(dst.files(t), expected) {
+		t.Errorf("expected files %q, got %q", expected, dst.files(t))
+	}
+
+	if feed, err := dst.feeds.Get("news"); err != nil || feed.Title != "Local news" {
+		t.Errorf("expected the existing feed to be left intact, got %+v (%v)", feed, err)
+	}
+
+	if feed, err := dst.feeds.Get("talks"); err != nil || feed.Title != "Talks" {
+		t.Errorf("expected the missing feed to be created, got %+v (%v)", feed, err)
+	}
+
+	items, err := dst.backend.Items("news").Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type itemState struct {
+		ID, Title string
+		Status    Status
+	}
+
+	var actual []itemState
+	for _, item := range items {
+		actual = append(actual, itemState{item.ID(), item.Title, item.Status})
+	}
+
+	expected := []itemState{
+		{failed.ID(), "Failed", ItemDownloadFailed},
+		{downloading.ID(), "Downloading", ItemCancelled},
+		{ready.ID(), "Existing", ItemReady},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected news items %+v, got %+v", expected, actual)
+	}
+
+	if item, err := dst.backend.Items("talks").Item(talk.ID()); err != nil || item.FileName != "talk.mp3" || !item.Playable() {
+		t.Errorf("expected the talk to be imported, got %+v (%v)", item, err)
+	}
+
+	imported, err := dst.subs.Get(sub.ID)
+	if err != nil {
+		t.Fatalf("expected the subscription to be imported: %s", err)
+	}
+
+	if imported.Feed != "talks" || imported.SourceID != sub.SourceID {
+		t.Errorf("unexpected subscription %+v", imported)
+	}
+
+	// importing the same archive again skips everything
+	stats, err = dst.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	if expected := (ImportStats{Skipped: 4}); stats != expected {
+		t.Errorf("expected import stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestArchiver_Import_UnsafeFileNames(t *testing.T) {
+	for name, item := range map[string]PodcastItem{
+		"media":      {FileName: "../youcast.db"},
+		"image":      {ImageFileName: "covers/../../cover.jpg"},
+		"transcript": {TranscriptFileName: ".."},
+		"absolute":   {FileName: "/etc/passwd"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			item.Type, item.Status, item.AddedAt = UploadedItem, ItemReady, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+			data, err := json.Marshal(archiveManifest{
+				Feeds: []archiveFeed{{PodcastFeed: PodcastFeed{Slug: "news"}, Items: []PodcastItem{item}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var archive bytes.Buffer
+
+			zw := gzip.NewWriter(&archive)
+			tw := tar.NewWriter(zw)
+			if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0644, Size: int64(len(data))}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(data)
+			tw.Close()
+			zw.Close()
+
+			inst := newTestInstance(t)
+
+			if _, err := inst.Import(&archive); err == nil {
+				t.Errorf("expected an error")
+			}
+
+			if _, err := inst.feeds.Get("news"); err != ErrFeedNotFound {
+				t.Errorf("expected nothing to be imported, got %v", err)
+			}
+		})
+	}
+}
@@ -5,7 +5,7 @@ import (
 )
 
 var (
-	//go:embed index.html.tmpl
+	//go:embed *.html.tmpl
 	Templates embed.FS
 
 	//go:embed icon.png
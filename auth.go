@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	sessionCookieName = "youcast_session"
+	sessionTTL        = 30 * 24 * time.Hour
+)
+
+// ErrTokenNotFound is returned when an access token is not found in the storage.
+var ErrTokenNotFound = errors.New("no such token")
+
+// TokenKind is a kind of an access token.
+type TokenKind uint8
+
+// Supported access token kinds.
+const (
+	// SubscriberToken grants read-only access to feeds and downloaded files. These tokens
+	// are meant to be embedded into the feed URL used by podcast apps.
+	SubscriberToken TokenKind = iota + 1
+	// APIToken grants full access to the instance.
+	APIToken
+)
+
+// String returns a string representation of the token kind.
+func (k TokenKind) String() string {
+	switch k {
+	case SubscriberToken:
+		return "subscriber"
+	case APIToken:
+		return "api"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTokenKind parses a string representation of the token kind.
+func ParseTokenKind(s string) (TokenKind, error) {
+	switch strings.ToLower(s) {
+	case "subscriber", "":
+		return SubscriberToken, nil
+	case "api":
+		return APIToken, nil
+	default:
+		return 0, fmt.Errorf("unsupported token kind %q", s)
+	}
+}
+
+// AccessToken is a token that grants access to the instance.
+type AccessToken struct {
+	Token     string
+	Name      string
+	Kind      TokenKind
+	CreatedAt time.Time
+}
+
+type boltToken struct {
+	Name      string    `json:",omitempty"`
+	Kind      TokenKind `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// TokenStore keeps access tokens in BoltDB.
+type TokenStore struct {
+	db *bolt.DB
+}
+
+// NewTokenStore returns a new instance of TokenStore.
+func NewTokenStore(db *bolt.DB) *TokenStore {
+	return &TokenStore{db: db}
+}
+
+// Create generates and stores a new access token.
+func (s *TokenStore) Create(name string, kind TokenKind) (AccessToken, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return AccessToken{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := AccessToken{
+		Token:     hex.EncodeToString(b),
+		Name:      name,
+		Kind:      kind,
+		CreatedAt: time.Now(),
+	}
+
+	data, err := json.Marshal(boltToken{token.Name, token.Kind, token.CreatedAt})
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	return token, s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("tokens"))
+		if err != nil {
+			return fmt.Errorf("failed to open tokens bucket: %w", err)
+		}
+
+		return b.Put([]byte(token.Token), data)
+	})
+}
+
+// Get returns an access token by its value.
+func (s *TokenStore) Get(token string) (AccessToken, error) {
+	t := AccessToken{Token: token}
+
+	return t, s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tokens"))
+		if b == nil {
+			return ErrTokenNotFound
+		}
+
+		v := b.Get([]byte(token))
+		if v == nil {
+			return ErrTokenNotFound
+		}
+
+		var bt boltToken
+		if err := json.Unmarshal(v, &bt); err != nil {
+			return fmt.Errorf("failed to unmarshal token: %w", err)
+		}
+
+		t.Name, t.Kind, t.CreatedAt = bt.Name, bt.Kind, bt.CreatedAt
+
+		return nil
+	})
+}
+
+// All returns all stored access tokens.
+func (s *TokenStore) All() ([]AccessToken, error) {
+	var tokens []AccessToken
+
+	return tokens, s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tokens"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var bt boltToken
+			if err := json.Unmarshal(v, &bt); err != nil {
+				return fmt.Errorf("failed to unmarshal token: %w", err)
+			}
+
+			tokens = append(tokens, AccessToken{string(k), bt.Name, bt.Kind, bt.CreatedAt})
+
+			return nil
+		})
+	})
+}
+
+// Revoke removes an access token.
+func (s *TokenStore) Revoke(token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tokens"))
+		if b == nil || b.Get([]byte(token)) == nil {
+			return ErrTokenNotFound
+		}
+
+		return b.Delete([]byte(token))
+	})
+}
+
+type accessLevel uint8
+
+const (
+	publicAccess accessLevel = iota
+	readAccess
+	adminAccess
+)
+
+// Authenticator authenticates requests using the admin password, session cookies and access tokens.
+type Authenticator struct {
+	Tokens *TokenStore
+
+	password []byte
+	secret   []byte
+}
+
+// NewAuthenticator returns a new instance of Authenticator. If the password is empty, authentication is disabled.
+func NewAuthenticator(db *bolt.DB, password string) (*Authenticator, error) {
+	a := &Authenticator{
+		Tokens: NewTokenStore(db),
+	}
+
+	if password == "" {
+		return a, nil
+	}
+
+	h := sha256.Sum256([]byte(password))
+	a.password = h[:]
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("auth"))
+		if err != nil {
+			return fmt.Errorf("failed to open auth bucket: %w", err)
+		}
+
+		if v := b.Get([]byte("secret")); v != nil {
+			a.secret = append([]byte(nil), v...)
+			return nil
+		}
+
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return fmt.Errorf("failed to generate session secret: %w", err)
+		}
+
+		return b.Put([]byte("secret"), a.secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Enabled returns true if the authentication is enabled.
+func (a *Authenticator) Enabled() bool {
+	return a.password != nil
+}
+
+// Middleware returns a middleware that requires requests to be authenticated. Feeds and downloaded
+// files can be accessed with a subscriber token passed as token= parameter. All other endpoints
+// require either a session cookie obtained via /login or an API token passed in the Authorization: Bearer
+// header. API tokens are never accepted as token= parameter, since the query string is copied into feed
+// links and ends up in access logs.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			a.HandleLogin(w, req)
+			return
+		case "/logout":
+			a.HandleLogout(w, req)
+			return
+		}
+
+		if !a.Enabled() {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		required := requiredAccess(req)
+		if required == publicAccess || a.authenticate(req) >= required {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/api/"):
+			writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		case req.Method == http.MethodGet && required == adminAccess:
+			http.Redirect(w, req, "/login?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+		default:
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	})
+}
+
+// HandleLogin serves the login form and starts a new session once the correct password is submitted.
+func (a *Authenticator) HandleLogin(w http.ResponseWriter, req *http.Request) {
+	next := req.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+
+	if !a.Enabled() {
+		http.Redirect(w, req, next, http.StatusSeeOther)
+		return
+	}
+
+	data := struct {
+		Next  string
+		Error string
+	}{Next: next}
+
+	if req.Method == http.MethodPost {
+		h := sha256.Sum256([]byte(req.FormValue("password")))
+		if subtle.ConstantTimeCompare(h[:], a.password) == 1 {
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookieName,
+				Value:    a.newSession(time.Now().Add(sessionTTL)),
+				Path:     "/",
+				MaxAge:   int(sessionTTL / time.Second),
+				HttpOnly: true,
+				Secure:   req.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, req, next, http.StatusSeeOther)
+
+			return
+		}
+
+		log.Printf("failed login attempt from %s", req.RemoteAddr)
+		data.Error = "Wrong password"
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	tmpl := Templates
+	if args.DevMode {
+		tmpl = ParseTemplates(os.DirFS("./assets"))
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "login.html.tmpl", data); err != nil {
+		log.Println("failed to render login form:", err)
+	}
+}
+
+// HandleLogout terminates the current session.
+func (a *Authenticator) HandleLogout(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, req, "/login", http.StatusSeeOther)
+}
+
+// authenticate returns the access level granted by credentials provided with the request. Tokens passed
+// as token= parameter only grant access to feeds and downloaded files.
+func (a *Authenticator) authenticate(req *http.Request) accessLevel {
+	if c, err := req.Cookie(sessionCookieName); err == nil && a.validSession(c.Value) {
+		return adminAccess
+	}
+
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		switch a.tokenKind(strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))) {
+		case APIToken:
+			return adminAccess
+		case SubscriberToken:
+			return readAccess
+		}
+	}
+
+	if a.tokenKind(req.URL.Query().Get("token")) == SubscriberToken {
+		return readAccess
+	}
+
+	return publicAccess
+}
+
+// tokenKind returns the kind of the access token or zero if there is no such token.
+func (a *Authenticator) tokenKind(token string) TokenKind {
+	if token == "" {
+		return 0
+	}
+
+	t, err := a.Tokens.Get(token)
+	if err != nil {
+		if err != ErrTokenNotFound {
+			log.Printf("failed to fetch access token: %s", err)
+		}
+
+		return 0
+	}
+
+	return t.Kind
+}
+
+// newSession returns a session cookie value that is valid until the given time. Session cookies are signed
+// with a key derived from the password, so changing the password terminates all sessions.
+func (a *Authenticator) newSession(expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return exp + "." + hex.EncodeToString(a.sign(exp))
+}
+
+func (a *Authenticator) validSession(s string) bool {
+	ind := strings.IndexByte(s, '.')
+	if ind < 0 {
+		return false
+	}
+
+	sig, err := hex.DecodeString(s[ind+1:])
+	if err != nil || !hmac.Equal(sig, a.sign(s[:ind])) {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(s[:ind], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Now().Before(time.Unix(exp, 0))
+}
+
+func (a *Authenticator) sign(s string) []byte {
+	mac := hmac.New(sha256.New, append(append([]byte(nil), a.secret...), a.password...))
+	mac.Write([]byte(s))
+
+	return mac.Sum(nil)
+}
+
+// requiredAccess returns the access level required to perform the request.
+func requiredAccess(req *http.Request) accessLevel {
+	switch p := req.URL.Path; {
+	case p == "/favicon.ico", p == "/style.css", p == "/script.js":
+		return publicAccess
+	case p == "/feed", p == "/feed.json", p == "/feeds", p == "/feeds.opml", strings.HasPrefix(p, "/downloads/"), strings.HasPrefix(p, "/chapters/"),
+		strings.HasPrefix(p, "/transcripts/"):
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return readAccess
+		}
+	case strings.HasPrefix(p, "/feed/"):
+		if _, itemID := parseItemPath(strings.TrimPrefix(p, "/feed/")); itemID == "" && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+			return readAccess
+		}
+	}
+
+	return adminAccess
+}
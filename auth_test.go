@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func newTestAuthenticator(t *testing.T, password string) *Authenticator {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	a, err := NewAuthenticator(db, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestAuthenticator_ValidSession(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+	session := a.newSession(time.Now().Add(time.Hour))
+	exp, sig, _ := strings.Cut(session, ".")
+
+	for name, tc := range map[string]struct {
+		Session  string
+		Expected bool
+	}{
+		"valid":               {session, true},
+		"expired":             {a.newSession(time.Now().Add(-time.Second)), false},
+		"extended expiration": {strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10) + "." + sig, false},
+		"tampered signature":  {exp + "." + strings.Repeat("0", len(sig)), false},
+		"malformed signature": {exp + ".xyz", false},
+		"no signature":        {exp, false},
+		"malformed expiry":    {"abc." + sig, false},
+		"empty":               {"", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := a.validSession(tc.Session); actual != tc.Expected {
+				t.Errorf("validSession(%q) = %t, expected %t", tc.Session, actual, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_ValidSession_KeyRotation(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+	session := a.newSession(time.Now().Add(time.Hour))
+
+	for name, other := range map[string]*Authenticator{
+		"another password": {password: []byte("another password"), secret: a.secret},
+		"another secret":   {password: a.password, secret: []byte("another secret")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if other.validSession(session) {
+				t.Errorf("session signed by another key is accepted")
+			}
+		})
+	}
+}
+
+func TestRequiredAccess(t *testing.T) {
+	for _, tc := range []struct {
+		Method, Path string
+		Expected     accessLevel
+	}{
+		{http.MethodGet, "/favicon.ico", publicAccess},
+		{http.MethodGet, "/style.css", publicAccess},
+		{http.MethodGet, "/script.js", publicAccess},
+		{http.MethodGet, "/feed", readAccess},
+		{http.MethodHead, "/feed", readAccess},
+		{http.MethodPost, "/feed", adminAccess},
+		{http.MethodGet, "/feed.json", readAccess},
+		{http.MethodGet, "/feeds", readAccess},
+		{http.MethodGet, "/feeds.opml", readAccess},
+		{http.MethodPost, "/feeds", adminAccess},
+		{http.MethodGet, "/downloads/abc.mp3", readAccess},
+		{http.MethodHead, "/downloads/abc.mp3", readAccess},
+		{http.MethodDelete, "/downloads/abc.mp3", adminAccess},
+		{http.MethodGet, "/", adminAccess},
+		{http.MethodGet, "/feeds/new", adminAccess},
+		{http.MethodGet, "/tokens", adminAccess},
+		{http.MethodGet, "/api/feeds", adminAccess},
+		{http.MethodGet, "/downloads", adminAccess},
+		{http.MethodGet, "/feed.xml", adminAccess},
+	} {
+		t.Run(tc.Method+" "+tc.Path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.Method, tc.Path, nil)
+			if actual := requiredAccess(req); actual != tc.Expected {
+				t.Errorf("requiredAccess(%s %s) = %d, expected %d", tc.Method, tc.Path, actual, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_HandleLogin_Redirect(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+
+	for _, tc := range []struct {
+		Next     string
+		Expected string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/feeds?page=2", "/feeds?page=2"},
+		{"//evil.example.com/", "/"},
+		{"/\\evil.example.com/", "/"},
+		{"https://evil.example.com/", "/"},
+		{"evil.example.com", "/"},
+		{"javascript:alert(1)", "/"},
+	} {
+		t.Run(tc.Next, func(t *testing.T) {
+			form := url.Values{"password": {"secret"}, "next": {tc.Next}}
+
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rec := httptest.NewRecorder()
+			a.HandleLogin(rec, req)
+
+			if rec.Code != http.StatusSeeOther {
+				t.Fatalf("expected %d, got %d", http.StatusSeeOther, rec.Code)
+			}
+
+			if actual := rec.Header().Get("Location"); actual != tc.Expected {
+				t.Errorf("expected redirect to %q, got %q", tc.Expected, actual)
+			}
+
+			if len(rec.Result().Cookies()) == 0 {
+				t.Errorf("expected a session cookie to be set")
+			}
+		})
+	}
+}
+
+func TestAuthenticator_HandleLogin_WrongPassword(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+	form := url.Values{"password": {"wrong"}, "next": {"/"}}
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	a.HandleLogin(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("expected no session cookie to be set")
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+
+	subscriber, err := a.Tokens.Create("podcast app", SubscriberToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := a.Tokens.Create("script", APIToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		Query, Authorization, Session string
+		Expected                      accessLevel
+	}{
+		"no credentials":          {Expected: publicAccess},
+		"session":                 {Session: a.newSession(time.Now().Add(time.Hour)), Expected: adminAccess},
+		"expired session":         {Session: a.newSession(time.Now().Add(-time.Hour)), Expected: publicAccess},
+		"subscriber token query":  {Query: subscriber.Token, Expected: readAccess},
+		"subscriber token header": {Authorization: "Bearer " + subscriber.Token, Expected: readAccess},
+		"api token query":         {Query: api.Token, Expected: publicAccess},
+		"api token query and subscriber token header":     {Query: api.Token, Authorization: "Bearer " + subscriber.Token, Expected: readAccess},
+		"unknown token header and subscriber token query": {Query: subscriber.Token, Authorization: "Bearer unknown", Expected: readAccess},
+		"api token header":         {Authorization: "Bearer " + api.Token, Expected: adminAccess},
+		"unknown token":            {Query: "unknown", Expected: publicAccess},
+		"non-bearer authorization": {Authorization: "Basic " + api.Token, Expected: publicAccess},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/feed?token="+url.QueryEscape(tc.Query), nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+
+			if tc.Session != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.Session})
+			}
+
+			if actual := a.authenticate(req); actual != tc.Expected {
+				t.Errorf("authenticate() = %d, expected %d", actual, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+
+	subscriber, err := a.Tokens.Create("podcast app", SubscriberToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := a.Tokens.Create("script", APIToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for name, tc := range map[string]struct {
+		Method, Target, Authorization string
+		Expected                      int
+	}{
+		"public asset":                {http.MethodGet, "/style.css", "", http.StatusNoContent},
+		"feed with subscriber token":  {http.MethodGet, "/feed?token=" + subscriber.Token, "", http.StatusNoContent},
+		"feed with api token query":   {http.MethodGet, "/feed?token=" + api.Token, "", http.StatusUnauthorized},
+		"feed with api token header":  {http.MethodGet, "/feed", "Bearer " + api.Token, http.StatusNoContent},
+		"api with api token header":   {http.MethodGet, "/api/v1/feeds", "Bearer " + api.Token, http.StatusNoContent},
+		"api with api token query":    {http.MethodGet, "/api/v1/feeds?token=" + api.Token, "", http.StatusUnauthorized},
+		"api with subscriber token":   {http.MethodGet, "/api/v1/feeds", "Bearer " + subscriber.Token, http.StatusUnauthorized},
+		"ui with api token query":     {http.MethodGet, "/?token=" + api.Token, "", http.StatusSeeOther},
+		"item upload with api token":  {http.MethodPost, "/feed", "Bearer " + api.Token, http.StatusNoContent},
+		"item upload with subscriber": {http.MethodPost, "/feed?token=" + subscriber.Token, "", http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.Method, tc.Target, nil)
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.Expected {
+				t.Errorf("expected %d, got %d", tc.Expected, rec.Code)
+			}
+		})
+	}
+}
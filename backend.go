@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// storageBackend provides storage for podcast items and download jobs.
+type storageBackend interface {
+	// Items returns the item store of the feed with given slug.
+	Items(slug string) itemStore
+	Jobs() jobStore
+	Close() error
+}
+
+// openStorageBackend opens the storage backend referenced by the DSN. An empty DSN or "bolt:" selects
+// the BoltDB database the rest of data is stored in, and "sqlite:<path>" selects an SQLite database file.
+// Items and download jobs are copied from BoltDB into an empty SQLite database.
+func openStorageBackend(dsn string, db *bolt.DB) (storageBackend, error) {
+	scheme, path, _ := strings.Cut(dsn, ":")
+
+	switch scheme {
+	case "", "bolt":
+		if path != "" {
+			return nil, fmt.Errorf("bolt storage uses the database set with -db, got %q", dsn)
+		}
+
+		return boltBackend{db}, nil
+	case "sqlite", "sqlite3":
+		b, err := openSQLiteBackend(strings.TrimPrefix(path, "//"))
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := b.copyBoltData(db)
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to copy items from BoltDB: %w", err)
+		}
+
+		if n > 0 {
+			log.Printf("copied %d items from BoltDB into the SQLite storage", n)
+		}
+
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", scheme)
+	}
+}
+
+// boltBackend stores items of each feed in a separate bucket, and download jobs in the "downloads" bucket.
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// Items returns the item store of the feed with given slug.
+func (b boltBackend) Items(slug string) itemStore {
+	return newBoltStorage(feedBucket(slug), b.db)
+}
+
+// Jobs returns the download job store.
+func (b boltBackend) Jobs() jobStore {
+	return newBoltJobStore(b.db)
+}
+
+// Close is a no-op, the database is shared with other stores and closed separately.
+func (boltBackend) Close() error {
+	return nil
+}
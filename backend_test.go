@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testBackends returns constructors of all supported storage backends backed by temporary databases.
+func testBackends() map[string]func(*testing.T) storageBackend {
+	return map[string]func(*testing.T) storageBackend{
+		"bolt": func(t *testing.T) storageBackend {
+			db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+
+			return boltBackend{db}
+		},
+		"sqlite": func(t *testing.T) storageBackend {
+			b, err := openSQLiteBackend(filepath.Join(t.TempDir(), "test.sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+
+			return b
+		},
+	}
+}
+
+// addTestItems adds n items to the store, one per minute, and returns their IDs in the order they were added.
+func addTestItems(t *testing.T, st itemStore, n int) []string {
+	t.Helper()
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	var ids []string
+	for i := 0; i < n; i++ {
+		item := NewPodcastItem(Metadata{Type: UploadedItem, Title: "Episode"}, start.Add(time.Duration(i)*time.Minute))
+		if err := st.Add(item); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, item.ID())
+	}
+
+	return ids
+}
+
+func itemIDs(items []PodcastItem) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID())
+	}
+
+	return ids
+}
+
+func jobIDs(jobs []DownloadJob) []string {
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.Feed+"/"+job.ItemID)
+	}
+
+	return ids
+}
+
+func TestItemStore_ItemsPage(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			b := newBackend(t)
+
+			st := b.Items("test")
+			ids := addTestItems(t, st, 5)
+			addTestItems(t, b.Items("other"), 3)
+
+			for _, tc := range []struct {
+				After        string
+				Limit        int
+				Expected     []string
+				ExpectedNext string
+			}{
+				{"", 0, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}, ""},
+				{"", 2, []string{ids[4], ids[3]}, ids[3]},
+				{ids[3], 2, []string{ids[2], ids[1]}, ids[1]},
+				{ids[1], 2, []string{ids[0]}, ""},
+				{ids[2], 2, []string{ids[1], ids[0]}, ""},
+				{"", 5, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}, ""},
+				{ids[0], 2, nil, ""},
+				{"9999-12-31T00:00:00Z", 1, []string{ids[4]}, ids[4]},
+			} {
+				items, next, err := st.ItemsPage(tc.After, tc.Limit)
+				if err != nil {
+					t.Fatalf("ItemsPage(%q, %d): unexpected error: %s", tc.After, tc.Limit, err)
+				}
+
+				if actual := itemIDs(items); !reflect.DeepEqual(actual, tc.Expected) {
+					t.Errorf("ItemsPage(%q, %d): expected items %q, got %q", tc.After, tc.Limit, tc.Expected, actual)
+				}
+
+				if next != tc.ExpectedNext {
+					t.Errorf("ItemsPage(%q, %d): expected cursor %q, got %q", tc.After, tc.Limit, tc.ExpectedNext, next)
+				}
+			}
+		})
+	}
+}
+
+func TestItemStore_ItemsPage_RemovedCursor(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			st := newBackend(t).Items("test")
+			ids := addTestItems(t, st, 4)
+
+			items, next, err := st.ItemsPage("", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := itemIDs(items); !reflect.DeepEqual(actual, []string{ids[3], ids[2]}) {
+				t.Fatalf("unexpected first page %q", actual)
+			}
+
+			if _, err := st.Remove(next); err != nil {
+				t.Fatal(err)
+			}
+
+			items, next, err = st.ItemsPage(next, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := itemIDs(items); !reflect.DeepEqual(actual, []string{ids[1], ids[0]}) {
+				t.Errorf("expected the page after the removed item to be %q, got %q", []string{ids[1], ids[0]}, actual)
+			}
+
+			if next != "" {
+				t.Errorf("expected no next page, got cursor %q", next)
+			}
+		})
+	}
+}
+
+func TestItemStore_ItemsPage_EmptyFeed(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			items, next, err := newBackend(t).Items("test").ItemsPage("", 10)
+			if err != nil || items != nil || next != "" {
+				t.Errorf("expected no items in an empty feed, got %q, %q (%v)", itemIDs(items), next, err)
+			}
+		})
+	}
+}
+
+func TestItemStore_Revision(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			b := newBackend(t)
+			st := b.Items("test")
+
+			rev, err := st.Revision()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rev != (Revision{}) {
+				t.Fatalf("expected an empty revision of a new feed, got %+v", rev)
+			}
+
+			ids := addTestItems(t, st, 1)
+			for i, modify := range []func() error{
+				func() error {
+					_, err := st.UpdateStatus(ids[0], ItemDownloaded)
+					return err
+				},
+				func() error {
+					_, err := st.UpdateDescription(ids[0], Description{Title: "Updated"})
+					return err
+				},
+				st.Touch,
+			} {
+				if err := modify(); err != nil {
+					t.Fatalf("modification %d: %s", i, err)
+				}
+			}
+
+			last, err := st.Revision()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if last.Number != 4 {
+				t.Errorf("expected revision 4 after 4 modifications, got %d", last.Number)
+			}
+
+			if last.ModifiedAt.IsZero() {
+				t.Errorf("expected the revision to have modification time")
+			}
+
+			other, err := b.Items("other").Revision()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if other != (Revision{}) {
+				t.Errorf("expected modifications not to bump revisions of other feeds, got %+v", other)
+			}
+
+			if _, err := st.Remove("unknown"); !errors.Is(err, ErrItemNotFound) {
+				t.Errorf("expected ErrItemNotFound, got %v", err)
+			}
+
+			if rev, err := st.Revision(); err != nil || rev.Number != last.Number {
+				t.Errorf("expected a failed modification not to bump the revision, got %+v (%v)", rev, err)
+			}
+
+			if err := st.Drop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if rev, err := st.Revision(); err != nil || rev != (Revision{}) {
+				t.Errorf("expected the revision of a dropped feed to be empty, got %+v (%v)", rev, err)
+			}
+		})
+	}
+}
+
+func TestJobStore_Claim(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			st := newBackend(t).Jobs()
+
+			for _, job := range []DownloadJob{
+				{Feed: "b", ItemID: "2024-01-03T00:00:00Z", Status: StatusAdded},
+				{Feed: "a", ItemID: "2024-01-05T00:00:00Z", Status: StatusDownloaded},
+				{Feed: "a", ItemID: "2024-01-01T00:00:00Z", Status: StatusAdded, NextAttemptAt: now.Add(time.Minute)},
+				{Feed: "a", ItemID: "2024-01-02T00:00:00Z", Status: StatusAdded, NextAttemptAt: now.Add(-time.Minute)},
+				{Feed: "a", ItemID: "2024-01-04T00:00:00Z", Status: StatusFailed},
+			} {
+				if err := st.Put(job); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, tc := range []struct {
+				Statuses []DownloadStatus
+				Expected string
+			}{
+				{[]DownloadStatus{StatusDownloaded}, "a/2024-01-05T00:00:00Z"},
+				{[]DownloadStatus{StatusAdded}, "a/2024-01-02T00:00:00Z"},
+				{nil, "b/2024-01-03T00:00:00Z"},
+				{nil, "a/2024-01-04T00:00:00Z"},
+				{nil, ""},
+				{[]DownloadStatus{StatusAdded}, ""},
+			} {
+				job, err := st.Claim(now, tc.Statuses)
+				if tc.Expected == "" {
+					if !errors.Is(err, ErrNoInactiveJobs) {
+						t.Errorf("Claim(%v): expected ErrNoInactiveJobs, got %v (%s/%s)", tc.Statuses, err, job.Feed, job.ItemID)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("Claim(%v): unexpected error: %s", tc.Statuses, err)
+				}
+
+				if actual := job.Feed + "/" + job.ItemID; actual != tc.Expected {
+					t.Errorf("Claim(%v): expected %s, got %s", tc.Statuses, tc.Expected, actual)
+				}
+			}
+
+			// the job scheduled to be retried later is claimed once it is due
+			job, err := st.Claim(now.Add(time.Hour), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if job.Feed != "a" || job.ItemID != "2024-01-01T00:00:00Z" || !job.NextAttemptAt.Equal(now.Add(time.Minute)) {
+				t.Errorf("expected the delayed job to be claimed, got %+v", job)
+			}
+
+			// storing a claimed job resets its active status
+			job.Status, job.Attempts, job.LastError = StatusDownloaded, 1, "timeout"
+			if err := st.Put(job); err != nil {
+				t.Fatal(err)
+			}
+
+			claimed, err := st.Claim(now.Add(time.Hour), []DownloadStatus{StatusDownloaded})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claimed.ItemID != job.ItemID || claimed.Attempts != 1 || claimed.LastError != "timeout" {
+				t.Errorf("expected the updated job to be claimed again, got %+v", claimed)
+			}
+		})
+	}
+}
+
+func TestJobStore_Release(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			st := newBackend(t).Jobs()
+
+			for _, job := range []DownloadJob{
+				{Feed: "a", ItemID: "2024-01-01T00:00:00Z", Status: StatusAdded},
+				{Feed: "a", ItemID: "2024-01-02T00:00:00Z", Status: StatusAdded},
+			} {
+				if err := st.Put(job); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := st.Claim(time.Now(), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			active, err := st.Release("a", "2024-01-01T00:00:00Z")
+			if err != nil || !active {
+				t.Errorf("expected an active job not to be released, got %t (%v)", active, err)
+			}
+
+			active, err = st.Release("a", "2024-01-02T00:00:00Z")
+			if err != nil || active {
+				t.Errorf("expected an inactive job to be released, got %t (%v)", active, err)
+			}
+
+			if _, err := st.Release("a", "2024-01-02T00:00:00Z"); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("expected ErrJobNotFound for a released job, got %v", err)
+			}
+
+			if _, err := st.Release("b", "2024-01-01T00:00:00Z"); !errors.Is(err, ErrJobNotFound) {
+				t.Errorf("expected ErrJobNotFound for a job of another feed, got %v", err)
+			}
+
+			jobs, err := st.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := jobIDs(jobs); !reflect.DeepEqual(actual, []string{"a/2024-01-01T00:00:00Z"}) {
+				t.Errorf("unexpected jobs left %q", actual)
+			}
+		})
+	}
+}
+
+func TestJobStore_FeedsShareItemIDs(t *testing.T) {
+	for name, newBackend := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			st := newBackend(t).Jobs()
+
+			for _, job := range []DownloadJob{
+				{Feed: "b", ItemID: "2024-01-01T00:00:00Z", Status: StatusAdded},
+				{Feed: "a", ItemID: "2024-01-01T00:00:00Z", Status: StatusAdded},
+				{Feed: "a", ItemID: "2024-01-02T00:00:00Z", Status: StatusAdded},
+			} {
+				if err := st.Put(job); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			jobs, err := st.All()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []string{"a/2024-01-01T00:00:00Z", "b/2024-01-01T00:00:00Z", "a/2024-01-02T00:00:00Z"}
+			if actual := jobIDs(jobs); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected jobs %q, got %q", expected, actual)
+			}
+
+			if err := st.Delete("b", "2024-01-01T00:00:00Z"); err != nil {
+				t.Fatal(err)
+			}
+
+			job, err := st.Claim(time.Now(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if job.Feed != "a" || job.ItemID != "2024-01-01T00:00:00Z" {
+				t.Errorf("expected the job of another feed to be kept, got %s/%s", job.Feed, job.ItemID)
+			}
+		})
+	}
+}
+
+func TestOpenStorageBackend_CopyBoltData(t *testing.T) {
+	db := openTestBoltDB(t)
+	feeds := NewFeedRegistry(db, boltBackend{db}, t.TempDir(), nil)
+
+	for _, slug := range []string{"news", "talks", "empty"} {
+		if err := feeds.Create(PodcastFeed{Slug: slug}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := boltBackend{db}
+	news := addTestItems(t, src.Items("news"), 3)
+	talks := addTestItems(t, src.Items("talks"), 1)
+	addTestItems(t, src.Items("removed"), 2) // items of a feed that no longer exists
+
+	if err := src.Jobs().Put(NewDownloadJob("news", news[2], "https://example.com/audio.mp3", "audio.mp3")); err != nil {
+		t.Fatal(err)
+	}
+
+	dsn := "sqlite:" + filepath.Join(t.TempDir(), "test.sqlite")
+
+	b, err := openStorageBackend(dsn, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for slug, expected := range map[string][]string{
+		"news":    {news[2], news[1], news[0]},
+		"talks":   talks,
+		"empty":   nil,
+		"removed": nil,
+	} {
+		if items, err := b.Items(slug).Items(); err != nil || !reflect.DeepEqual(itemIDs(items), expected) {
+			t.Errorf("expected %s items %q, got %q (%v)", slug, expected, itemIDs(items), err)
+		}
+	}
+
+	if rev, err := b.Items("news").Revision(); err != nil || rev.Number == 0 {
+		t.Errorf("expected the feed revision to be copied, got %+v (%v)", rev, err)
+	}
+
+	if jobs, err := b.Jobs().All(); err != nil || !reflect.DeepEqual(jobIDs(jobs), []string{"news/" + news[2]}) {
+		t.Errorf("expected the download job to be copied, got %q (%v)", jobIDs(jobs), err)
+	}
+
+	// items removed after the switch are not copied again
+	for _, id := range news {
+		if _, err := b.Items("news").Remove(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := b.Jobs().Release("news", news[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Items("talks").Remove(talks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+
+	if b, err = openStorageBackend(dsn, db); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, slug := range []string{"news", "talks"} {
+		if items, err := b.Items(slug).Items(); err != nil || len(items) != 0 {
+			t.Errorf("expected no %s items, got %q (%v)", slug, itemIDs(items), err)
+		}
+	}
+
+	if jobs, err := b.Jobs().All(); err != nil || len(jobs) != 0 {
+		t.Errorf("expected no download jobs, got %q (%v)", jobIDs(jobs), err)
+	}
+}
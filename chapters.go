@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chapterLineRe matches description lines that start with a timestamp, i.e. "01:23 Intro" or "(1:02:03) - Q&A".
+var chapterLineRe = regexp.MustCompile(`^[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?\s*(?:[-–—:|.]\s*)?(\S.*)$`)
+
+// ParseChapters extracts chapters from a text listing timestamps, such as YouTube video description. Timestamps
+// are expected to go one per line in ascending order, otherwise the text is considered to have no chapters.
+func ParseChapters(text string) []Chapter {
+	var chapters []Chapter
+	for _, line := range strings.Split(text, "\n") {
+		m := chapterLineRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+
+		start, ok := parseClockTime(m[1])
+		if !ok {
+			continue
+		}
+
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start {
+			return nil // timestamps are not ordered, these are likely not chapters
+		}
+
+		chapters = append(chapters, Chapter{
+			Start: start,
+			Title: strings.TrimSpace(m[2]),
+		})
+	}
+
+	// a single timestamp is a reference rather than a chapter list
+	if len(chapters) < 2 {
+		return nil
+	}
+
+	return chapters
+}
+
+// ShiftChapters returns chapters of media that is trimmed at the beginning by offset. The chapter that
+// was playing at the offset becomes the first one.
+func ShiftChapters(chapters []Chapter, offset time.Duration) []Chapter {
+	if offset <= 0 {
+		return chapters
+	}
+
+	var shifted []Chapter
+	for i, ch := range chapters {
+		if i < len(chapters)-1 && chapters[i+1].Start <= offset {
+			continue // the chapter ends before the offset
+		}
+
+		ch.Start -= offset
+		if ch.Start < 0 {
+			ch.Start = 0
+		}
+
+		shifted = append(shifted, ch)
+	}
+
+	return shifted
+}
+
+// parseClockTime parses a timestamp in [hh:]mm:ss format.
+func parseClockTime(s string) (time.Duration, bool) {
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		if part == "" || part[0] < '0' || part[0] > '9' { // signs are accepted by strconv.Atoi
+			return 0, false
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, false
+		}
+
+		d = d*60 + time.Duration(n)
+	}
+
+	return d * time.Second, true
+}
+
+// podcastChapters is a chapters document in Podcasting 2.0 JSON chapters format,
+// see https://github.com/Podcastindex-org/podcast-namespace/blob/main/chapters/jsonChapters.md
+type podcastChapters struct {
+	Version  string           `json:"version"`
+	Chapters []podcastChapter `json:"chapters"`
+}
+
+type podcastChapter struct {
+	StartTime float64 `json:"startTime"`
+	Title     string  `json:"title"`
+}
+
+func newPodcastChapters(chapters []Chapter) podcastChapters {
+	doc := podcastChapters{
+		Version:  "1.2.0",
+		Chapters: make([]podcastChapter, 0, len(chapters)),
+	}
+
+	for _, ch := range chapters {
+		doc.Chapters = append(doc.Chapters, podcastChapter{
+			StartTime: ch.Start.Seconds(),
+			Title:     ch.Title,
+		})
+	}
+
+	return doc
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChapters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		Input    string
+		Expected []Chapter
+	}{
+		"description": {
+			Input: "Episode description\n\n0:00 Intro\n(1:30) - First topic\n[12:05] | Second topic\n  1:02:03. Q&A  \nhttps://example.com",
+			Expected: []Chapter{
+				{Start: 0, Title: "Intro"},
+				{Start: 90 * time.Second, Title: "First topic"},
+				{Start: 12*time.Minute + 5*time.Second, Title: "Second topic"},
+				{Start: time.Hour + 2*time.Minute + 3*time.Second, Title: "Q&A"},
+			},
+		},
+		"crlf": {
+			Input: "00:00 Intro\r\n05:00 Outro\r\n",
+			Expected: []Chapter{
+				{Start: 0, Title: "Intro"},
+				{Start: 5 * time.Minute, Title: "Outro"},
+			},
+		},
+		"single timestamp": {
+			Input: "The best part starts at\n3:15 Solo",
+		},
+		"unordered timestamps": {
+			Input: "0:00 Intro\n5:00 Second\n3:00 Third",
+		},
+		"timestamps without titles": {
+			Input: "0:00\n5:00",
+		},
+		"no timestamps": {
+			Input: "Just a description",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := ParseChapters(tc.Input); !reflect.DeepEqual(actual, tc.Expected) {
+				t.Errorf("expected %+v, got %+v", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestShiftChapters(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 0, Title: "Intro"},
+		{Start: time.Minute, Title: "First"},
+		{Start: 3 * time.Minute, Title: "Second"},
+	}
+
+	for _, tc := range []struct {
+		Offset   time.Duration
+		Expected []Chapter
+	}{
+		{0, chapters},
+		{30 * time.Second, []Chapter{{Start: 0, Title: "Intro"}, {Start: 30 * time.Second, Title: "First"}, {Start: 150 * time.Second, Title: "Second"}}},
+		{time.Minute, []Chapter{{Start: 0, Title: "First"}, {Start: 2 * time.Minute, Title: "Second"}}},
+		{2 * time.Minute, []Chapter{{Start: 0, Title: "First"}, {Start: time.Minute, Title: "Second"}}},
+		{5 * time.Minute, []Chapter{{Start: 0, Title: "Second"}}},
+	} {
+		if actual := ShiftChapters(chapters, tc.Offset); !reflect.DeepEqual(actual, tc.Expected) {
+			t.Errorf("ShiftChapters(%s): expected %+v, got %+v", tc.Offset, tc.Expected, actual)
+		}
+	}
+}
+
+func TestParseClockTime(t *testing.T) {
+	for _, tc := range []struct {
+		Input    string
+		Expected time.Duration
+		OK       bool
+	}{
+		{"0:00", 0, true},
+		{"1:30", 90 * time.Second, true},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second, true},
+		{"125", 125 * time.Second, true},
+		{"", 0, false},
+		{"1::30", 0, false},
+		{"1:30:", 0, false},
+		{"-1:30", 0, false},
+		{"1:+30", 0, false},
+		{"1:3a", 0, false},
+	} {
+		actual, ok := parseClockTime(tc.Input)
+		if actual != tc.Expected || ok != tc.OK {
+			t.Errorf("parseClockTime(%q) = %s, %t, expected %s, %t", tc.Input, actual, ok, tc.Expected, tc.OK)
+		}
+	}
+}
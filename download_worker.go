@@ -2,11 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/dhowden/tag"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts to download a file before giving up.
+	DefaultMaxAttempts = 5
+
+	minRetryBackoff = time.Minute
+	maxRetryBackoff = time.Hour
 )
 
 type fileDownloader interface {
@@ -14,67 +30,177 @@ type fileDownloader interface {
 }
 
 type mediaTranscoder interface {
-	TranscodeMedia(context.Context, string) (int64, error)
+	TranscodeMedia(context.Context, string, TranscodeOptions) (string, int64, error)
+	ProbeMedia(context.Context, string) (MediaInfo, error)
+	TagMedia(context.Context, string, MediaTags) (int64, error)
+}
+
+type transcriber interface {
+	Transcribe(context.Context, string) (Transcript, error)
+}
+
+// subtitledSource is implemented by audio sources that may provide subtitles for their media.
+type subtitledSource interface {
+	Subtitles(context.Context) (Transcript, string, error)
 }
 
-type statusUpdater interface {
-	UpdateStatus(string, Status) (PodcastItem, error)
+type itemStorage interface {
+	Get(feed string) (PodcastFeed, error)
+	Item(feed, itemID string) (PodcastItem, error)
+	UpdateStatus(feed, itemID string, newStatus Status) (PodcastItem, error)
+	UpdateError(feed, itemID, msg string) (PodcastItem, error)
+	UpdateImage(feed, itemID, fileName string) (PodcastItem, error)
+	UpdateMedia(feed, itemID, fileName, mimeType string, contentLength int64) (PodcastItem, error)
+	UpdatePostProcessing(feed, itemID string, pp PostProcessing) (PodcastItem, error)
+	UpdateMediaInfo(feed, itemID string, info MediaInfo) (PodcastItem, error)
+	UpdateTranscript(feed, itemID, fileName, lang string) (PodcastItem, error)
 }
 
 // DownloadWorker is a worker that monitors the download job queue and executes download jobs.
 type DownloadWorker struct {
 	q         *DownloadJobQueue
-	st        statusUpdater
+	st        itemStorage
 	c         fileDownloader
 	converter mediaTranscoder
+	resolvers map[PodcastItemType]sourceResolver
+	stt       transcriber
+
+	maxDownloads, maxTranscodes int
+	maxAttempts                 int
+	defaultProfile              string
 }
 
-// NewDownloadWorker returns a new instance of DownloadWorker.
+// NewDownloadWorker returns a new instance of DownloadWorker that runs up to maxDownloads downloads
+// and up to maxTranscodes transcoding jobs concurrently. Failed downloads are retried up to maxAttempts times.
+// Items are transcoded using defaultProfile unless the item or its feed specify another one.
 func NewDownloadWorker(
 	q *DownloadJobQueue,
-	st statusUpdater,
+	st itemStorage,
 	c fileDownloader,
 	converter mediaTranscoder,
+	maxDownloads, maxTranscodes, maxAttempts int,
+	defaultProfile string,
 ) *DownloadWorker {
-	return &DownloadWorker{q: q, st: st, c: c, converter: converter}
+	if maxDownloads < 1 {
+		maxDownloads = 1
+	}
+
+	if maxTranscodes < 1 {
+		maxTranscodes = 1
+	}
+
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &DownloadWorker{
+		q:              q,
+		st:             st,
+		c:              c,
+		converter:      converter,
+		resolvers:      make(map[PodcastItemType]sourceResolver),
+		maxDownloads:   maxDownloads,
+		maxTranscodes:  maxTranscodes,
+		maxAttempts:    maxAttempts,
+		defaultProfile: defaultProfile,
+	}
+}
+
+// RegisterResolver registers a provider used to get a fresh download URL for items of given type
+// before retrying a failed download.
+func (w *DownloadWorker) RegisterResolver(typ PodcastItemType, r sourceResolver) {
+	w.resolvers[typ] = r
 }
 
+// UseTranscriber sets the speech-to-text service used to transcribe items which source provides no subtitles.
+func (w *DownloadWorker) UseTranscriber(t transcriber) {
+	w.stt = t
+}
+
+// Run picks up jobs from the queue as soon as they are added and executes them until the context is cancelled.
+// The queue is also checked every pollDuration. Once the context is cancelled, the worker stops picking up new jobs
+// and waits for the running ones to complete.
 func (w *DownloadWorker) Run(ctx context.Context, pollDuration time.Duration) {
-	log.Printf("starting download worker with poll duration %s", pollDuration)
+	log.Printf("starting download worker with %d download(s) and %d transcoding job(s) at a time", w.maxDownloads, w.maxTranscodes)
 	defer log.Print("download worker stopped")
 
 	if err := w.resetStaleJobs(ctx); err != nil {
 		log.Printf("failed to reset stale jobs: %s", err)
 	}
 
-	c := time.Tick(pollDuration)
+	var (
+		wg         sync.WaitGroup
+		downloads  = make(chan struct{}, w.maxDownloads)
+		transcodes = make(chan struct{}, w.maxTranscodes)
+		released   = make(chan struct{}, 1)
+		// running jobs are not interrupted on shutdown
+		jobCtx = context.WithoutCancel(ctx)
+	)
+
+	t := time.NewTicker(pollDuration)
+	defer t.Stop()
 
 	for {
+		w.dispatch(jobCtx, &wg, downloads, released, w.handleFileDownload, StatusAdded)
+		w.dispatch(jobCtx, &wg, transcodes, released, w.handleFileConversion, StatusDownloaded)
+
 		select {
 		case <-ctx.Done():
+			log.Printf("waiting for %d running job(s) to complete", len(downloads)+len(transcodes))
+			wg.Wait()
+
 			return
-		case <-c:
-			job, err := w.q.Next()
-			if err != nil {
-				if err != ErrNoInactiveJobs {
-					log.Printf("failed to get next job: %v", err)
-				}
+		case <-w.q.Notify():
+		case <-released:
+		case <-t.C:
+		}
+	}
+}
 
-				continue
-			}
+// dispatch starts jobs with given statuses until there are no free slots left in the pool.
+func (w *DownloadWorker) dispatch(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pool chan struct{},
+	released chan<- struct{},
+	handle func(context.Context, DownloadJob),
+	statuses ...DownloadStatus,
+) {
+	for {
+		select {
+		case pool <- struct{}{}:
+		default:
+			return // all slots are taken
+		}
+
+		job, err := w.q.Next(statuses...)
+		if err != nil {
+			<-pool
 
-			switch job.Status {
-			case StatusAdded:
-				go w.handleFileDownload(ctx, job)
-			case StatusDownloaded:
-				go w.handleFileConversion(ctx, job)
-			case StatusFailed:
-				go w.handleDownloadFailure(ctx, job)
-			default:
-				log.Printf("unexpected job status %q (job id %s)", job.Status, job.ItemID)
-				continue
+			if err != ErrNoInactiveJobs {
+				log.Printf("failed to get next job: %v", err)
 			}
+
+			return
 		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-pool
+				wg.Done()
+
+				select {
+				case released <- struct{}{}:
+				default:
+				}
+			}()
+
+			jobCtx, done := w.q.Track(ctx, job)
+			defer done()
+
+			handle(jobCtx, job)
+		}()
 	}
 }
 
@@ -98,22 +224,51 @@ func (w *DownloadWorker) resetStaleJobs(ctx context.Context) error {
 }
 
 func (w *DownloadWorker) handleFileDownload(ctx context.Context, job DownloadJob) {
-	defer func() {
-		if err := w.q.Update(job); err != nil {
-			log.Printf("failed to update job status to %s (job id %s): %s", job.ItemID, job.Status, err)
+	defer w.updateJob(&job)
+
+	item, err := w.st.Item(job.Feed, job.ItemID)
+	if err != nil {
+		if err != ErrItemNotFound {
+			log.Printf("failed to fetch podcast item %s: %s", job.ItemID, err)
+			return
 		}
-	}()
 
-	newItemStatus := ItemDownloaded
-	job.Status = StatusDownloaded
+		log.Printf("podcast item %s was deleted, cancelling job", job.ItemID)
+		job.Status = StatusCancelled // item was deleted, cancel job
+
+		return
+	}
+
+	if job.Attempts > 0 || job.Refresh {
+		if u, err := w.resolveSourceURL(ctx, item); err != nil {
+			log.Printf("failed to refresh download URL for %s: %s", item.OriginalURL, err)
+		} else if u != "" {
+			job.SourceURI = u
+		}
+
+		job.Refresh = false
+	}
 
 	if err := w.downloadFile(ctx, job.SourceURI, job.TargetURI); err != nil {
+		if ctx.Err() != nil {
+			w.handleJobCancel(&job)
+			return
+		}
+
 		log.Printf("failed to download %s: %s", job.SourceURI, err)
-		newItemStatus = ItemDownloadFailed
-		job.Status = StatusFailed
+		w.handleJobError(&job, err, w.retryable(err, item))
+
+		return
+	}
+
+	job.Status = StatusDownloaded
+	job.Attempts, job.LastError, job.NextAttemptAt = 0, "", time.Time{}
+
+	if item.ImageFileName == "" {
+		w.extractArtwork(job)
 	}
 
-	if _, err := w.st.UpdateStatus(job.ItemID, newItemStatus); err != nil {
+	if _, err := w.st.UpdateStatus(job.Feed, job.ItemID, ItemDownloaded); err != nil {
 		if err != ErrItemNotFound {
 			log.Printf("failed to update podcast item status for %s: %s", job.ItemID, err)
 			job.Status = StatusFailed
@@ -143,24 +298,223 @@ func (w *DownloadWorker) downloadFile(ctx context.Context, sourceURL, destinatio
 	return nil
 }
 
+// extractArtwork stores the cover art embedded into the downloaded file next to it. Video streams, and
+// thus embedded pictures, are dropped while transcoding, so this needs to be done beforehand.
+func (w *DownloadWorker) extractArtwork(job DownloadJob) {
+	fd, err := os.Open(job.TargetURI)
+	if err != nil {
+		log.Printf("failed to open %s: %s", job.TargetURI, err)
+		return
+	}
+	defer fd.Close()
+
+	m, err := tag.ReadFrom(fd)
+	if err != nil || m.Picture() == nil {
+		return // no embedded artwork
+	}
+
+	pic := m.Picture()
+	imagePath := strings.TrimSuffix(job.TargetURI, path.Ext(job.TargetURI)) + artworkExt(pic.MIMEType)
+
+	if err := os.WriteFile(imagePath, pic.Data, 0644); err != nil {
+		log.Printf("failed to store artwork for %s: %s", job.ItemID, err)
+		return
+	}
+
+	if _, err := w.st.UpdateImage(job.Feed, job.ItemID, path.Base(imagePath)); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item artwork for %s: %s", job.ItemID, err)
+	}
+}
+
+// resolveSourceURL returns a fresh download URL for an item if its provider supports it.
+func (w *DownloadWorker) resolveSourceURL(ctx context.Context, item PodcastItem) (string, error) {
+	r, ok := w.resolvers[item.Type]
+	if !ok || item.OriginalURL == "" {
+		return "", nil
+	}
+
+	src, err := r.ResolveSource(item.OriginalURL)
+	if err != nil {
+		return "", err
+	}
+
+	return src.DownloadURL(ctx)
+}
+
+// retryable returns true if the download failed due to a transient error. Download URLs that were rejected by
+// the server are considered to be expired and are retried only if the item provider can issue a new one.
+func (w *DownloadWorker) retryable(err error, item PodcastItem) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode; {
+		case code >= http.StatusInternalServerError, code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+			return true
+		case code == http.StatusForbidden, code == http.StatusNotFound, code == http.StatusGone:
+			_, ok := w.resolvers[item.Type]
+			return ok && item.OriginalURL != ""
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// handleJobError records a failed job attempt and either schedules the next one or marks both the job and
+// the item as failed.
+func (w *DownloadWorker) handleJobError(job *DownloadJob, jobErr error, retry bool) {
+	job.Attempts++
+	job.LastError = jobErr.Error()
+
+	var (
+		msg    string
+		status Status
+	)
+
+	if retry && job.Attempts < w.maxAttempts {
+		job.NextAttemptAt = time.Now().Add(retryBackoff(job.Attempts))
+		msg = fmt.Sprintf("Attempt %d of %d failed, retrying at %s: %s", job.Attempts, w.maxAttempts, job.NextAttemptAt.Format("15:04"), job.LastError)
+
+		log.Printf("job %s failed (attempt %d of %d), retrying in %s", job.ItemID, job.Attempts, w.maxAttempts, time.Until(job.NextAttemptAt).Round(time.Second))
+	} else {
+		job.Status, status = StatusFailed, ItemDownloadFailed
+		msg = job.LastError
+
+		if job.Attempts > 1 {
+			msg = fmt.Sprintf("Gave up after %d attempts: %s", job.Attempts, job.LastError)
+		}
+
+		log.Printf("job %s failed after %d attempt(s)", job.ItemID, job.Attempts)
+	}
+
+	if _, err := w.st.UpdateError(job.Feed, job.ItemID, msg); err != nil {
+		if err != ErrItemNotFound {
+			log.Printf("failed to update podcast item %s: %s", job.ItemID, err)
+			return
+		}
+
+		log.Printf("podcast item %s was deleted, cancelling job", job.ItemID)
+		job.Status = StatusCancelled // item was deleted, cancel job
+
+		return
+	}
+
+	if status == 0 {
+		return
+	}
+
+	if _, err := w.st.UpdateStatus(job.Feed, job.ItemID, status); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item status for %s: %s", job.ItemID, err)
+	}
+}
+
+// handleJobCancel marks both the job and the item as cancelled after the job has been stopped by the user.
+// updateJob stores the job once the worker is done with it. If the job has been cancelled in the meantime, the
+// item is marked as cancelled.
+func (w *DownloadWorker) updateJob(job *DownloadJob) {
+	switch err := w.q.Update(*job); err {
+	case nil:
+	case ErrJobCancelled:
+		w.handleJobCancel(job)
+	default:
+		log.Printf("failed to update job status to %s (job id %s): %s", job.Status, job.ItemID, err)
+	}
+}
+
+func (w *DownloadWorker) handleJobCancel(job *DownloadJob) {
+	log.Printf("job %s was cancelled", job.ItemID)
+	job.Status = StatusCancelled
+
+	if _, err := w.st.UpdateStatus(job.Feed, job.ItemID, ItemCancelled); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item status for %s: %s", job.ItemID, err)
+	}
+}
+
 func (w *DownloadWorker) handleFileConversion(ctx context.Context, job DownloadJob) {
-	defer func() {
-		if err := w.q.Update(job); err != nil {
-			log.Printf("failed to update job status to %s (job id %s): %s", job.ItemID, job.Status, err)
+	defer w.updateJob(&job)
+
+	item, err := w.st.Item(job.Feed, job.ItemID)
+	if err != nil {
+		if err != ErrItemNotFound {
+			log.Printf("failed to fetch podcast item %s: %s", job.ItemID, err)
 			return
 		}
-	}()
 
-	newItemStatus := ItemReady
-	job.Status = StatusReady
+		log.Printf("podcast item %s was deleted, cancelling job", job.ItemID)
+		job.Status = StatusCancelled // item was deleted, cancel job
+
+		return
+	}
+
+	feed, err := w.st.Get(job.Feed)
+	if err != nil {
+		log.Printf("failed to fetch feed %s: %s", job.Feed, err)
+		w.handleJobError(&job, err, false)
+
+		return
+	}
+
+	profile, err := w.transcodingProfile(feed, item)
+	if err != nil {
+		log.Printf("failed to get transcoding profile for %s: %s", job.ItemID, err)
+		w.handleJobError(&job, err, false)
+
+		return
+	}
+
+	filePath, size, err := w.convertFile(ctx, job.TargetURI, TranscodeOptions{
+		Start:          item.StartOffset,
+		Profile:        profile,
+		PostProcessing: feed.PostProcessing,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			w.handleJobCancel(&job)
+			return
+		}
 
-	if err := w.convertFile(ctx, job.TargetURI); err != nil {
 		log.Printf("failed to convert %s: %s", job.TargetURI, err)
-		job.Status = StatusFailed
-		newItemStatus = ItemDownloadFailed
+		w.handleJobError(&job, err, false)
+
+		return
 	}
 
-	if _, err := w.st.UpdateStatus(job.ItemID, newItemStatus); err != nil {
+	mimeType := item.MIMEType
+	if profile.MIMEType != "" {
+		mimeType = profile.MIMEType
+	}
+
+	// the transcoded file may have a different extension than the downloaded one
+	job.TargetURI = filePath
+
+	if _, err := w.st.UpdateMedia(job.Feed, job.ItemID, path.Base(filePath), mimeType, size); err != nil {
+		if err != ErrItemNotFound {
+			log.Printf("failed to update podcast item media for %s: %s", job.ItemID, err)
+			job.Status = StatusFailed
+			return
+		}
+
+		log.Printf("podcast item %s was deleted, cancelling job", job.ItemID)
+		job.Status = StatusCancelled // item was deleted, cancel job
+
+		return
+	}
+
+	if _, err := w.st.UpdatePostProcessing(job.Feed, job.ItemID, feed.PostProcessing); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item post-processing for %s: %s", job.ItemID, err)
+	}
+
+	w.tagFile(ctx, feed, item, filePath)
+	w.probeFile(ctx, job, filePath)
+	w.transcribeFile(ctx, job, item, feed.PostProcessing, filePath)
+
+	job.Status = StatusReady
+
+	if _, err := w.st.UpdateStatus(job.Feed, job.ItemID, ItemReady); err != nil {
 		if err != ErrItemNotFound {
 			log.Printf("failed to update podcast item status for %s: %s", job.ItemID, err)
 			job.Status = StatusFailed
@@ -172,33 +526,164 @@ func (w *DownloadWorker) handleFileConversion(ctx context.Context, job DownloadJ
 	}
 }
 
-func (w *DownloadWorker) convertFile(ctx context.Context, filePath string) error {
-	log.Println("transcoding", filePath)
+// transcodingProfile returns the profile to transcode the item with. The item profile takes precedence over
+// the feed one, which in turn takes precedence over the default profile.
+func (w *DownloadWorker) transcodingProfile(feed PodcastFeed, item PodcastItem) (TranscodingProfile, error) {
+	name := item.TranscodingProfile
+	if name == "" {
+		name = feed.TranscodingProfile
+	}
+
+	if name == "" {
+		name = w.defaultProfile
+	}
+
+	if name == "" {
+		name = OriginalProfile
+	}
+
+	profile, ok := TranscodingProfiles[name]
+	if !ok {
+		return TranscodingProfile{}, fmt.Errorf("%w %q", ErrUnknownProfile, name)
+	}
+
+	return profile, nil
+}
+
+// tagFile writes the item metadata into the media file, so that it can be identified once copied elsewhere.
+func (w *DownloadWorker) tagFile(ctx context.Context, feed PodcastFeed, item PodcastItem, filePath string) {
+	tags := MediaTags{
+		Title:    item.Title,
+		Artist:   item.Author,
+		Album:    feed.Title,
+		Comment:  item.Body,
+		Date:     item.AddedAt,
+		Chapters: item.Chapters,
+		Duration: item.Duration,
+	}
+
+	if item.ImageFileName != "" {
+		tags.ImagePath = path.Join(path.Dir(filePath), item.ImageFileName)
+	}
+
+	if _, err := w.converter.TagMedia(ctx, filePath, tags); err != nil {
+		log.Printf("failed to tag %s: %s", filePath, err)
+		return
+	}
+
+	log.Printf("tagged %s", filePath)
+}
 
-	transcodedSize, err := w.converter.TranscodeMedia(ctx, filePath)
+// probeFile stores the actual duration, size and audio format of the final media file. Providers don't always
+// know the duration in advance, and the size changes after transcoding, so this info is never taken for granted.
+func (w *DownloadWorker) probeFile(ctx context.Context, job DownloadJob, filePath string) {
+	info, err := w.converter.ProbeMedia(ctx, filePath)
 	if err != nil {
-		return fmt.Errorf("failed to transcode file: %w", err)
+		log.Printf("failed to probe %s: %s", filePath, err)
+		return
 	}
 
-	log.Printf("transcoded %s (new size %s)", filePath, FileSize(transcodedSize))
+	log.Printf("probed %s: %s, %s, %d channel(s), %d bps", filePath, info.Duration.Round(time.Second), info.Codec, info.Channels, info.BitRate)
 
-	return nil
+	if _, err := w.st.UpdateMediaInfo(job.Feed, job.ItemID, info); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item media info for %s: %s", job.ItemID, err)
+	}
 }
 
-func (w *DownloadWorker) handleDownloadFailure(ctx context.Context, job DownloadJob) {
-	if _, err := w.st.UpdateStatus(job.ItemID, ItemDownloadFailed); err == ErrItemNotFound {
-		log.Printf("podcast item %s was deleted, cancelling job", job.ItemID)
+// transcribeFile stores the transcript of the item next to its media file. Subtitles provided by the item source
+// are used if available, otherwise the file is transcribed if the worker has a transcriber. Subtitles don't match
+// the audio with silent parts removed, so they're never used in this case.
+func (w *DownloadWorker) transcribeFile(ctx context.Context, job DownloadJob, item PodcastItem, pp PostProcessing, filePath string) {
+	var (
+		transcript Transcript
+		lang       string
+		err        error = ErrNoSubtitles
+	)
+
+	if !pp.TrimSilence && !pp.CompressSilence {
+		if transcript, lang, err = w.fetchSubtitles(ctx, item); err == nil {
+			log.Printf("fetched %s subtitles for %s", lang, job.ItemID)
+		} else if err != ErrNoSubtitles {
+			log.Printf("failed to fetch subtitles for %s: %s", job.ItemID, err)
+		}
+	}
 
-		job.Status = StatusCancelled
-		if err := w.q.Update(job); err != nil {
-			log.Printf("failed to update job status to %s (job id %s): %s", job.ItemID, job.Status, err)
+	if err != nil {
+		if w.stt == nil {
 			return
 		}
 
+		log.Printf("transcribing %s", filePath)
+
+		if transcript, err = w.stt.Transcribe(ctx, filePath); err != nil {
+			log.Printf("failed to transcribe %s: %s", filePath, err)
+			return
+		}
+
+		log.Printf("transcribed %s (%d cue(s))", filePath, len(transcript))
+	}
+
+	stem := strings.TrimSuffix(filePath, path.Ext(filePath))
+	if err := writeTranscriptFiles(stem, transcript); err != nil {
+		log.Printf("failed to store transcript of %s: %s", job.ItemID, err)
 		return
 	}
 
-	log.Printf("ignoring failed job %s", job.ItemID)
+	if _, err := w.st.UpdateTranscript(job.Feed, job.ItemID, path.Base(stem)+TranscriptFormats[0].Ext, lang); err != nil && err != ErrItemNotFound {
+		log.Printf("failed to update podcast item transcript for %s: %s", job.ItemID, err)
+	}
+}
+
+// fetchSubtitles returns subtitles provided by the item source. ErrNoSubtitles is returned if the source
+// doesn't provide any.
+func (w *DownloadWorker) fetchSubtitles(ctx context.Context, item PodcastItem) (Transcript, string, error) {
+	r, ok := w.resolvers[item.Type]
+	if !ok || item.OriginalURL == "" {
+		return nil, "", ErrNoSubtitles
+	}
+
+	src, err := r.ResolveSource(item.OriginalURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subSrc, ok := src.(subtitledSource)
+	if !ok {
+		return nil, "", ErrNoSubtitles
+	}
+
+	return subSrc.Subtitles(ctx)
+}
+
+func (w *DownloadWorker) convertFile(ctx context.Context, filePath string, opts TranscodeOptions) (string, int64, error) {
+	if opts.PostProcessing.Enabled() {
+		log.Printf("transcoding %s using %s profile (%s)", filePath, opts.Profile.Name, opts.PostProcessing)
+	} else {
+		log.Printf("transcoding %s using %s profile", filePath, opts.Profile.Name)
+	}
+
+	transcodedPath, transcodedSize, err := w.converter.TranscodeMedia(ctx, filePath, opts)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to transcode file: %w", err)
+	}
+
+	log.Printf("transcoded %s to %s (new size %s)", filePath, transcodedPath, FileSize(transcodedSize))
+
+	return transcodedPath, transcodedSize, nil
+}
+
+// retryBackoff returns the delay before the next attempt doubling it after each failed one.
+func retryBackoff(attempt int) time.Duration {
+	d := minRetryBackoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+
+	return d
 }
 
 // moveFile moves a file from srcPath to destPath even if these path are on different filesystems.
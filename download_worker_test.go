@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testResolver struct{}
+
+func (testResolver) ResolveSource(string) (audioSource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		Attempt  int
+		Expected time.Duration
+	}{
+		{0, minRetryBackoff},
+		{1, minRetryBackoff},
+		{2, 2 * minRetryBackoff},
+		{3, 4 * minRetryBackoff},
+		{6, 32 * minRetryBackoff},
+		{7, maxRetryBackoff},
+		{100, maxRetryBackoff},
+	} {
+		if actual := retryBackoff(tc.Attempt); actual != tc.Expected {
+			t.Errorf("retryBackoff(%d) = %s, expected %s", tc.Attempt, actual, tc.Expected)
+		}
+	}
+}
+
+func TestDownloadWorker_Retryable(t *testing.T) {
+	w := &DownloadWorker{
+		resolvers: map[PodcastItemType]sourceResolver{YouTubeItem: testResolver{}},
+	}
+
+	statusErr := func(code int) error {
+		return fmt.Errorf("download failed: %w", &HTTPStatusError{
+			URL:        "https://example.com/audio.mp3",
+			StatusCode: code,
+			Status:     http.StatusText(code),
+		})
+	}
+
+	resolvable := PodcastItem{Type: YouTubeItem, OriginalURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+	unresolvable := PodcastItem{Type: TelegramItem, OriginalURL: "https://t.me/c/1/2"}
+
+	for name, tc := range map[string]struct {
+		Err      error
+		Item     PodcastItem
+		Expected bool
+	}{
+		"internal server error":      {statusErr(http.StatusInternalServerError), unresolvable, true},
+		"bad gateway":                {statusErr(http.StatusBadGateway), unresolvable, true},
+		"request timeout":            {statusErr(http.StatusRequestTimeout), unresolvable, true},
+		"too many requests":          {statusErr(http.StatusTooManyRequests), unresolvable, true},
+		"bad request":                {statusErr(http.StatusBadRequest), resolvable, false},
+		"unauthorized":               {statusErr(http.StatusUnauthorized), resolvable, false},
+		"forbidden with resolver":    {statusErr(http.StatusForbidden), resolvable, true},
+		"not found with resolver":    {statusErr(http.StatusNotFound), resolvable, true},
+		"gone with resolver":         {statusErr(http.StatusGone), resolvable, true},
+		"forbidden without resolver": {statusErr(http.StatusForbidden), unresolvable, false},
+		"gone without original url":  {statusErr(http.StatusGone), PodcastItem{Type: YouTubeItem}, false},
+		"network error":              {fmt.Errorf("download failed: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), unresolvable, true},
+		"deadline exceeded":          {fmt.Errorf("download failed: %w", context.DeadlineExceeded), unresolvable, true},
+		"unexpected eof":             {fmt.Errorf("download failed: %w", io.ErrUnexpectedEOF), unresolvable, true},
+		"canceled":                   {context.Canceled, unresolvable, false},
+		"other error":                {errors.New("invalid file"), resolvable, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := w.retryable(tc.Err, tc.Item); actual != tc.Expected {
+				t.Errorf("retryable(%q) = %t, expected %t", tc.Err, actual, tc.Expected)
+			}
+		})
+	}
+}
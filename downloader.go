@@ -8,6 +8,18 @@ import (
 	"os"
 )
 
+// HTTPStatusError is returned when the server responds to a download request with an error status.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+// Error implements the error interface.
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("failed to download %s: server responded with %s", e.URL, e.Status)
+}
+
 // HTTPDownloader is a service that downloads files via HTTP.
 type HTTPDownloader struct {
 	tmpDir string
@@ -40,7 +52,7 @@ func (svc *HTTPDownloader) DownloadFile(ctx context.Context, u string) (string,
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= http.StatusBadRequest {
-		return "", 0, fmt.Errorf("failed to download %s: server responded with %s", u, resp.Status)
+		return "", 0, &HTTPStatusError{URL: u, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	fd, err := os.CreateTemp(svc.tmpDir, "youcast*")
@@ -51,6 +63,7 @@ func (svc *HTTPDownloader) DownloadFile(ctx context.Context, u string) (string,
 
 	written, err := io.Copy(fd, resp.Body)
 	if err != nil {
+		os.Remove(fd.Name())
 		return "", written, fmt.Errorf("failed to download %s to %s: %w", u, fd.Name(), err)
 	}
 
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFeedDocumentSize is the maximum size of an imported RSS, Atom or OPML document.
+const maxFeedDocumentSize = 20 << 20
+
+// ErrFeedImport is returned when a download URL is requested for an imported feed.
+var ErrFeedImport = errors.New("imported feeds can only be downloaded episode by episode")
+
+// FeedImportProvider is an audio source provider that imports episodes of existing podcast feeds.
+type FeedImportProvider struct{}
+
+// Name returns the name of the provider.
+func (*FeedImportProvider) Name() string {
+	return "Podcast feed"
+}
+
+// HandleRequest handles an HTTP request and returns an audio source.
+func (p *FeedImportProvider) HandleRequest(w http.ResponseWriter, req *http.Request) audioSource {
+	src, err := p.ParseRequest(req)
+	if err != nil {
+		log.Printf("failed to import podcast feed: %s", err)
+		if errors.Is(err, ErrInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return nil
+	}
+
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+
+	return src
+}
+
+// ParseRequest returns the RSS or Atom feed referenced by the url= parameter, or the RSS, Atom or OPML document
+// uploaded as file= form field. Imports can be limited to the newest limit= episodes of each feed, and
+// skip_existing=1 skips episodes already present in the feed.
+func (p *FeedImportProvider) ParseRequest(req *http.Request) (audioSource, error) {
+	var imp PodcastFeedImport
+
+	if s := req.FormValue("url"); s != "" {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%w: malformed feed URL %q", ErrInvalidRequest, s)
+		}
+
+		imp.URL = u.String()
+	} else {
+		fd, header, err := req.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing url= parameter or uploaded file", ErrInvalidRequest)
+		}
+		defer fd.Close()
+
+		data, err := io.ReadAll(io.LimitReader(fd, maxFeedDocumentSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+		}
+
+		if len(data) > maxFeedDocumentSize {
+			return nil, fmt.Errorf("%w: uploaded file exceeds %d bytes", ErrInvalidRequest, maxFeedDocumentSize)
+		}
+
+		imp.FileName, imp.document = header.Filename, data
+	}
+
+	if s := req.FormValue("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%w: malformed limit= parameter", ErrInvalidRequest)
+		}
+
+		imp.Limit = limit
+	}
+
+	switch strings.ToLower(req.FormValue("skip_existing")) {
+	case "1", "true", "on", "yes":
+		imp.SkipExisting = true
+	}
+
+	return &imp, nil
+}
+
+// PodcastFeedImport is an RSS, Atom or OPML document that provides a podcast item for each episode of
+// the feeds it contains. Episodes keep their original publication dates.
+type PodcastFeedImport struct {
+	// URL is the location of the document, FileName is used for uploaded ones.
+	URL      string
+	FileName string
+
+	// Limit is the number of the newest episodes to add from each feed. Zero means all episodes.
+	Limit int
+	// SkipExisting omits episodes that are already present in the feed.
+	SkipExisting bool
+
+	document []byte
+}
+
+// Metadata returns the title, description and author of the imported feed, or the title of the OPML document.
+func (imp *PodcastFeedImport) Metadata(ctx context.Context) (Metadata, error) {
+	feed, err := imp.parse(ctx)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	meta := Metadata{
+		Type:        ImportedItem,
+		OriginalURL: imp.URL,
+		Title:       feed.Title,
+		Description: feed.Description,
+		Author:      feed.Author,
+	}
+
+	if meta.OriginalURL == "" {
+		meta.OriginalURL = imp.FileName
+	}
+
+	return meta, nil
+}
+
+// DownloadURL always returns ErrFeedImport, since episodes are downloaded separately.
+func (imp *PodcastFeedImport) DownloadURL(context.Context) (string, error) {
+	return "", ErrFeedImport
+}
+
+// Sources returns a PodcastEpisode for each episode of the imported feeds, oldest first. Feeds listed in
+// an OPML document are fetched one by one, and the ones that fail to load are logged and skipped.
+func (imp *PodcastFeedImport) Sources(ctx context.Context, exists func(originalURL string) bool) ([]audioSource, error) {
+	feed, err := imp.parse(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds := []importedFeed{feed}
+	if len(feed.Feeds) > 0 {
+		feeds = feeds[:0]
+
+		for _, u := range feed.Feeds {
+			data, err := fetchFeedDocument(ctx, u)
+			if err != nil {
+				log.Printf("skipping %s: %s", u, err)
+				continue
+			}
+
+			f, err := parseFeedDocument(data)
+			if err != nil {
+				log.Printf("skipping %s: %s", u, err)
+				continue
+			}
+
+			feeds = append(feeds, f)
+		}
+	}
+
+	var episodes []PodcastEpisode
+	for _, f := range feeds {
+		eps := f.Episodes
+		sort.SliceStable(eps, func(i, j int) bool {
+			return eps[i].PublishedAt.Before(eps[j].PublishedAt)
+		})
+
+		if imp.Limit > 0 && len(eps) > imp.Limit {
+			eps = eps[len(eps)-imp.Limit:]
+		}
+
+		episodes = append(episodes, eps...)
+	}
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].PublishedAt.Before(episodes[j].PublishedAt)
+	})
+
+	srcs := make([]audioSource, 0, len(episodes))
+	for _, ep := range episodes {
+		if imp.SkipExisting && exists(ep.URL) {
+			log.Printf("skipping %s that is already in the feed", ep.URL)
+			continue
+		}
+
+		srcs = append(srcs, ep)
+	}
+
+	return srcs, nil
+}
+
+// parse returns the uploaded document or fetches and parses the one referenced by the URL.
+func (imp *PodcastFeedImport) parse(ctx context.Context) (importedFeed, error) {
+	data := imp.document
+	if data == nil {
+		var err error
+		if data, err = fetchFeedDocument(ctx, imp.URL); err != nil {
+			return importedFeed{}, err
+		}
+	}
+
+	return parseFeedDocument(data)
+}
+
+// PodcastEpisode is an audio source that represents an episode of an imported podcast feed.
+type PodcastEpisode struct {
+	Title         string
+	Description   string
+	Author        string
+	URL           string
+	MIMEType      string
+	ContentLength int64
+	Duration      time.Duration
+	ImageURL      string
+	PublishedAt   time.Time
+}
+
+// Metadata returns the metadata of the episode. The enclosure URL is used as the original URL of the item.
+func (ep PodcastEpisode) Metadata(ctx context.Context) (Metadata, error) {
+	return Metadata{
+		Type:          ImportedItem,
+		OriginalURL:   ep.URL,
+		Title:         ep.Title,
+		Description:   ep.Description,
+		Author:        ep.Author,
+		Duration:      ep.Duration,
+		MIMEType:      ep.MIMEType,
+		ContentLength: ep.ContentLength,
+		ImageURL:      ep.ImageURL,
+		PublishedAt:   ep.PublishedAt,
+	}, nil
+}
+
+// DownloadURL returns the enclosure URL of the episode.
+func (ep PodcastEpisode) DownloadURL(ctx context.Context) (string, error) {
+	return ep.URL, nil
+}
+
+// importedFeed is a podcast feed read from an RSS or Atom document, or a list of feeds read from OPML.
+type importedFeed struct {
+	Title       string
+	Description string
+	Author      string
+	Episodes    []PodcastEpisode
+	// Feeds lists URLs of RSS and Atom feeds referenced by an OPML document.
+	Feeds []string
+}
+
+// fetchFeedDocument downloads an RSS, Atom or OPML document.
+func fetchFeedDocument(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, text/x-opml, application/xml;q=0.9, */*;q=0.8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: server responded with %s", u, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedDocumentSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+
+	return data, nil
+}
+
+// parseFeedDocument parses an RSS 2.0, Atom or OPML document. Episodes without an enclosure are omitted.
+func parseFeedDocument(data []byte) (importedFeed, error) {
+	var root string
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for root == "" {
+		tok, err := dec.Token()
+		if err != nil {
+			return importedFeed{}, fmt.Errorf("failed to parse feed: %w", err)
+		}
+
+		if el, ok := tok.(xml.StartElement); ok {
+			root = el.Name.Local
+		}
+	}
+
+	switch root {
+	case "rss":
+		return parseRSSDocument(data)
+	case "feed":
+		return parseAtomDocument(data)
+	case "opml":
+		return parseOPMLDocument(data)
+	default:
+		return importedFeed{}, fmt.Errorf("%w: unsupported feed format <%s>", ErrInvalidRequest, root)
+	}
+}
+
+type rssImportItem struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Summary     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Author      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	PubDate     string `xml:"pubDate"`
+	Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Image       struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Type   string `xml:"type,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"enclosure"`
+}
+
+func parseRSSDocument(data []byte) (importedFeed, error) {
+	var doc struct {
+		Channel struct {
+			Title       string `xml:"title"`
+			Description string `xml:"description"`
+			Author      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+			// both <image><url>...</url></image> and <itunes:image href="..."/> are decoded into Image
+			Image struct {
+				URL  string `xml:"url"`
+				Href string `xml:"href,attr"`
+			} `xml:"image"`
+			Items []rssImportItem `xml:"item"`
+		} `xml:"channel"`
+	}
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return importedFeed{}, fmt.Errorf("failed to parse RSS feed: %w", err)
+	}
+
+	ch := doc.Channel
+	feed := importedFeed{
+		Title:       strings.TrimSpace(ch.Title),
+		Description: strings.TrimSpace(ch.Description),
+		Author:      strings.TrimSpace(ch.Author),
+	}
+
+	imageURL := firstNonEmpty(ch.Image.Href, ch.Image.URL)
+
+	for _, it := range ch.Items {
+		if it.Enclosure.URL == "" {
+			continue
+		}
+
+		ep := PodcastEpisode{
+			Title:       strings.TrimSpace(it.Title),
+			Description: strings.TrimSpace(firstNonEmpty(it.Summary, it.Description, it.Content)),
+			Author:      strings.TrimSpace(firstNonEmpty(it.Author, ch.Author)),
+			URL:         strings.TrimSpace(it.Enclosure.URL),
+			MIMEType:    it.Enclosure.Type,
+			ImageURL:    firstNonEmpty(it.Image.Href, imageURL),
+			PublishedAt: parseFeedTime(it.PubDate),
+		}
+
+		ep.ContentLength, _ = strconv.ParseInt(strings.TrimSpace(it.Enclosure.Length), 10, 64)
+		ep.Duration = parseFeedDuration(it.Duration)
+
+		feed.Episodes = append(feed.Episodes, ep.withDefaults())
+	}
+
+	return feed, nil
+}
+
+type atomImportLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomImportEntry struct {
+	Title     string           `xml:"title"`
+	Summary   string           `xml:"summary"`
+	Content   string           `xml:"content"`
+	Published string           `xml:"published"`
+	Updated   string           `xml:"updated"`
+	Author    string           `xml:"author>name"`
+	Links     []atomImportLink `xml:"link"`
+}
+
+func parseAtomDocument(data []byte) (importedFeed, error) {
+	var doc struct {
+		Title    string            `xml:"title"`
+		Subtitle string            `xml:"subtitle"`
+		Author   string            `xml:"author>name"`
+		Logo     string            `xml:"logo"`
+		Entries  []atomImportEntry `xml:"entry"`
+	}
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return importedFeed{}, fmt.Errorf("failed to parse Atom feed: %w", err)
+	}
+
+	feed := importedFeed{
+		Title:       strings.TrimSpace(doc.Title),
+		Description: strings.TrimSpace(doc.Subtitle),
+		Author:      strings.TrimSpace(doc.Author),
+	}
+
+	for _, e := range doc.Entries {
+		for _, l := range e.Links {
+			if l.Rel != "enclosure" || l.Href == "" {
+				continue
+			}
+
+			ep := PodcastEpisode{
+				Title:       strings.TrimSpace(e.Title),
+				Description: strings.TrimSpace(firstNonEmpty(e.Summary, e.Content)),
+				Author:      strings.TrimSpace(firstNonEmpty(e.Author, doc.Author)),
+				URL:         strings.TrimSpace(l.Href),
+				MIMEType:    l.Type,
+				ImageURL:    doc.Logo,
+				PublishedAt: parseFeedTime(firstNonEmpty(e.Published, e.Updated)),
+			}
+
+			ep.ContentLength, _ = strconv.ParseInt(strings.TrimSpace(l.Length), 10, 64)
+
+			feed.Episodes = append(feed.Episodes, ep.withDefaults())
+
+			break
+		}
+	}
+
+	return feed, nil
+}
+
+type opmlImportOutline struct {
+	XMLURL   string              `xml:"xmlUrl,attr"`
+	Outlines []opmlImportOutline `xml:"outline"`
+}
+
+func parseOPMLDocument(data []byte) (importedFeed, error) {
+	var doc struct {
+		Title    string              `xml:"head>title"`
+		Outlines []opmlImportOutline `xml:"body>outline"`
+	}
+
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return importedFeed{}, fmt.Errorf("failed to parse OPML document: %w", err)
+	}
+
+	feed := importedFeed{Title: strings.TrimSpace(doc.Title)}
+
+	// outlines can be grouped into categories
+	var walk func([]opmlImportOutline)
+	walk = func(outlines []opmlImportOutline) {
+		for _, o := range outlines {
+			if u := strings.TrimSpace(o.XMLURL); u != "" {
+				feed.Feeds = append(feed.Feeds, u)
+			}
+
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Outlines)
+
+	if len(feed.Feeds) == 0 {
+		return feed, fmt.Errorf("%w: OPML document does not reference any feeds", ErrInvalidRequest)
+	}
+
+	return feed, nil
+}
+
+// withDefaults returns the episode with the title taken from the file name if it's empty.
+func (ep PodcastEpisode) withDefaults() PodcastEpisode {
+	if ep.Title == "" {
+		if u, err := url.Parse(ep.URL); err == nil {
+			ep.Title = path.Base(u.Path)
+		}
+	}
+
+	if ep.Description == "" {
+		ep.Description = ep.Title
+	}
+
+	return ep
+}
+
+// feedTimeFormats lists date formats used by RSS and Atom feeds in the wild.
+var feedTimeFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// rfc822Zones maps time zone names defined by RFC 822 to their offsets in seconds. time.Parse only knows
+// the offset of UTC and the zones of the local time, and assumes zero offset for the rest of them.
+var rfc822Zones = map[string]int{
+	"GMT": 0,
+	"EST": -5 * 3600,
+	"EDT": -4 * 3600,
+	"CST": -6 * 3600,
+	"CDT": -5 * 3600,
+	"MST": -7 * 3600,
+	"MDT": -6 * 3600,
+	"PST": -8 * 3600,
+	"PDT": -7 * 3600,
+}
+
+// parseFeedTime parses the publication date of an episode. It returns zero time if the date is malformed.
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+
+	if strings.HasSuffix(s, " UT") { // time.Parse expects zone names to have at least three letters
+		s += "C"
+	}
+
+	for _, layout := range feedTimeFormats {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+
+		name, offset := t.Zone()
+		if rfcOffset, ok := rfc822Zones[name]; ok {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+				time.FixedZone(name, rfcOffset))
+		}
+
+		if offset == 0 && name != "" && name != "UTC" && name != "Z" {
+			log.Printf("unknown time zone %s in episode publication date %q, assuming UTC", name, s)
+		}
+
+		return t
+	}
+
+	log.Printf("malformed episode publication date %q", s)
+
+	return time.Time{}
+}
+
+// parseFeedDuration parses the itunes:duration value that is either a number of seconds or a timestamp
+// in [hh:]mm:ss format.
+func parseFeedDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if d, ok := parseClockTime(s); ok {
+		return d
+	}
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+
+	return 0
+}
+
+// firstNonEmpty returns the first of the strings that is not blank.
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if strings.TrimSpace(s) != "" {
+			return s
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFeedTime(t *testing.T) {
+	est, pdt := time.FixedZone("EST", -5*3600), time.FixedZone("PDT", -7*3600)
+
+	for _, tc := range []struct {
+		Input    string
+		Expected time.Time
+	}{
+		{"Mon, 02 Jan 2017 10:00:00 +0000", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 +0200", time.Date(2017, 1, 2, 8, 0, 0, 0, time.UTC)},
+		{"Mon, 2 Jan 2017 10:00:00 -0000", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 GMT", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"Mon, 2 Jan 2017 10:00:00 UT", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 UTC", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 EST", time.Date(2017, 1, 2, 10, 0, 0, 0, est)},
+		{"Mon, 02 Jan 2017 10:00:00 EDT", time.Date(2017, 1, 2, 14, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 CST", time.Date(2017, 1, 2, 16, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 CDT", time.Date(2017, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 MST", time.Date(2017, 1, 2, 17, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 MDT", time.Date(2017, 1, 2, 16, 0, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2017 10:00:00 PST", time.Date(2017, 1, 2, 18, 0, 0, 0, time.UTC)},
+		{"Mon, 2 Jan 2017 10:00:00 PDT", time.Date(2017, 1, 2, 10, 0, 0, 0, pdt)},
+		{"Mon, 2 Jan 2017 10:00 PST", time.Date(2017, 1, 2, 18, 0, 0, 0, time.UTC)},
+		{"Mon, 2 Jan 2017 10:00 +0100", time.Date(2017, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{"2 Jan 2017 10:00:00 EST", time.Date(2017, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{"2 Jan 2017 10:00:00 -0500", time.Date(2017, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{"2017-01-02T10:00:00Z", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"2017-01-02T10:00:00.5+01:00", time.Date(2017, 1, 2, 9, 0, 0, 5e8, time.UTC)},
+		{"2017-01-02T10:00:00", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"2017-01-02", time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"  Mon, 02 Jan 2017 10:00:00 GMT\n", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		// unknown zone names are assumed to be UTC
+		{"Mon, 02 Jan 2017 10:00:00 BST", time.Date(2017, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+		{"32 Jan 2017 10:00:00 GMT", time.Time{}},
+	} {
+		t.Run(tc.Input, func(t *testing.T) {
+			if got := parseFeedTime(tc.Input); !got.Equal(tc.Expected) {
+				t.Errorf("parseFeedTime(%q) = %s, expected %s", tc.Input, got, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestParseFeedDuration(t *testing.T) {
+	for _, tc := range []struct {
+		Input    string
+		Expected time.Duration
+	}{
+		{"125", 125 * time.Second},
+		{"125.5", 125500 * time.Millisecond},
+		{"02:05", 2*time.Minute + 5*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{" 1:02:03 ", time.Hour + 2*time.Minute + 3*time.Second},
+		{"0", 0},
+		{"", 0},
+		{"   ", 0},
+		{"-125", 0},
+		{"-1:30", 0},
+		{"1:-30", 0},
+		{"+1:30", 0},
+		{"1::30", 0},
+		{"1:30:", 0},
+		{"1h30m", 0},
+	} {
+		t.Run(tc.Input, func(t *testing.T) {
+			if got := parseFeedDuration(tc.Input); got != tc.Expected {
+				t.Errorf("parseFeedDuration(%q) = %s, expected %s", tc.Input, got, tc.Expected)
+			}
+		})
+	}
+}
+
+func TestParseFeedDocument(t *testing.T) {
+	for name, tc := range map[string]struct {
+		Input    string
+		Expected importedFeed
+	}{
+		"rss": {
+			Input: `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel>
+	<title> Show </title>
+	<description>About the show</description>
+	<itunes:author>Jane</itunes:author>
+	<itunes:image href="https://example.com/show.jpg"/>
+	<item>
+		<title>Episode 1</title>
+		<description>First</description>
+		<pubDate>Mon, 02 Jan 2017 10:00:00 EST</pubDate>
+		<enclosure url="https://example.com/ep1.mp3" type="audio/mpeg" length="1234"/>
+		<itunes:duration>1:02:03</itunes:duration>
+	</item>
+	<item>
+		<title>Episode 2</title>
+		<content:encoded>Second</content:encoded>
+		<itunes:summary>Summary</itunes:summary>
+		<itunes:author>John</itunes:author>
+		<itunes:image href="https://example.com/ep2.jpg"/>
+		<pubDate>Tue, 3 Jan 2017 10:00:00 GMT</pubDate>
+		<enclosure url=" https://example.com/ep2.mp3 " type="audio/mpeg" length=""/>
+		<itunes:duration>-1</itunes:duration>
+	</item>
+	<item>
+		<enclosure url="https://example.com/files/ep3.m4a"/>
+	</item>
+	<item>
+		<title>Blog post</title>
+	</item>
+</channel>
+</rss>`,
+			Expected: importedFeed{
+				Title:       "Show",
+				Description: "About the show",
+				Author:      "Jane",
+				Episodes: []PodcastEpisode{
+					{
+						Title:         "Episode 1",
+						Description:   "First",
+						Author:        "Jane",
+						URL:           "https://example.com/ep1.mp3",
+						MIMEType:      "audio/mpeg",
+						ContentLength: 1234,
+						Duration:      time.Hour + 2*time.Minute + 3*time.Second,
+						ImageURL:      "https://example.com/show.jpg",
+						PublishedAt:   time.Date(2017, 1, 2, 15, 0, 0, 0, time.UTC),
+					},
+					{
+						Title:       "Episode 2",
+						Description: "Summary",
+						Author:      "John",
+						URL:         "https://example.com/ep2.mp3",
+						MIMEType:    "audio/mpeg",
+						ImageURL:    "https://example.com/ep2.jpg",
+						PublishedAt: time.Date(2017, 1, 3, 10, 0, 0, 0, time.UTC),
+					},
+					{
+						Title:       "ep3.m4a",
+						Description: "ep3.m4a",
+						Author:      "Jane",
+						URL:         "https://example.com/files/ep3.m4a",
+						ImageURL:    "https://example.com/show.jpg",
+					},
+				},
+			},
+		},
+		"rss with channel image": {
+			Input: `<rss><channel><title>Show</title><image><url>https://example.com/logo.png</url></image>
+				<item><title>Episode</title><enclosure url="https://example.com/ep.mp3"/></item></channel></rss>`,
+			Expected: importedFeed{
+				Title: "Show",
+				Episodes: []PodcastEpisode{
+					{
+						Title:       "Episode",
+						Description: "Episode",
+						URL:         "https://example.com/ep.mp3",
+						ImageURL:    "https://example.com/logo.png",
+					},
+				},
+			},
+		},
+		"atom": {
+			Input: `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Atom Show</title>
+	<subtitle>About</subtitle>
+	<author><name>Jane</name></author>
+	<logo>https://example.com/logo.png</logo>
+	<entry>
+		<title>Entry 1</title>
+		<summary>First</summary>
+		<published>2017-01-02T10:00:00-05:00</published>
+		<updated>2017-01-05T10:00:00Z</updated>
+		<link rel="alternate" href="https://example.com/entry1"/>
+		<link rel="enclosure" href="https://example.com/entry1.mp3" type="audio/mpeg" length="42"/>
+	</entry>
+	<entry>
+		<title>Entry 2</title>
+		<content>Second</content>
+		<updated>2017-01-03T10:00:00Z</updated>
+		<author><name>John</name></author>
+		<link rel="enclosure" href="https://example.com/entry2.mp3"/>
+		<link rel="enclosure" href="https://example.com/entry2.ogg"/>
+	</entry>
+	<entry>
+		<title>Text only</title>
+		<link rel="alternate" href="https://example.com/entry3"/>
+	</entry>
+</feed>`,
+			Expected: importedFeed{
+				Title:       "Atom Show",
+				Description: "About",
+				Author:      "Jane",
+				Episodes: []PodcastEpisode{
+					{
+						Title:         "Entry 1",
+						Description:   "First",
+						Author:        "Jane",
+						URL:           "https://example.com/entry1.mp3",
+						MIMEType:      "audio/mpeg",
+						ContentLength: 42,
+						ImageURL:      "https://example.com/logo.png",
+						PublishedAt:   time.Date(2017, 1, 2, 15, 0, 0, 0, time.UTC),
+					},
+					{
+						Title:       "Entry 2",
+						Description: "Second",
+						Author:      "John",
+						URL:         "https://example.com/entry2.mp3",
+						ImageURL:    "https://example.com/logo.png",
+						PublishedAt: time.Date(2017, 1, 3, 10, 0, 0, 0, time.UTC),
+					},
+				},
+			},
+		},
+		"opml": {
+			Input: `<?xml version="1.0"?>
+<opml version="2.0">
+	<head><title>Subscriptions</title></head>
+	<body>
+		<outline text="Show" type="rss" xmlUrl="https://example.com/feed.xml"/>
+		<outline text="Category">
+			<outline text="Nested" type="rss" xmlUrl=" https://example.org/rss "/>
+			<outline text="Deeper">
+				<outline text="Atom" xmlUrl="https://example.net/atom.xml"/>
+			</outline>
+		</outline>
+		<outline text="Link" url="https://example.com"/>
+	</body>
+</opml>`,
+			Expected: importedFeed{
+				Title: "Subscriptions",
+				Feeds: []string{"https://example.com/feed.xml", "https://example.org/rss", "https://example.net/atom.xml"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			feed, err := parseFeedDocument([]byte(tc.Input))
+			if err != nil {
+				t.Fatalf("parseFeedDocument() returned an error: %s", err)
+			}
+
+			if len(feed.Episodes) != len(tc.Expected.Episodes) {
+				t.Fatalf("expected %d episode(s), got %d: %+v", len(tc.Expected.Episodes), len(feed.Episodes), feed.Episodes)
+			}
+
+			for i, ep := range feed.Episodes {
+				expected := tc.Expected.Episodes[i]
+				if !ep.PublishedAt.Equal(expected.PublishedAt) {
+					t.Errorf("episode %d: expected publication date %s, got %s", i, expected.PublishedAt, ep.PublishedAt)
+				}
+
+				ep.PublishedAt, expected.PublishedAt = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(ep, expected) {
+					t.Errorf("episode %d:\nexpected %+v\n     got %+v", i, expected, ep)
+				}
+			}
+
+			feed.Episodes, tc.Expected.Episodes = nil, nil
+			if !reflect.DeepEqual(feed, tc.Expected) {
+				t.Errorf("expected %+v, got %+v", tc.Expected, feed)
+			}
+		})
+	}
+}
+
+func TestParseFeedDocument_Errors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		Input      string
+		InvalidReq bool
+	}{
+		"empty":              {Input: ""},
+		"not xml":            {Input: "{\"title\": \"json\"}"},
+		"truncated":          {Input: "<rss><channel><title>Show"},
+		"unsupported format": {Input: `<html><body>Not a feed</body></html>`, InvalidReq: true},
+		"opml without feeds": {Input: `<opml><head><title>Empty</title></head><body><outline text="Link" url="https://example.com"/></body></opml>`, InvalidReq: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseFeedDocument([]byte(tc.Input))
+			if err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+
+			if errors.Is(err, ErrInvalidRequest) != tc.InvalidReq {
+				t.Errorf("expected errors.Is(err, ErrInvalidRequest) to be %t, got error %q", tc.InvalidReq, err)
+			}
+		})
+	}
+}
@@ -1,46 +1,173 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime"
+	"net/http"
 	"os"
 	"path"
+	"time"
+)
+
+// maxArtworkSize is the maximum size of an episode artwork downloaded from a remote location.
+const maxArtworkSize = 10 << 20
+
+var (
+	// ErrCannotRetry is returned when an item that is not failed or cancelled is requested to be retried.
+	ErrCannotRetry = errors.New("only failed or cancelled items can be retried")
+	// ErrNotInProgress is returned when an item that is not being downloaded is requested to be cancelled.
+	ErrNotInProgress = errors.New("item is not being downloaded")
 )
 
 type storage interface {
 	Add(PodcastItem) error
 	Remove(string) (PodcastItem, error)
 	UpdateDescription(string, Description) (PodcastItem, error)
+	UpdateStatus(string, Status) (PodcastItem, error)
+	Item(string) (PodcastItem, error)
 	Items() ([]PodcastItem, error)
+	ItemsPage(after string, limit int) ([]PodcastItem, string, error)
+	Find(ItemFilter) ([]PodcastItem, error)
 }
 
 // FeedService is a service that manages podcast items.
 type FeedService struct {
+	feed        string
 	q           *DownloadJobQueue
 	st          storage
 	storagePath string
 }
 
-// NewFeedService creates a new FeedService instance.
+// NewFeedService creates a new FeedService instance that manages items of the given feed.
 func NewFeedService(
+	feed string,
 	st storage,
 	storagePath string,
 	q *DownloadJobQueue,
-	downloader fileDownloader,
-	converter mediaTranscoder,
 ) *FeedService {
 	return &FeedService{
+		feed:        feed,
 		st:          st,
 		storagePath: storagePath,
 		q:           q,
 	}
 }
 
+// Feed returns the slug of the feed managed by this service.
+func (s *FeedService) Feed() string {
+	return s.feed
+}
+
+// AddSource fetches the metadata of an audio source and adds it to the feed. It returns the added item.
+func (s *FeedService) AddSource(ctx context.Context, src audioSource) (PodcastItem, error) {
+	return s.addSource(ctx, src, time.Now())
+}
+
+// AddSourceList adds an item for each source referenced by the list, i.e. for each video in a playlist. Items are
+// added in the list order, so that the first entry becomes the oldest one, unless their metadata contains the
+// original publication time. Entries that failed to be added are logged and skipped. It returns the added items.
+func (s *FeedService) AddSourceList(ctx context.Context, list audioSourceList) ([]PodcastItem, error) {
+	items, err := s.st.Items()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed items: %w", err)
+	}
+
+	existing := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		if item.OriginalURL != "" {
+			existing[item.OriginalURL] = struct{}{}
+		}
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	srcs, err := list.Sources(listCtx, func(originalURL string) bool {
+		_, ok := existing[originalURL]
+		return ok
+	})
+	cancel()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch list entries: %w", err)
+	}
+
+	log.Printf("adding %d list entries to %s", len(srcs), s.feed)
+
+	var (
+		added   []PodcastItem
+		addedAt = time.Now()
+	)
+	for i, src := range srcs {
+		srcCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		// item IDs are derived from AddedAt, so each entry gets its own timestamp
+		item, err := s.addSource(srcCtx, src, addedAt.Add(time.Duration(i)*time.Millisecond))
+		cancel()
+
+		if err != nil {
+			log.Printf("failed to add list entry %d of %d to %s: %s", i+1, len(srcs), s.feed, err)
+			continue
+		}
+
+		added = append(added, item)
+	}
+
+	return added, nil
+}
+
+func (s *FeedService) addSource(ctx context.Context, src audioSource, addedAt time.Time) (PodcastItem, error) {
+	meta, err := src.Metadata(ctx)
+	if err != nil {
+		return PodcastItem{}, fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+
+	u, err := src.DownloadURL(ctx)
+	if err != nil {
+		return PodcastItem{}, fmt.Errorf("failed to fetch download URL: %w", err)
+	}
+
+	if !meta.PublishedAt.IsZero() {
+		if addedAt, err = s.vacantAddedAt(meta.PublishedAt); err != nil {
+			return PodcastItem{}, err
+		}
+	}
+
+	item := NewPodcastItem(meta, addedAt)
+	if item.ImageFileName, err = s.storeArtwork(ctx, s.mediaFileStem(u), meta); err != nil {
+		log.Printf("failed to store artwork for %s: %s", item.Title, err)
+	}
+
+	if err := s.AddItem(item, u); err != nil {
+		return PodcastItem{}, err
+	}
+
+	return s.Item(item.ID())
+}
+
+// vacantAddedAt returns the earliest time not before t that can be used as the time an item is added at. Item IDs
+// are derived from this time, so items published at the same time are added a nanosecond apart.
+func (s *FeedService) vacantAddedAt(t time.Time) (time.Time, error) {
+	for {
+		_, err := s.st.Item(PodcastItem{AddedAt: t}.ID())
+		if err == ErrItemNotFound {
+			return t, nil
+		}
+
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to fetch item: %w", err)
+		}
+
+		t = t.Add(time.Nanosecond)
+	}
+}
+
 // AddItem adds a new podcast item to the feed.
 func (s *FeedService) AddItem(item PodcastItem, audioURL string) error {
-	filePath := path.Join(s.storagePath, fmt.Sprintf("%x", sha256.Sum256([]byte(audioURL))))
+	filePath := s.mediaFileStem(audioURL)
 	if exts, err := mime.ExtensionsByType(item.MIMEType); err != nil {
 		log.Printf("failed to get file extensions list for %s: %s", item.MIMEType, err)
 	} else if len(exts) == 0 {
@@ -54,47 +181,215 @@ func (s *FeedService) AddItem(item PodcastItem, audioURL string) error {
 		filePath += exts[0]
 	}
 
-	item.FileName, item.Status = path.Base(filePath), ItemAdded
+	item.FileName, item.SourceURL, item.Status = path.Base(filePath), audioURL, ItemAdded
 	if err := s.st.Add(item); err != nil {
 		return fmt.Errorf("failed to add item to the feed: %w", err)
 	}
 
-	if err := s.q.Add(NewDownloadJob(item.ID(), audioURL, filePath)); err != nil {
+	if err := s.q.Add(NewDownloadJob(s.feed, item.ID(), audioURL, filePath)); err != nil {
 		return fmt.Errorf("failed to add download job for %s: %w", audioURL, err)
 	}
 
 	return nil
 }
 
+// ImportItem adds a podcast item exported from another instance to the feed keeping its ID and files. Items
+// that were still being processed are added as cancelled, so that they can be retried.
+func (s *FeedService) ImportItem(item PodcastItem) error {
+	if !item.Playable() && !item.Failed() {
+		item.Status = ItemCancelled
+	}
+
+	if err := s.st.Add(item); err != nil {
+		return fmt.Errorf("failed to add item to the feed: %w", err)
+	}
+
+	return nil
+}
+
+// mediaFileStem returns the path to the downloaded media file without extension. Files related to the item,
+// such as artwork, are stored next to it with the same name and a different extension.
+func (s *FeedService) mediaFileStem(audioURL string) string {
+	// all feeds share the same storage directory, so the feed slug is mixed into the
+	// file name to let the same media be added to several feeds
+	fileKey := audioURL
+	if s.feed != DefaultFeed {
+		fileKey = s.feed + ":" + audioURL
+	}
+
+	return path.Join(s.storagePath, fmt.Sprintf("%x", sha256.Sum256([]byte(fileKey))))
+}
+
+// storeArtwork saves the episode artwork either embedded into the media file or downloaded from
+// the remote location next to the media file. It returns the name of the stored file or an empty
+// string if there is no artwork.
+func (s *FeedService) storeArtwork(ctx context.Context, fileStem string, meta Metadata) (string, error) {
+	var (
+		r        io.Reader
+		mimeType = meta.ImageMIMEType
+	)
+
+	switch {
+	case len(meta.Image) > 0:
+		r = bytes.NewReader(meta.Image)
+	case meta.ImageURL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.ImageURL, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to download %s: %w", meta.ImageURL, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download %s: %s", meta.ImageURL, resp.Status)
+		}
+
+		r, mimeType = io.LimitReader(resp.Body, maxArtworkSize), resp.Header.Get("Content-Type")
+	default:
+		return "", nil
+	}
+
+	filePath := fileStem + artworkExt(mimeType)
+
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filePath, err)
+	}
+	defer fd.Close()
+
+	if _, err := io.Copy(fd, r); err != nil {
+		os.Remove(filePath)
+		return "", fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+
+	return path.Base(filePath), nil
+}
+
+// artworkExt returns the file extension for an image MIME type. Podcast apps expect artwork
+// to be either JPEG or PNG, so the former is used by default.
+func artworkExt(mimeType string) string {
+	switch mimeType, _, _ := mime.ParseMediaType(mimeType); mimeType {
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
 // UpdateItem updates an existing podcast item.
-func (s *FeedService) UpdateItem(itemID string, desc Description) error {
+func (s *FeedService) UpdateItem(itemID string, desc Description) (PodcastItem, error) {
 	log.Printf("updating %s", itemID)
 
-	_, err := s.st.UpdateDescription(itemID, desc)
+	return s.st.UpdateDescription(itemID, desc)
+}
+
+// RetryItem re-enqueues the download job for a failed or cancelled podcast item. If the file has
+// already been downloaded, only the processing is repeated.
+func (s *FeedService) RetryItem(itemID string) error {
+	item, err := s.st.Item(itemID)
 	if err != nil {
 		return err
 	}
 
+	if !item.Failed() && !item.Cancelled() {
+		return ErrCannotRetry
+	}
+
+	log.Printf("retrying %s", itemID)
+
+	filePath := path.Join(s.storagePath, item.FileName)
+
+	job := NewDownloadJob(s.feed, itemID, item.SourceURL, filePath)
+	job.Refresh = true
+
+	newStatus := ItemAdded
+	if _, err := os.Stat(filePath); err == nil {
+		job.Status, newStatus = StatusDownloaded, ItemDownloaded
+	}
+
+	if _, err := s.st.UpdateStatus(itemID, newStatus); err != nil {
+		return err
+	}
+
+	if err := s.q.Add(job); err != nil {
+		return fmt.Errorf("failed to add download job for %s: %w", itemID, err)
+	}
+
 	return nil
 }
 
-// RemoveItem removes an existing podcast item.
+// CancelItem cancels the download of a podcast item aborting it if it's already running.
+func (s *FeedService) CancelItem(itemID string) error {
+	item, err := s.st.Item(itemID)
+	if err != nil {
+		return err
+	}
+
+	if !item.InProgress() {
+		return ErrNotInProgress
+	}
+
+	log.Printf("cancelling %s", itemID)
+
+	active, err := s.q.Cancel(s.feed, itemID)
+	if err != nil && err != ErrJobNotFound {
+		return fmt.Errorf("failed to cancel download job for %s: %w", itemID, err)
+	}
+
+	if active {
+		return nil // the worker updates the status once the job is stopped
+	}
+
+	_, err = s.st.UpdateStatus(itemID, ItemCancelled)
+
+	return err
+}
+
+// RemoveItem removes an existing podcast item cancelling its download if it is still in progress.
 func (s *FeedService) RemoveItem(itemID string) error {
 	log.Printf("removing %s", itemID)
 
+	if _, err := s.q.Cancel(s.feed, itemID); err != nil && err != ErrJobNotFound {
+		log.Printf("failed to cancel download job for %s: %s", itemID, err)
+	}
+
 	item, err := s.st.Remove(itemID)
 	if err != nil {
 		return err
 	}
 
 	filePath := path.Join(s.storagePath, item.FileName)
-	if err := os.Remove(filePath); err != nil {
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete %s: %w", filePath, err)
 	}
 
+	if item.ImageFileName != "" {
+		imagePath := path.Join(s.storagePath, item.ImageFileName)
+		if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", imagePath, err)
+		}
+	}
+
+	if item.TranscriptFileName != "" {
+		if err := removeTranscriptFiles(path.Join(s.storagePath, item.TranscriptFileName)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Item returns a podcast item by its ID.
+func (s *FeedService) Item(itemID string) (PodcastItem, error) {
+	return s.st.Item(itemID)
+}
+
 // Items returns a list of podcast items.
 func (s *FeedService) Items() ([]PodcastItem, error) {
 	items, err := s.st.Items()
@@ -104,3 +399,24 @@ func (s *FeedService) Items() ([]PodcastItem, error) {
 
 	return items, nil
 }
+
+// Find returns podcast items matching the filter, newest first.
+func (s *FeedService) Find(filter ItemFilter) ([]PodcastItem, error) {
+	items, err := s.st.Find(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find podcast items: %w", err)
+	}
+
+	return items, nil
+}
+
+// ItemsPage returns up to limit podcast items added before the one with ID after, newest first, along with
+// the cursor to fetch the next page with. The cursor is empty if there are no more items.
+func (s *FeedService) ItemsPage(after string, limit int) ([]PodcastItem, string, error) {
+	items, next, err := s.st.ItemsPage(after, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch podcast items: %w", err)
+	}
+
+	return items, next, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxCachedFeeds limits the number of rendered feeds kept in memory. Feeds are cached per format and
+// per URL, since the URL of each subscriber contains their access token.
+const maxCachedFeeds = 256
+
+// renderedFeed is a feed rendered for a certain feed revision.
+type renderedFeed struct {
+	Revision Revision
+	ETag     string
+	Body     []byte
+	Gzipped  []byte
+}
+
+// newRenderedFeed returns a rendered feed with the ETag derived from the revision and the content.
+func newRenderedFeed(rev Revision, body []byte) (*renderedFeed, error) {
+	h := fnv.New64a()
+	h.Write(body)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to compress feed: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress feed: %w", err)
+	}
+
+	return &renderedFeed{
+		Revision: rev,
+		ETag:     fmt.Sprintf(`W/"%d-%x"`, rev.Number, h.Sum64()),
+		Body:     body,
+		Gzipped:  buf.Bytes(),
+	}, nil
+}
+
+// ServeHTTP responds with the rendered feed. Conditional and range requests are handled by http.ServeContent,
+// the response is compressed if the client accepts gzip encoding.
+func (f *renderedFeed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("ETag", f.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept, Accept-Encoding")
+
+	body := f.Body
+	if acceptsGzip(req) {
+		w.Header().Set("Content-Encoding", "gzip")
+		body = f.Gzipped
+	}
+
+	http.ServeContent(w, req, "", f.Revision.ModifiedAt, bytes.NewReader(body))
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of the request allows gzip-encoded responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, s := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(s, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+
+		v, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+
+		q, err := strconv.ParseFloat(v, 64)
+
+		return err == nil && q > 0
+	}
+
+	return false
+}
+
+// feedCache keeps rendered feeds in memory until the feed revision changes.
+type feedCache struct {
+	mu      sync.Mutex
+	entries map[string]*renderedFeed
+}
+
+func newFeedCache() *feedCache {
+	return &feedCache{entries: make(map[string]*renderedFeed)}
+}
+
+// Get returns the feed cached under the key if it has been rendered for given revision.
+func (c *feedCache) Get(key string, rev Revision) (*renderedFeed, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.entries[key]
+	if !ok || f.Revision.Number != rev.Number || !f.Revision.ModifiedAt.Equal(rev.ModifiedAt) {
+		return nil, false
+	}
+
+	return f, true
+}
+
+// Put stores the rendered feed under the key replacing the previously cached revision. The cache is
+// flushed once it grows over maxCachedFeeds.
+func (c *feedCache) Put(key string, f *renderedFeed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedFeeds {
+		clear(c.entries)
+	}
+
+	c.entries[key] = f
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderedFeed_ServeHTTP(t *testing.T) {
+	body := []byte(`<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Test</title></channel></rss>`)
+	rev := Revision{Number: 42, ModifiedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+
+	f, err := newRenderedFeed(rev, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		if etag := rec.Header().Get("ETag"); etag != f.ETag {
+			t.Errorf("expected ETag %q, got %q", f.ETag, etag)
+		}
+
+		if lm := rec.Header().Get("Last-Modified"); lm != rev.ModifiedAt.Format(http.TimeFormat) {
+			t.Errorf("unexpected Last-Modified %q", lm)
+		}
+
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("unexpected Content-Encoding %q", enc)
+		}
+
+		if !bytes.Equal(rec.Body.Bytes(), body) {
+			t.Errorf("unexpected body %q", rec.Body.String())
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, req)
+
+		if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("expected gzip Content-Encoding, got %q", enc)
+		}
+
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, body) {
+			t.Errorf("unexpected decompressed body %q", data)
+		}
+	})
+
+	for name, header := range map[string][2]string{
+		"if-none-match":           {"If-None-Match", f.ETag},
+		"if-none-match list":      {"If-None-Match", `"other", ` + f.ETag},
+		"if-modified-since":       {"If-Modified-Since", rev.ModifiedAt.Format(http.TimeFormat)},
+		"if-modified-since later": {"If-Modified-Since", rev.ModifiedAt.Add(time.Hour).Format(http.TimeFormat)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+			req.Header.Set(header[0], header[1])
+
+			rec := httptest.NewRecorder()
+			f.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("expected %d, got %d", http.StatusNotModified, rec.Code)
+			}
+
+			if rec.Body.Len() != 0 {
+				t.Errorf("expected empty body, got %q", rec.Body.String())
+			}
+		})
+	}
+
+	for name, header := range map[string][2]string{
+		"stale etag":     {"If-None-Match", `W/"41-0"`},
+		"modified since": {"If-Modified-Since", rev.ModifiedAt.Add(-time.Hour).Format(http.TimeFormat)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+			req.Header.Set(header[0], header[1])
+
+			rec := httptest.NewRecorder()
+			f.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("expected %d, got %d", http.StatusOK, rec.Code)
+			}
+		})
+	}
+}
+
+func TestNewRenderedFeed_ETag(t *testing.T) {
+	rev := Revision{Number: 1, ModifiedAt: time.Now()}
+
+	f1, _ := newRenderedFeed(rev, []byte("feed"))
+	f2, _ := newRenderedFeed(rev, []byte("feed"))
+	f3, _ := newRenderedFeed(rev, []byte("another feed"))
+	f4, _ := newRenderedFeed(Revision{Number: 2, ModifiedAt: rev.ModifiedAt}, []byte("feed"))
+
+	if f1.ETag != f2.ETag {
+		t.Errorf("expected the same content to have the same ETag, got %q and %q", f1.ETag, f2.ETag)
+	}
+
+	if f1.ETag == f3.ETag {
+		t.Errorf("expected different content to have different ETags, got %q", f1.ETag)
+	}
+
+	if f1.ETag == f4.ETag {
+		t.Errorf("expected different revisions to have different ETags, got %q", f1.ETag)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		AcceptEncoding string
+		Expected       bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=1.0", true},
+		{"gzip;q=0", false},
+		{"br, deflate", false},
+		{"identity", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+		req.Header.Set("Accept-Encoding", tc.AcceptEncoding)
+
+		if actual := acceptsGzip(req); actual != tc.Expected {
+			t.Errorf("acceptsGzip(%q) = %t, expected %t", tc.AcceptEncoding, actual, tc.Expected)
+		}
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	c := newFeedCache()
+	rev := Revision{Number: 1, ModifiedAt: time.Now()}
+
+	f, _ := newRenderedFeed(rev, []byte("feed"))
+	c.Put("rss:/feed", f)
+
+	if cached, ok := c.Get("rss:/feed", rev); !ok || cached != f {
+		t.Errorf("expected the feed to be cached")
+	}
+
+	if _, ok := c.Get("rss:/feed", Revision{Number: 2, ModifiedAt: rev.ModifiedAt}); ok {
+		t.Errorf("expected a newer revision not to be cached")
+	}
+
+	if _, ok := c.Get("json:/feed", rev); ok {
+		t.Errorf("expected another key not to be cached")
+	}
+}
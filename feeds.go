@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// DefaultFeed is the slug of the feed served at /feed and used whenever no feed is specified.
+const DefaultFeed = "default"
+
+var (
+	// ErrFeedNotFound is returned when a feed is not found in the registry.
+	ErrFeedNotFound = errors.New("no such feed")
+	// ErrFeedExists is returned when a feed with the same slug is already registered.
+	ErrFeedExists = errors.New("feed already exists")
+	// ErrInvalidFeedSlug is returned when a feed slug contains unsupported characters.
+	ErrInvalidFeedSlug = errors.New("feed slug must consist of lowercase letters, digits, dashes and underscores")
+)
+
+var feedSlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// PodcastFeed is a named podcast feed served by YouCast.
+type PodcastFeed struct {
+	Slug string
+	PodcastMetadata
+	// TranscodingProfile is the name of the profile used to transcode feed items, the default one is used if empty.
+	TranscodingProfile string
+	// PostProcessing defines audio filters applied to feed items while transcoding.
+	PostProcessing PostProcessing
+}
+
+// Path returns the URL path of the feed.
+func (f PodcastFeed) Path() string {
+	if f.Slug == DefaultFeed {
+		return "/feed"
+	}
+
+	return "/feed/" + f.Slug
+}
+
+type boltFeed struct {
+	Title       string `json:",omitempty"`
+	Link        string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	IconURL     string `json:",omitempty"`
+	Profile     string `json:",omitempty"`
+	Loudnorm    bool   `json:",omitempty"`
+	TrimSilence bool   `json:",omitempty"`
+	Compress    bool   `json:",omitempty"`
+	Author      string `json:",omitempty"`
+	Owner       string `json:",omitempty"`
+	Email       string `json:",omitempty"`
+	Language    string `json:",omitempty"`
+	Category    string `json:",omitempty"`
+	Explicit    bool   `json:",omitempty"`
+}
+
+func newBoltFeed(feed PodcastFeed) boltFeed {
+	return boltFeed{
+		feed.Title,
+		feed.Link,
+		feed.Description,
+		feed.IconURL,
+		feed.TranscodingProfile,
+		feed.PostProcessing.Loudnorm,
+		feed.PostProcessing.TrimSilence,
+		feed.PostProcessing.CompressSilence,
+		feed.Author,
+		feed.Owner,
+		feed.Email,
+		feed.Language,
+		feed.Category,
+		feed.Explicit,
+	}
+}
+
+// PodcastFeed converts the stored feed into a PodcastFeed with given slug.
+func (f boltFeed) PodcastFeed(slug string) PodcastFeed {
+	return PodcastFeed{
+		Slug: slug,
+		PodcastMetadata: PodcastMetadata{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			IconURL:     f.IconURL,
+			Author:      f.Author,
+			Owner:       f.Owner,
+			Email:       f.Email,
+			Language:    f.Language,
+			Category:    f.Category,
+			Explicit:    f.Explicit,
+		},
+		TranscodingProfile: f.Profile,
+		PostProcessing: PostProcessing{
+			Loudnorm:        f.Loudnorm,
+			TrimSilence:     f.TrimSilence,
+			CompressSilence: f.Compress,
+		},
+	}
+}
+
+// feedBucket returns the name of the bucket that holds items of the feed.
+func feedBucket(slug string) string {
+	return "feed:" + slug
+}
+
+// FeedRegistry keeps track of podcast feeds served by this instance and provides access to their items.
+// Feeds are stored in BoltDB, while their items are kept in the storage backend.
+type FeedRegistry struct {
+	db          *bolt.DB
+	backend     storageBackend
+	q           *DownloadJobQueue
+	storagePath string
+
+	mu       sync.Mutex
+	services map[string]*FeedService
+	storages map[string]itemStore
+}
+
+// NewFeedRegistry returns a new instance of FeedRegistry.
+func NewFeedRegistry(db *bolt.DB, backend storageBackend, storagePath string, q *DownloadJobQueue) *FeedRegistry {
+	return &FeedRegistry{
+		db:          db,
+		backend:     backend,
+		q:           q,
+		storagePath: storagePath,
+		services:    make(map[string]*FeedService),
+		storages:    make(map[string]itemStore),
+	}
+}
+
+// Create registers a new feed.
+func (r *FeedRegistry) Create(feed PodcastFeed) error {
+	if !feedSlugRe.MatchString(feed.Slug) {
+		return ErrInvalidFeedSlug
+	}
+
+	if err := ValidateTranscodingProfile(feed.TranscodingProfile); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(newBoltFeed(feed))
+	if err != nil {
+		return fmt.Errorf("failed to marshal feed: %w", err)
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("feeds"))
+		if err != nil {
+			return fmt.Errorf("failed to open feeds bucket: %w", err)
+		}
+
+		k := []byte(feed.Slug)
+		if b.Get(k) != nil {
+			return ErrFeedExists
+		}
+
+		if err := b.Put(k, data); err != nil {
+			return fmt.Errorf("failed to store feed %q: %w", feed.Slug, err)
+		}
+
+		return nil
+	})
+}
+
+// Update updates the metadata of an existing feed.
+func (r *FeedRegistry) Update(feed PodcastFeed) error {
+	if err := ValidateTranscodingProfile(feed.TranscodingProfile); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(newBoltFeed(feed))
+	if err != nil {
+		return fmt.Errorf("failed to marshal feed: %w", err)
+	}
+
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("feeds"))
+		if b == nil {
+			return ErrFeedNotFound
+		}
+
+		k := []byte(feed.Slug)
+		if b.Get(k) == nil {
+			return ErrFeedNotFound
+		}
+
+		if err := b.Put(k, data); err != nil {
+			return fmt.Errorf("failed to store feed %q: %w", feed.Slug, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.itemStorage(feed.Slug).Touch()
+}
+
+// Remove deletes the feed along with all its items and downloaded files. The default feed cannot be removed.
+func (r *FeedRegistry) Remove(slug string) error {
+	if slug == DefaultFeed {
+		return errors.New("default feed cannot be removed")
+	}
+
+	svc, err := r.Service(slug)
+	if err != nil {
+		return err
+	}
+
+	items, err := svc.Items()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := svc.RemoveItem(item.ID()); err != nil {
+			return fmt.Errorf("failed to remove item %s from %s: %w", item.ID(), slug, err)
+		}
+	}
+
+	if err := r.itemStorage(slug).Drop(); err != nil {
+		return fmt.Errorf("failed to remove items of %s: %w", slug, err)
+	}
+
+	r.mu.Lock()
+	delete(r.services, slug)
+	delete(r.storages, slug)
+	r.mu.Unlock()
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("feeds"))
+		if b == nil {
+			return ErrFeedNotFound
+		}
+
+		return b.Delete([]byte(slug))
+	})
+}
+
+// Get returns the feed with given slug.
+func (r *FeedRegistry) Get(slug string) (PodcastFeed, error) {
+	if slug == "" {
+		slug = DefaultFeed
+	}
+
+	feed := PodcastFeed{Slug: slug}
+
+	return feed, r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("feeds"))
+		if b == nil {
+			return ErrFeedNotFound
+		}
+
+		v := b.Get([]byte(slug))
+		if v == nil {
+			return ErrFeedNotFound
+		}
+
+		var f boltFeed
+		if err := json.Unmarshal(v, &f); err != nil {
+			return fmt.Errorf("failed to unmarshal feed %q: %w", slug, err)
+		}
+
+		feed = f.PodcastFeed(slug)
+
+		return nil
+	})
+}
+
+// All returns all registered feeds, the default one goes first.
+func (r *FeedRegistry) All() ([]PodcastFeed, error) {
+	var feeds []PodcastFeed
+
+	return feeds, r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		feeds, err = readFeeds(tx)
+
+		return err
+	})
+}
+
+// readFeeds returns all feeds stored in the database within a transaction, the default one goes first.
+func readFeeds(tx *bolt.Tx) ([]PodcastFeed, error) {
+	b := tx.Bucket([]byte("feeds"))
+	if b == nil {
+		return nil, nil
+	}
+
+	var feeds []PodcastFeed
+
+	err := b.ForEach(func(k, v []byte) error {
+		var f boltFeed
+		if err := json.Unmarshal(v, &f); err != nil {
+			return fmt.Errorf("failed to unmarshal feed %q: %w", k, err)
+		}
+
+		feed := f.PodcastFeed(string(k))
+
+		if feed.Slug == DefaultFeed {
+			feeds = append([]PodcastFeed{feed}, feeds...)
+		} else {
+			feeds = append(feeds, feed)
+		}
+
+		return nil
+	})
+
+	return feeds, err
+}
+
+// Service returns a FeedService that manages items of the feed with given slug.
+func (r *FeedRegistry) Service(slug string) (*FeedService, error) {
+	if slug == "" {
+		slug = DefaultFeed
+	}
+
+	if _, err := r.Get(slug); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if svc, ok := r.services[slug]; ok {
+		return svc, nil
+	}
+
+	svc := NewFeedService(slug, r.storage(slug), r.storagePath, r.q)
+	r.services[slug] = svc
+
+	return svc, nil
+}
+
+// UpdateStatus updates the status of an item in the feed with given slug.
+func (r *FeedRegistry) UpdateStatus(slug, itemID string, newStatus Status) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateStatus(itemID, newStatus)
+}
+
+// Item returns an item of the feed with given slug.
+func (r *FeedRegistry) Item(slug, itemID string) (PodcastItem, error) {
+	return r.itemStorage(slug).Item(itemID)
+}
+
+// UpdateError stores the error message of the last failed attempt to process an item of the feed with given slug.
+func (r *FeedRegistry) UpdateError(slug, itemID, msg string) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateError(itemID, msg)
+}
+
+// UpdateImage sets the artwork file name of an item of the feed with given slug.
+func (r *FeedRegistry) UpdateImage(slug, itemID, fileName string) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateImage(itemID, fileName)
+}
+
+// UpdateMedia sets the media file name, the MIME type and the size of an item of the feed with given slug.
+func (r *FeedRegistry) UpdateMedia(slug, itemID, fileName, mimeType string, contentLength int64) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateMedia(itemID, fileName, mimeType, contentLength)
+}
+
+// UpdatePostProcessing records audio filters applied to an item of the feed with given slug.
+func (r *FeedRegistry) UpdatePostProcessing(slug, itemID string, pp PostProcessing) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdatePostProcessing(itemID, pp)
+}
+
+// UpdateMediaInfo stores the media file info of an item of the feed with given slug.
+func (r *FeedRegistry) UpdateMediaInfo(slug, itemID string, info MediaInfo) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateMediaInfo(itemID, info)
+}
+
+// UpdateTranscript sets the transcript file name and language of an item of the feed with given slug.
+func (r *FeedRegistry) UpdateTranscript(slug, itemID, fileName, lang string) (PodcastItem, error) {
+	return r.itemStorage(slug).UpdateTranscript(itemID, fileName, lang)
+}
+
+// Revision returns the current revision of the feed with given slug.
+func (r *FeedRegistry) Revision(slug string) (Revision, error) {
+	return r.itemStorage(slug).Revision()
+}
+
+func (r *FeedRegistry) itemStorage(slug string) itemStore {
+	if slug == "" {
+		slug = DefaultFeed
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.storage(slug)
+}
+
+// storage returns the item storage of the feed. Storages of unknown feeds are not cached, so that requests
+// to arbitrary slugs don't pile up. The caller is expected to hold r.mu.
+func (r *FeedRegistry) storage(slug string) itemStore {
+	if st, ok := r.storages[slug]; ok {
+		return st
+	}
+
+	st := r.backend.Items(slug)
+	if _, err := r.Get(slug); err == nil {
+		r.storages[slug] = st
+	}
+
+	return st
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestFeedRegistry_ItemStorage(t *testing.T) {
+	db := openTestBoltDB(t)
+	r := NewFeedRegistry(db, boltBackend{db}, t.TempDir(), nil)
+
+	if err := r.Create(PodcastFeed{Slug: "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range []string{"news", "unknown"} {
+		if _, err := r.Revision(slug); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := r.Item(slug, "2024-03-01T10:00:00Z"); err != ErrItemNotFound {
+			t.Fatalf("expected ErrItemNotFound, got %v", err)
+		}
+	}
+
+	if _, err := r.Service("unknown"); err != ErrFeedNotFound {
+		t.Errorf("expected ErrFeedNotFound, got %v", err)
+	}
+
+	if _, ok := r.storages["news"]; !ok {
+		t.Errorf("expected the storage of an existing feed to be cached")
+	}
+
+	if _, ok := r.storages["unknown"]; ok {
+		t.Errorf("expected the storage of an unknown feed not to be cached")
+	}
+}
@@ -1,15 +1,142 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// OriginalProfile is the name of the transcoding profile that keeps the original audio stream.
+const OriginalProfile = "original"
+
+// ErrUnknownProfile is returned when a transcoding profile is not supported.
+var ErrUnknownProfile = errors.New("unknown transcoding profile")
+
+// TranscodingProfile defines the audio codec and the container of transcoded files.
+type TranscodingProfile struct {
+	Name string
+	// Args are the ffmpeg output options that select the codec and its settings.
+	Args []string
+	// Ext and MIMEType define the resulting file type. Empty values mean that the original type is kept.
+	Ext, MIMEType string
+}
+
+// TranscodingProfiles are the supported transcoding profiles.
+var TranscodingProfiles = map[string]TranscodingProfile{
+	OriginalProfile: {
+		Name: OriginalProfile,
+		Args: []string{"-c:a", "copy"},
+	},
+	"voice-64k-mono-aac": {
+		Name:     "voice-64k-mono-aac",
+		Args:     []string{"-c:a", "aac", "-b:a", "64k", "-ac", "1"},
+		Ext:      ".m4a",
+		MIMEType: "audio/mp4",
+	},
+	"mp3-128k": {
+		Name:     "mp3-128k",
+		Args:     []string{"-c:a", "libmp3lame", "-b:a", "128k"},
+		Ext:      ".mp3",
+		MIMEType: "audio/mpeg",
+	},
+	"opus-48k": {
+		Name:     "opus-48k",
+		Args:     []string{"-c:a", "libopus", "-b:a", "48k"},
+		Ext:      ".opus",
+		MIMEType: "audio/ogg",
+	},
+}
+
+// TranscodingProfileNames returns the names of supported transcoding profiles, the original one goes first.
+func TranscodingProfileNames() []string {
+	names := make([]string, 0, len(TranscodingProfiles))
+	for name := range TranscodingProfiles {
+		if name != OriginalProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{OriginalProfile}, names...)
+}
+
+// ValidateTranscodingProfile returns ErrUnknownProfile if the profile name is neither empty nor supported.
+func ValidateTranscodingProfile(name string) error {
+	if _, ok := TranscodingProfiles[name]; name != "" && !ok {
+		return fmt.Errorf("%w %q", ErrUnknownProfile, name)
+	}
+
+	return nil
+}
+
+// Post-processing settings.
+const (
+	// loudness targets according to EBU R128 adjusted for podcasts
+	targetLoudness      = "-16"
+	targetTruePeak      = "-1.5"
+	targetLoudnessRange = "11"
+
+	silenceThreshold = "-50dB"
+	// minSilenceDuration is the shortest pause at the beginning or the end of media that is trimmed.
+	minSilenceDuration = 0.5
+	// maxPauseDuration is the length pauses in the middle of media are shortened to when compressing silence.
+	maxPauseDuration = 1.0
+)
+
+// PostProcessing defines audio filters applied to media files while transcoding.
+type PostProcessing struct {
+	// Loudnorm normalizes loudness using two-pass EBU R128 loudnorm filter.
+	Loudnorm bool
+	// TrimSilence removes silence at the beginning and at the end of media.
+	TrimSilence bool
+	// CompressSilence shortens long pauses in the middle of media.
+	CompressSilence bool
+}
+
+// Enabled returns true if any of post-processing steps is enabled.
+func (pp PostProcessing) Enabled() bool {
+	return pp.Loudnorm || pp.TrimSilence || pp.CompressSilence
+}
+
+// String returns a human-readable list of enabled post-processing steps.
+func (pp PostProcessing) String() string {
+	var steps []string
+	if pp.Loudnorm {
+		steps = append(steps, "loudness normalized")
+	}
+
+	if pp.TrimSilence {
+		steps = append(steps, "silence trimmed")
+	}
+
+	if pp.CompressSilence {
+		steps = append(steps, "pauses shortened")
+	}
+
+	return strings.Join(steps, ", ")
+}
+
+// TranscodeOptions are the options applied to a media file while transcoding.
+type TranscodeOptions struct {
+	// Start is the position to trim the beginning of the media file at.
+	Start time.Duration
+	// Profile is the transcoding profile to use.
+	Profile TranscodingProfile
+	// PostProcessing defines audio filters to apply.
+	PostProcessing PostProcessing
+}
+
 // FFMpeg is a wrapper around ffmpeg command line tool.
 type FFMpeg struct{}
 
@@ -19,16 +146,151 @@ func NewFFMpeg() *FFMpeg {
 }
 
 // TranscodeMedia transcodes the media file at filePath to a format suitable for podcast items using following command:
-// ffmpeg -i $filePath -c:a copy -vn $tempFile
-func (svc *FFMpeg) TranscodeMedia(ctx context.Context, filePath string) (int64, error) {
+// ffmpeg [-ss $start] -i $filePath -vn [-af $filters] $profileArgs $tempFile
+// The original file is replaced with the transcoded one, which path is returned along with its size. The path
+// differs from the original one if the profile changes the file type. If post-processing is enabled, the file is
+// analyzed first to measure its loudness and find silent parts.
+func (svc *FFMpeg) TranscodeMedia(ctx context.Context, filePath string, opts TranscodeOptions) (string, int64, error) {
+	profile := opts.Profile
+	if profile.Name == "" {
+		profile = TranscodingProfiles[OriginalProfile]
+	}
+
+	ext := path.Ext(filePath)
+	outExt := ext
+	if profile.Ext != "" {
+		outExt = profile.Ext
+	}
+
+	stem := strings.TrimSuffix(filePath, ext)
+	tempFile, outFile := stem+".tmp"+outExt, stem+outExt
+	defer os.Remove(tempFile)
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start.Seconds(), 'f', -1, 64))
+	}
+	args = append(args, "-i", filePath, "-vn")
+
+	codecArgs := profile.Args
+	if opts.PostProcessing.Enabled() {
+		filters, err := svc.audioFilters(ctx, filePath, opts.Start, opts.PostProcessing)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to analyze file: %w", err)
+		}
+
+		if len(filters) > 0 {
+			args = append(args, "-af", strings.Join(filters, ","))
+
+			// filtered audio cannot be copied as is, so it's re-encoded with the default codec for the container
+			if profile.Name == OriginalProfile {
+				codecArgs = []string{"-c:a", defaultEncoder(ext)}
+			}
+		}
+	}
+
+	args = append(args, codecArgs...)
+	args = append(args, tempFile)
+
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		log.Println("ffmpeg responded with", string(out))
+		return "", 0, fmt.Errorf("failed to transcode file: %w", err)
+	}
+
+	fi, err := os.Stat(tempFile)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get file info for %s: %w", tempFile, err)
+	}
+
+	if err := os.Rename(tempFile, outFile); err != nil {
+		return "", 0, fmt.Errorf("failed to rename %s to %s: %w", tempFile, outFile, err)
+	}
+
+	if outFile != filePath {
+		if err := os.Remove(filePath); err != nil {
+			log.Printf("failed to remove %s: %s", filePath, err)
+		}
+	}
+
+	return outFile, fi.Size(), nil
+}
+
+// MediaTags are the metadata tags written into a media file.
+type MediaTags struct {
+	Title, Artist, Album string
+	Comment              string
+	Date                 time.Time
+	// ImagePath is the path to the artwork to embed into the file.
+	ImagePath string
+	Chapters  []Chapter
+	// Duration is the media duration used to set the end of the last chapter.
+	Duration time.Duration
+}
+
+// TagMedia writes tags into the media file at filePath using following command:
+// ffmpeg -i $filePath [-i $chaptersFile] [-i $imagePath] -map 0:a -c copy -metadata ... $tempFile
+// Tags already present in the file are kept unless overridden. It returns the size of the tagged file.
+func (svc *FFMpeg) TagMedia(ctx context.Context, filePath string, tags MediaTags) (int64, error) {
 	ext := path.Ext(filePath)
 	tempFile := strings.TrimSuffix(filePath, ext) + ".tmp" + ext
 	defer os.Remove(tempFile)
 
-	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error", "-y", "-i", filePath, "-c:a", "copy", "-vn", tempFile).CombinedOutput()
+	var (
+		inputs  = []string{"-i", filePath}
+		mapping = []string{"-map", "0:a", "-map_metadata", "0"}
+	)
+
+	if len(tags.Chapters) > 0 {
+		chaptersFile := strings.TrimSuffix(filePath, ext) + ".chapters.txt"
+		if err := os.WriteFile(chaptersFile, ffmetadataChapters(tags.Chapters, tags.Duration), 0644); err != nil {
+			return 0, fmt.Errorf("failed to write chapters to %s: %w", chaptersFile, err)
+		}
+		defer os.Remove(chaptersFile)
+
+		mapping = append(mapping, "-map_chapters", strconv.Itoa(len(inputs)/2))
+		inputs = append(inputs, "-f", "ffmetadata", "-i", chaptersFile)
+	}
+
+	// only ID3 and MP4 support embedded pictures that can be muxed by ffmpeg
+	if tags.ImagePath != "" && (ext == ".mp3" || ext == ".m4a" || ext == ".mp4") {
+		if _, err := os.Stat(tags.ImagePath); err == nil {
+			mapping = append(mapping, "-map", strconv.Itoa(len(inputs)/2)+":v", "-disposition:v:0", "attached_pic")
+			inputs = append(inputs, "-i", tags.ImagePath)
+		}
+	}
+
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-y"}, inputs...)
+	args = append(args, mapping...)
+	args = append(args, "-c", "copy")
+
+	var date string
+	if !tags.Date.IsZero() {
+		date = tags.Date.Format("2006-01-02")
+	}
+
+	for _, kv := range [][2]string{
+		{"title", tags.Title},
+		{"artist", tags.Artist},
+		{"album", tags.Album},
+		{"date", date},
+		{"comment", tags.Comment},
+	} {
+		if kv[1] != "" {
+			args = append(args, "-metadata", kv[0]+"="+kv[1])
+		}
+	}
+
+	if ext == ".mp3" {
+		args = append(args, "-id3v2_version", "3")
+	}
+
+	args = append(args, tempFile)
+
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
 	if err != nil {
 		log.Println("ffmpeg responded with", string(out))
-		return 0, fmt.Errorf("failed to transcode file: %w", err)
+		return 0, fmt.Errorf("failed to tag file: %w", err)
 	}
 
 	fi, err := os.Stat(tempFile)
@@ -42,3 +304,276 @@ func (svc *FFMpeg) TranscodeMedia(ctx context.Context, filePath string) (int64,
 
 	return fi.Size(), nil
 }
+
+// ffmetadataChapters returns chapters in ffmpeg metadata format. Each chapter ends where the next
+// one starts, and the last one ends at the end of media.
+func ffmetadataChapters(chapters []Chapter, duration time.Duration) []byte {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+	var buf bytes.Buffer
+	buf.WriteString(";FFMETADATA1\n")
+
+	for i, ch := range chapters {
+		end := duration
+		if i < len(chapters)-1 {
+			end = chapters[i+1].Start
+		}
+
+		if end <= ch.Start {
+			end = ch.Start + time.Second
+		}
+
+		fmt.Fprintf(&buf, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			ch.Start.Milliseconds(), end.Milliseconds(), escape.Replace(ch.Title))
+	}
+
+	return buf.Bytes()
+}
+
+// MediaInfo describes the audio stream of a media file.
+type MediaInfo struct {
+	Duration time.Duration
+	// BitRate is the audio bit rate in bits per second.
+	BitRate  int64
+	Codec    string
+	Channels int
+	Size     int64
+	Chapters []Chapter
+}
+
+// ProbeMedia returns the information about the first audio stream of the media file at filePath using following command:
+// ffprobe -v error -print_format json -show_format -show_streams -show_chapters -select_streams a:0 $filePath
+func (svc *FFMpeg) ProbeMedia(ctx context.Context, filePath string) (MediaInfo, error) {
+	out, err := exec.CommandContext(
+		ctx, "ffprobe",
+		"-v", "error", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", "-select_streams", "a:0",
+		filePath,
+	).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			log.Println("ffprobe responded with", string(exitErr.Stderr))
+		}
+
+		return MediaInfo{}, fmt.Errorf("failed to probe file: %w", err)
+	}
+
+	// ffprobe reports numbers as strings
+	var res struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Channels  int    `json:"channels"`
+			BitRate   string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+			Size     string `json:"size"`
+		} `json:"format"`
+		Chapters []struct {
+			StartTime string `json:"start_time"`
+			Tags      struct {
+				Title string `json:"title"`
+			} `json:"tags"`
+		} `json:"chapters"`
+	}
+
+	if err := json.Unmarshal(out, &res); err != nil {
+		return MediaInfo{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	if len(res.Streams) == 0 {
+		return MediaInfo{}, fmt.Errorf("no audio stream found in %s", filePath)
+	}
+
+	stream := res.Streams[0]
+	info := MediaInfo{
+		Codec:    stream.CodecName,
+		Channels: stream.Channels,
+	}
+
+	if secs, err := strconv.ParseFloat(res.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(secs * float64(time.Second))
+	}
+
+	// some containers, i.e. Ogg, only report the overall bit rate
+	if info.BitRate, err = strconv.ParseInt(stream.BitRate, 10, 64); err != nil {
+		info.BitRate, _ = strconv.ParseInt(res.Format.BitRate, 10, 64)
+	}
+
+	for _, ch := range res.Chapters {
+		secs, err := strconv.ParseFloat(ch.StartTime, 64)
+		if err != nil {
+			continue
+		}
+
+		info.Chapters = append(info.Chapters, Chapter{
+			Start: time.Duration(secs * float64(time.Second)),
+			Title: ch.Tags.Title,
+		})
+	}
+
+	if info.Size, err = strconv.ParseInt(res.Format.Size, 10, 64); err != nil {
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			return MediaInfo{}, fmt.Errorf("failed to get file info for %s: %w", filePath, err)
+		}
+
+		info.Size = fi.Size()
+	}
+
+	return info, nil
+}
+
+var (
+	durationRe     = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+	silenceStartRe = regexp.MustCompile(`silence_start: (-?\d+(?:\.\d+)?)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end: (-?\d+(?:\.\d+)?)`)
+)
+
+// loudnessStats are the values measured by the first pass of loudnorm filter.
+type loudnessStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// audioFilters analyzes the media file and returns the list of ffmpeg audio filters that apply post-processing.
+// The first pass of loudnorm and the silence detection are done within the same ffmpeg run:
+// ffmpeg [-ss $start] -i $filePath -vn -af silencedetect,loudnorm -f null -
+func (svc *FFMpeg) audioFilters(ctx context.Context, filePath string, start time.Duration, pp PostProcessing) ([]string, error) {
+	var analyzers []string
+	if pp.TrimSilence {
+		analyzers = append(analyzers, fmt.Sprintf("silencedetect=noise=%s:d=%g", silenceThreshold, minSilenceDuration))
+	}
+
+	if pp.Loudnorm {
+		analyzers = append(analyzers, fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s:print_format=json", targetLoudness, targetTruePeak, targetLoudnessRange))
+	}
+
+	var out []byte
+	if len(analyzers) > 0 {
+		args := []string{"-hide_banner", "-nostats"}
+		if start > 0 {
+			args = append(args, "-ss", strconv.FormatFloat(start.Seconds(), 'f', -1, 64))
+		}
+		args = append(args, "-i", filePath, "-vn", "-af", strings.Join(analyzers, ","), "-f", "null", "-")
+
+		var err error
+		if out, err = exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+			log.Println("ffmpeg responded with", string(out))
+			return nil, err
+		}
+	}
+
+	var filters []string
+	if pp.TrimSilence {
+		if f := trimFilter(out, start); f != "" {
+			filters = append(filters, f)
+		}
+	}
+
+	if pp.CompressSilence {
+		filters = append(filters, fmt.Sprintf(
+			"silenceremove=stop_periods=-1:stop_duration=%g:stop_threshold=%s:stop_silence=%g",
+			maxPauseDuration, silenceThreshold, maxPauseDuration,
+		))
+	}
+
+	if pp.Loudnorm {
+		stats, err := parseLoudnessStats(out)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(stats.InputI, "inf") {
+			log.Printf("%s seems to be silent, skipping loudness normalization", filePath)
+		} else {
+			filters = append(filters, fmt.Sprintf(
+				"loudnorm=I=%s:TP=%s:LRA=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+				targetLoudness, targetTruePeak, targetLoudnessRange,
+				stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset,
+			), "aresample=48000") // loudnorm upsamples audio to 192 kHz
+		}
+	}
+
+	return filters, nil
+}
+
+// trimFilter returns the atrim filter that cuts off silence detected at the beginning and at the end of
+// media by silencedetect filter. It returns an empty string if there is nothing to trim.
+func trimFilter(out []byte, start time.Duration) string {
+	var duration float64
+	if m := durationRe.FindSubmatch(out); m != nil {
+		hours, _ := strconv.ParseFloat(string(m[1]), 64)
+		mins, _ := strconv.ParseFloat(string(m[2]), 64)
+		secs, _ := strconv.ParseFloat(string(m[3]), 64)
+
+		duration = hours*3600 + mins*60 + secs - start.Seconds()
+	}
+
+	starts, ends := silenceStartRe.FindAllSubmatch(out, -1), silenceEndRe.FindAllSubmatch(out, -1)
+	if len(starts) == 0 {
+		return ""
+	}
+
+	parse := func(m [][]byte) float64 {
+		v, _ := strconv.ParseFloat(string(m[1]), 64)
+		return v
+	}
+
+	var trimStart, trimEnd float64
+	if parse(starts[0]) <= 0.1 {
+		if len(ends) == 0 {
+			return "" // the whole file is silent
+		}
+
+		if trimStart = parse(ends[0]); duration > 0 && trimStart >= duration-0.1 {
+			return "" // the whole file is silent
+		}
+	}
+
+	// silence that lasts until the end of file either has no end or ends at the very end
+	if last := starts[len(starts)-1]; len(ends) < len(starts) || (duration > 0 && parse(ends[len(ends)-1]) >= duration-0.1) {
+		trimEnd = parse(last)
+	}
+
+	switch {
+	case trimEnd > trimStart:
+		return fmt.Sprintf("atrim=start=%g:end=%g,asetpts=PTS-STARTPTS", trimStart, trimEnd)
+	case trimStart > 0:
+		return fmt.Sprintf("atrim=start=%g,asetpts=PTS-STARTPTS", trimStart)
+	default:
+		return ""
+	}
+}
+
+// parseLoudnessStats extracts the JSON printed by the first pass of loudnorm filter from ffmpeg output.
+func parseLoudnessStats(out []byte) (loudnessStats, error) {
+	var stats loudnessStats
+
+	start, end := bytes.LastIndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if start == -1 || end < start {
+		return stats, errors.New("no loudness stats found in ffmpeg output")
+	}
+
+	if err := json.Unmarshal(out[start:end+1], &stats); err != nil {
+		return stats, fmt.Errorf("failed to parse loudness stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// defaultEncoder returns the name of the ffmpeg audio encoder for a container with given file extension.
+func defaultEncoder(ext string) string {
+	switch ext {
+	case ".mp3":
+		return "libmp3lame"
+	case ".opus", ".ogg", ".oga", ".webm":
+		return "libopus"
+	default:
+		return "aac"
+	}
+}
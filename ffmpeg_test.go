@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLoudnormOutput = `[Parsed_loudnorm_1 @ 0x55d0c8a0c2c0]
+{
+	"input_i" : "-23.54",
+	"input_tp" : "-4.12",
+	"input_lra" : "5.80",
+	"input_thresh" : "-34.01",
+	"output_i" : "-16.02",
+	"output_tp" : "-1.50",
+	"output_lra" : "4.90",
+	"output_thresh" : "-26.40",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.02"
+}
+`
+
+func TestTrimFilter(t *testing.T) {
+	const header = "Input #0, mp3, from 'in.mp3':\n  Duration: 00:01:00.00, start: 0.025057, bitrate: 128 kb/s\n"
+
+	for name, tc := range map[string]struct {
+		Output   string
+		Start    time.Duration
+		Expected string
+	}{
+		"no silence": {
+			Output: header,
+		},
+		"leading silence": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 0\n" +
+				"[silencedetect @ 0x1] silence_end: 1.5 | silence_duration: 1.5\n",
+			Expected: "atrim=start=1.5,asetpts=PTS-STARTPTS",
+		},
+		"trailing silence without end": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 58.2\n",
+			Expected: "atrim=start=0:end=58.2,asetpts=PTS-STARTPTS",
+		},
+		"trailing silence ending with media": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 58.2\n" +
+				"[silencedetect @ 0x1] silence_end: 60 | silence_duration: 1.8\n",
+			Expected: "atrim=start=0:end=58.2,asetpts=PTS-STARTPTS",
+		},
+		"leading and trailing silence": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: -0.02\n" +
+				"[silencedetect @ 0x1] silence_end: 1.5 | silence_duration: 1.52\n" +
+				"[silencedetect @ 0x1] silence_start: 30\n" +
+				"[silencedetect @ 0x1] silence_end: 32 | silence_duration: 2\n" +
+				"[silencedetect @ 0x1] silence_start: 58.2\n",
+			Expected: "atrim=start=1.5:end=58.2,asetpts=PTS-STARTPTS",
+		},
+		"pause in the middle": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 30\n" +
+				"[silencedetect @ 0x1] silence_end: 32 | silence_duration: 2\n",
+		},
+		"silent media without end": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 0\n",
+		},
+		"silent media": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 0\n" +
+				"[silencedetect @ 0x1] silence_end: 59.95 | silence_duration: 59.95\n",
+		},
+		"start offset": {
+			Output: header +
+				"[silencedetect @ 0x1] silence_start: 0\n" +
+				"[silencedetect @ 0x1] silence_end: 2 | silence_duration: 2\n" +
+				"[silencedetect @ 0x1] silence_start: 48\n" +
+				"[silencedetect @ 0x1] silence_end: 50 | silence_duration: 2\n",
+			Start:    10 * time.Second,
+			Expected: "atrim=start=2:end=48,asetpts=PTS-STARTPTS",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := trimFilter([]byte(tc.Output), tc.Start); actual != tc.Expected {
+				t.Errorf("expected %q, got %q", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseLoudnessStats(t *testing.T) {
+	expected := loudnessStats{
+		InputI:       "-23.54",
+		InputTP:      "-4.12",
+		InputLRA:     "5.80",
+		InputThresh:  "-34.01",
+		TargetOffset: "0.02",
+	}
+
+	for name, output := range map[string]string{
+		"loudnorm output":  testLoudnormOutput,
+		"with other stats": "Stream #0:0 -> #0:0 (mp3 (mp3float) -> pcm_s16le (native))\n{ not a json }\n" + testLoudnormOutput + "size=N/A time=00:01:00.00\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			stats, err := parseLoudnessStats([]byte(output))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if stats != expected {
+				t.Errorf("expected %+v, got %+v", expected, stats)
+			}
+		})
+	}
+}
+
+func TestParseLoudnessStats_Errors(t *testing.T) {
+	for name, output := range map[string]string{
+		"empty":     "",
+		"no stats":  "Input #0, mp3, from 'in.mp3':\n  Duration: 00:01:00.00\n",
+		"truncated": "[Parsed_loudnorm_1 @ 0x1]\n{\n\t\"input_i\" : \"-23.54\",\n",
+		"malformed": "[Parsed_loudnorm_1 @ 0x1]\n{\n\t\"input_i\" : -23.54,\n}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseLoudnessStats([]byte(output)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestFFMpeg_AudioFilters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ffmpeg stub is a shell script")
+	}
+
+	dir := t.TempDir()
+
+	output := "Input #0, mp3, from 'in.mp3':\n  Duration: 00:01:10.00, start: 0.025057, bitrate: 128 kb/s\n" +
+		"[silencedetect @ 0x1] silence_start: 0\n" +
+		"[silencedetect @ 0x1] silence_end: 1.5 | silence_duration: 1.5\n" +
+		"[silencedetect @ 0x1] silence_start: 58.2\n" +
+		testLoudnormOutput
+
+	if err := os.WriteFile(filepath.Join(dir, "output.txt"), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stub := "#!/bin/sh\necho \"$@\" > '" + filepath.Join(dir, "args.txt") + "'\ncat '" + filepath.Join(dir, "output.txt") + "' >&2\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	svc := &FFMpeg{}
+
+	t.Run("all steps", func(t *testing.T) {
+		filters, err := svc.audioFilters(context.Background(), "in.mp3", 10*time.Second, PostProcessing{
+			Loudnorm:        true,
+			TrimSilence:     true,
+			CompressSilence: true,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		expected := []string{
+			"atrim=start=1.5:end=58.2,asetpts=PTS-STARTPTS",
+			"silenceremove=stop_periods=-1:stop_duration=1:stop_threshold=-50dB:stop_silence=1",
+			"loudnorm=I=-16:TP=-1.5:LRA=11:measured_I=-23.54:measured_TP=-4.12:measured_LRA=5.80:measured_thresh=-34.01:offset=0.02:linear=true",
+			"aresample=48000",
+		}
+		if !reflect.DeepEqual(filters, expected) {
+			t.Errorf("expected\n\t%q\ngot\n\t%q", expected, filters)
+		}
+
+		args, err := os.ReadFile(filepath.Join(dir, "args.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := string(args); !strings.HasPrefix(s, "-hide_banner -nostats -ss 10 -i in.mp3 -vn -af silencedetect=") || !strings.Contains(s, ",loudnorm=") {
+			t.Errorf("unexpected ffmpeg arguments %q", s)
+		}
+	})
+
+	t.Run("compress silence only", func(t *testing.T) {
+		os.Remove(filepath.Join(dir, "args.txt"))
+
+		filters, err := svc.audioFilters(context.Background(), "in.mp3", 0, PostProcessing{CompressSilence: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		expected := []string{"silenceremove=stop_periods=-1:stop_duration=1:stop_threshold=-50dB:stop_silence=1"}
+		if !reflect.DeepEqual(filters, expected) {
+			t.Errorf("expected\n\t%q\ngot\n\t%q", expected, filters)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "args.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected ffmpeg not to be run")
+		}
+	})
+}
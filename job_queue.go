@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
 
-// ErrNoInactiveJobs is returned when there are no inactive jobs in the queue.
-var ErrNoInactiveJobs = errors.New("no inactive jobs")
+var (
+	// ErrNoInactiveJobs is returned when there are no inactive jobs in the queue.
+	ErrNoInactiveJobs = errors.New("no inactive jobs")
+	// ErrJobNotFound is returned when there is no job for an item in the queue.
+	ErrJobNotFound = errors.New("no such job")
+	// ErrJobCancelled is returned when updating a job that has been cancelled while running.
+	ErrJobCancelled = errors.New("job cancelled")
+)
 
 // DownloadStatus represents a status of a download job.
 type DownloadStatus uint8
@@ -31,6 +41,10 @@ func (st DownloadStatus) String() string {
 		return "downloaded"
 	case StatusReady:
 		return "ready"
+	case StatusCancelled:
+		return "cancelled"
+	case StatusFailed:
+		return "failed"
 	default:
 		return "unknown"
 	}
@@ -38,15 +52,23 @@ func (st DownloadStatus) String() string {
 
 // DownloadJob represents a job to be performed on a podcast item.
 type DownloadJob struct {
+	Feed      string
 	ItemID    string
 	Status    DownloadStatus
 	SourceURI string
 	TargetURI string
+
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	// Refresh requests a fresh source URL to be obtained from the item provider before downloading.
+	Refresh bool
 }
 
 // NewDownloadJob returns a new instance of DownloadJob.
-func NewDownloadJob(itemID, sourceURI, targetURI string) DownloadJob {
+func NewDownloadJob(feed, itemID, sourceURI, targetURI string) DownloadJob {
 	return DownloadJob{
+		Feed:      feed,
 		ItemID:    itemID,
 		Status:    StatusAdded,
 		SourceURI: sourceURI,
@@ -54,28 +76,216 @@ func NewDownloadJob(itemID, sourceURI, targetURI string) DownloadJob {
 	}
 }
 
+// jobStore persists download jobs keyed by feed slug and item ID, since items of different feeds may share
+// the same ID. Jobs are returned in the order of their item IDs, i.e. in the order items were added.
+type jobStore interface {
+	// Put adds a new job or replaces the existing one resetting its active status.
+	Put(DownloadJob) error
+	// Delete removes the job of an item.
+	Delete(feed, itemID string) error
+	// Claim marks the first inactive job that has one of given statuses and is not scheduled to be retried
+	// after now as active and returns it. It returns ErrNoInactiveJobs if there is no such job.
+	Claim(now time.Time, statuses []DownloadStatus) (DownloadJob, error)
+	// Release removes the job of an item unless it is active. It returns whether the job is active and
+	// ErrJobNotFound if there is no such job.
+	Release(feed, itemID string) (bool, error)
+	All() ([]DownloadJob, error)
+}
+
 // DownloadJobQueue is a queue of download jobs that allows adding, updating and getting jobs.
 type DownloadJobQueue struct {
-	db *bolt.DB
+	st     jobStore
+	notify chan struct{}
+
+	mu        sync.Mutex
+	running   map[jobKey]context.CancelFunc
+	cancelled map[jobKey]struct{}
+}
+
+// jobKey identifies the job of an item in a feed.
+type jobKey struct {
+	Feed, ItemID string
 }
 
 // NewDownloadJobQueue returns a new instance of Queue.
-func NewDownloadJobQueue(db *bolt.DB) *DownloadJobQueue {
-	return &DownloadJobQueue{db: db}
+func NewDownloadJobQueue(st jobStore) *DownloadJobQueue {
+	return &DownloadJobQueue{
+		st:        st,
+		notify:    make(chan struct{}, 1),
+		running:   make(map[jobKey]context.CancelFunc),
+		cancelled: make(map[jobKey]struct{}),
+	}
+}
+
+// Notify returns a channel that receives a value whenever there is a new job to pick up in the queue.
+func (q *DownloadJobQueue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+func (q *DownloadJobQueue) wakeUp() {
+	select {
+	case q.notify <- struct{}{}:
+	default: // there is a pending notification already
+	}
+}
+
+// Add adds a job to the end of the queue.
+func (q *DownloadJobQueue) Add(job DownloadJob) error {
+	if err := q.st.Put(job); err != nil {
+		return err
+	}
+
+	q.wakeUp()
+
+	return nil
+}
+
+// Next returns the next inactive job in the queue that has one of given statuses. If no statuses are
+// provided, the first inactive job is returned. Jobs scheduled to be retried later are skipped.
+func (q *DownloadJobQueue) Next(statuses ...DownloadStatus) (DownloadJob, error) {
+	return q.st.Claim(time.Now(), statuses)
+}
+
+// Update updates the job in the queue resetting its active status. It deletes any completed jobs. If the job
+// has been cancelled while running, it is deleted as well, and ErrJobCancelled is returned unless the job is
+// complete already.
+func (q *DownloadJobQueue) Update(job DownloadJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := jobKey{job.Feed, job.ItemID}
+
+	// the worker may have finished a stage before noticing the cancellation
+	_, cancelled := q.cancelled[k]
+	delete(q.cancelled, k)
+
+	if job.Status == StatusReady || job.Status == StatusCancelled || job.Status == StatusFailed {
+		return q.st.Delete(job.Feed, job.ItemID)
+	}
+
+	if cancelled {
+		if err := q.st.Delete(job.Feed, job.ItemID); err != nil {
+			return err
+		}
+
+		return ErrJobCancelled
+	}
+
+	if err := q.st.Put(job); err != nil {
+		return err
+	}
+
+	q.wakeUp()
+
+	return nil
+}
+
+// Cancel removes the job of an item from the queue. If the job is being executed, its context is cancelled, and
+// the returned value is true. In this case the job is removed by the next Update.
+func (q *DownloadJobQueue) Cancel(feed, itemID string) (bool, error) {
+	// the lock keeps the worker from updating the job between checking and cancelling it
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	active, err := q.st.Release(feed, itemID)
+	if err != nil || !active {
+		return false, err
+	}
+
+	k := jobKey{feed, itemID}
+
+	// the job may have been picked up, but not started yet, in which case Track cancels it
+	q.cancelled[k] = struct{}{}
+
+	if cancel, ok := q.running[k]; ok {
+		cancel()
+	}
+
+	return true, nil
+}
+
+// Track returns a context for a job that is cancelled once the job is cancelled with Cancel. The returned
+// function must be called after the job is complete.
+func (q *DownloadJobQueue) Track(ctx context.Context, job DownloadJob) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	k := jobKey{job.Feed, job.ItemID}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.cancelled[k]; ok {
+		cancel()
+	}
+
+	q.running[k] = cancel
+
+	return ctx, func() {
+		q.mu.Lock()
+		delete(q.running, k)
+		q.mu.Unlock()
+
+		cancel()
+	}
+}
+
+// All returns all jobs in the queue.
+func (q *DownloadJobQueue) All() ([]DownloadJob, error) {
+	return q.st.All()
+}
+
+func hasDownloadStatus(st DownloadStatus, statuses []DownloadStatus) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+
+	for _, s := range statuses {
+		if s == st {
+			return true
+		}
+	}
+
+	return false
 }
 
 type boltJob struct {
-	Status    DownloadStatus `json:",omitempty"`
-	SourceURI string         `json:",omitempty"`
-	TargetURI string         `json:",omitempty"`
-	Active    bool           `json:",omitempty"`
+	Feed          string         `json:",omitempty"`
+	Status        DownloadStatus `json:",omitempty"`
+	SourceURI     string         `json:",omitempty"`
+	TargetURI     string         `json:",omitempty"`
+	Active        bool           `json:",omitempty"`
+	Attempts      int            `json:",omitempty"`
+	LastError     string         `json:",omitempty"`
+	NextAttemptAt time.Time      `json:",omitzero"`
+	Refresh       bool           `json:",omitempty"`
 }
 
 func newBoltJob(job DownloadJob) boltJob {
 	return boltJob{
-		Status:    job.Status,
-		SourceURI: job.SourceURI,
-		TargetURI: job.TargetURI,
+		Feed:          job.Feed,
+		Status:        job.Status,
+		SourceURI:     job.SourceURI,
+		TargetURI:     job.TargetURI,
+		Attempts:      job.Attempts,
+		LastError:     job.LastError,
+		NextAttemptAt: job.NextAttemptAt,
+		Refresh:       job.Refresh,
+	}
+}
+
+// DownloadJob converts the job stored under given key into a DownloadJob.
+func (j boltJob) DownloadJob(key []byte) DownloadJob {
+	itemID, _, _ := strings.Cut(string(key), " ")
+
+	return DownloadJob{
+		Feed:          j.Feed,
+		ItemID:        itemID,
+		Status:        j.Status,
+		SourceURI:     j.SourceURI,
+		TargetURI:     j.TargetURI,
+		Attempts:      j.Attempts,
+		LastError:     j.LastError,
+		NextAttemptAt: j.NextAttemptAt,
+		Refresh:       j.Refresh,
 	}
 }
 
@@ -84,23 +294,50 @@ func (j boltJob) MarshalBinary() []byte {
 	return b
 }
 
-// Push adds a value to the end of the queue.
-func (q *DownloadJobQueue) Add(job DownloadJob) error {
-	return q.db.Update(func(tx *bolt.Tx) error {
+// boltJobKey returns the key of an item job. Keys start with the item ID, so that jobs are sorted in the order
+// items were added regardless of their feed.
+func boltJobKey(feed, itemID string) []byte {
+	return []byte(itemID + " " + feed)
+}
+
+// boltJobStore keeps download jobs in the "downloads" bucket of a BoltDB database.
+type boltJobStore struct {
+	db *bolt.DB
+}
+
+func newBoltJobStore(db *bolt.DB) *boltJobStore {
+	return &boltJobStore{db}
+}
+
+// Put stores the job resetting its active status.
+func (s *boltJobStore) Put(job DownloadJob) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("downloads"))
 		if err != nil {
 			return err
 		}
 
-		return b.Put([]byte(job.ItemID), newBoltJob(job).MarshalBinary())
+		return b.Put(boltJobKey(job.Feed, job.ItemID), newBoltJob(job).MarshalBinary())
 	})
 }
 
-// Next returns the next inactive job in the queue.
-func (q *DownloadJobQueue) Next() (DownloadJob, error) {
+// Delete removes the job of an item.
+func (s *boltJobStore) Delete(feed, itemID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("downloads"))
+		if err != nil {
+			return err
+		}
+
+		return b.Delete(boltJobKey(feed, itemID))
+	})
+}
+
+// Claim marks the first matching inactive job as active and returns it.
+func (s *boltJobStore) Claim(now time.Time, statuses []DownloadStatus) (DownloadJob, error) {
 	var job DownloadJob
 
-	err := q.db.Update(func(tx *bolt.Tx) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("downloads"))
 		if err != nil {
 			return err
@@ -113,16 +350,11 @@ func (q *DownloadJobQueue) Next() (DownloadJob, error) {
 				return err
 			}
 
-			if j.Active {
+			if j.Active || !hasDownloadStatus(j.Status, statuses) || j.NextAttemptAt.After(now) {
 				continue
 			}
 
-			job = DownloadJob{
-				ItemID:    string(k),
-				Status:    j.Status,
-				SourceURI: j.SourceURI,
-				TargetURI: j.TargetURI,
-			}
+			job = j.DownloadJob(k)
 
 			j.Active = true
 
@@ -135,27 +367,42 @@ func (q *DownloadJobQueue) Next() (DownloadJob, error) {
 	return job, err
 }
 
-// Update updates the job in the queue resetting its active status. It deletes any completed jobs.
-func (q *DownloadJobQueue) Update(job DownloadJob) error {
-	return q.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("downloads"))
-		if err != nil {
+// Release removes the job of an item unless it is active.
+func (s *boltJobStore) Release(feed, itemID string) (bool, error) {
+	var active bool
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("downloads"))
+		if b == nil {
+			return ErrJobNotFound
+		}
+
+		k := boltJobKey(feed, itemID)
+		v := b.Get(k)
+		if v == nil {
+			return ErrJobNotFound
+		}
+
+		var j boltJob
+		if err := json.Unmarshal(v, &j); err != nil {
 			return err
 		}
 
-		if job.Status == StatusReady || job.Status == StatusCancelled || job.Status == StatusFailed {
-			return b.Delete([]byte(job.ItemID))
+		if active = j.Active; active {
+			return nil
 		}
 
-		return b.Put([]byte(job.ItemID), newBoltJob(job).MarshalBinary())
+		return b.Delete(k)
 	})
+
+	return active, err
 }
 
-// All returns all jobs in the queue.
-func (q *DownloadJobQueue) All() ([]DownloadJob, error) {
+// All returns all stored jobs.
+func (s *boltJobStore) All() ([]DownloadJob, error) {
 	var jobs []DownloadJob
 
-	err := q.db.View(func(tx *bolt.Tx) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("downloads"))
 		if b == nil {
 			return nil
@@ -168,12 +415,7 @@ func (q *DownloadJobQueue) All() ([]DownloadJob, error) {
 				return err
 			}
 
-			jobs = append(jobs, DownloadJob{
-				ItemID:    string(k),
-				Status:    j.Status,
-				SourceURI: j.SourceURI,
-				TargetURI: j.TargetURI,
-			})
+			jobs = append(jobs, j.DownloadJob(k))
 		}
 
 		return nil
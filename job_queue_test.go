@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDownloadJobQueue_Cancel(t *testing.T) {
+	for name, tc := range map[string]struct {
+		// CancelBeforeTrack cancels the job after it has been picked up, but before it has been started
+		CancelBeforeTrack bool
+		// Status is the status the worker has moved the job to by the time it notices the cancellation
+		Status   DownloadStatus
+		Expected error
+	}{
+		"running":                 {Status: StatusAdded, Expected: ErrJobCancelled},
+		"finished stage":          {Status: StatusDownloaded, Expected: ErrJobCancelled},
+		"picked up":               {CancelBeforeTrack: true, Status: StatusDownloaded, Expected: ErrJobCancelled},
+		"stopped by the worker":   {Status: StatusCancelled},
+		"completed by the worker": {Status: StatusReady},
+		"failed by the worker":    {Status: StatusFailed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			q := NewDownloadJobQueue(newBoltJobStore(openTestBoltDB(t)))
+
+			if err := q.Add(NewDownloadJob("news", "item1", "https://example.com/audio.mp3", "item1.mp3")); err != nil {
+				t.Fatal(err)
+			}
+
+			job, err := q.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.CancelBeforeTrack {
+				if active, err := q.Cancel(job.Feed, job.ItemID); err != nil || !active {
+					t.Fatalf("expected an active job to be cancelled, got %t (%v)", active, err)
+				}
+			}
+
+			ctx, done := q.Track(context.Background(), job)
+			defer done()
+
+			if !tc.CancelBeforeTrack {
+				if active, err := q.Cancel(job.Feed, job.ItemID); err != nil || !active {
+					t.Fatalf("expected an active job to be cancelled, got %t (%v)", active, err)
+				}
+			}
+
+			if ctx.Err() == nil {
+				t.Errorf("expected the job context to be cancelled")
+			}
+
+			job.Status = tc.Status
+			if err := q.Update(job); err != tc.Expected {
+				t.Errorf("expected Update() to return %v, got %v", tc.Expected, err)
+			}
+
+			if jobs, err := q.All(); err != nil || len(jobs) != 0 {
+				t.Errorf("expected the job to be removed, got %+v (%v)", jobs, err)
+			}
+
+			// the cancellation does not affect the job once it is added again
+			if err := q.Add(job); err != nil {
+				t.Fatal(err)
+			}
+
+			job, err = q.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, done = q.Track(context.Background(), job)
+			defer done()
+
+			if ctx.Err() != nil {
+				t.Errorf("expected the job context not to be cancelled")
+			}
+
+			job.Status = StatusDownloaded
+			if err := q.Update(job); err != nil {
+				t.Errorf("expected the job to be updated, got %v", err)
+			}
+
+			if jobs, err := q.All(); err != nil || len(jobs) != 1 || jobs[0].Status != StatusDownloaded {
+				t.Errorf("expected the job to be kept, got %+v (%v)", jobs, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONFeedRenderer renders a podcast feed as JSON Feed 1.1, see https://www.jsonfeed.org/version/1.1/
+type JSONFeedRenderer struct{}
+
+// ContentType returns the content type of the rendered feed.
+func (JSONFeedRenderer) ContentType() string {
+	return "application/feed+json; charset=utf-8"
+}
+
+// Render renders the feed to the given writer.
+func (JSONFeedRenderer) Render(w io.Writer, feed Feed) error {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.URL,
+		FeedURL:     feed.SelfURL,
+		Description: feed.Description,
+		Icon:        feed.IconURL,
+		Favicon:     feed.IconURL,
+		Language:    feed.Language,
+		Items:       make([]jsonFeedItem, 0, len(feed.Items)),
+	}
+
+	if feed.Author != "" {
+		doc.Authors = []jsonFeedAuthor{{Name: feed.Author}}
+	}
+
+	for _, it := range feed.Items {
+		if !it.Playable() {
+			continue // skip incomplete items
+		}
+
+		item := jsonFeedItem{
+			ID:            it.ID(),
+			URL:           it.OriginalURL,
+			Title:         it.Title,
+			ContentHTML:   itemSummary(it),
+			Summary:       itemDescription(it),
+			Image:         it.ImageURL,
+			DatePublished: it.AddedAt.UTC().Format(time.RFC3339),
+			Attachments: []jsonFeedAttachment{{
+				URL:               it.MediaURL,
+				MIMEType:          enclosureType(it.MIMEType),
+				SizeInBytes:       it.ContentLength,
+				DurationInSeconds: int64(it.Duration / time.Second),
+			}},
+		}
+
+		if item.ContentHTML == "" {
+			item.ContentHTML = item.Summary // either content_html or content_text is required
+		}
+
+		if it.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: it.Author}}
+		}
+
+		doc.Items = append(doc.Items, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Favicon     string           `json:"favicon,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	Summary       string               `json:"summary,omitempty"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments"`
+}
+
+type jsonFeedAttachment struct {
+	URL               string `json:"url"`
+	MIMEType          string `json:"mime_type"`
+	SizeInBytes       int64  `json:"size_in_bytes,omitempty"`
+	DurationInSeconds int64  `json:"duration_in_seconds,omitempty"`
+}
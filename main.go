@@ -6,9 +6,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -20,34 +22,71 @@ const (
 
 	// Default podcast title
 	DefaultPodcastTitle = "YouCast"
+
+	// Default podcast language
+	DefaultPodcastLanguage = "en"
 )
 
 var args struct {
 	Title       string
+	Author      string
+	Owner       string
+	Email       string
+	Language    string
+	Category    string
+	Explicit    bool
 	ListenAddr  string
 	DBPath      string
+	StorageDSN  string
 	StoragePath string
+	Password    string
 	DevMode     bool
+
+	MaxDownloads, MaxTranscodes int
+	MaxAttempts                 int
+	SubscriptionInterval        time.Duration
+	TranscodingProfile          string
+	TranscriptionCommand        string
+	MaxFeedItems                int
 }
 
 func main() {
 	log.Println("YouCast version", Version)
+
+	// youcast export|import [flags] <file>
+	var cmd string
+	if len(os.Args) > 1 && (os.Args[1] == "export" || os.Args[1] == "import") {
+		cmd = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	flag.StringVar(&args.Title, "title", os.Getenv("PODCAST_TITLE"), "Podcast title")
+	flag.StringVar(&args.Author, "author", os.Getenv("PODCAST_AUTHOR"), "Default podcast author")
+	flag.StringVar(&args.Owner, "owner", os.Getenv("PODCAST_OWNER"), "Default podcast owner name")
+	flag.StringVar(&args.Email, "email", os.Getenv("PODCAST_EMAIL"), "Default podcast owner email")
+	flag.StringVar(&args.Language, "language", envString("PODCAST_LANGUAGE", DefaultPodcastLanguage), "Default podcast language code")
+	flag.StringVar(&args.Category, "category", os.Getenv("PODCAST_CATEGORY"), "Default podcast iTunes category, i.e. \"Society & Culture > Documentary\"")
+	flag.BoolVar(&args.Explicit, "explicit", envBool("PODCAST_EXPLICIT", false), "Mark podcasts as containing explicit content")
 	flag.StringVar(&args.ListenAddr, "l", os.Getenv("LISTEN_ADDR"), "Listen address")
 	flag.StringVar(&args.DBPath, "db", os.Getenv("DB_PATH"), "Path to the database")
+	flag.StringVar(&args.StorageDSN, "storage", os.Getenv("STORAGE_DSN"), "Where to store podcast items and download jobs, i.e. sqlite:/var/lib/youcast/youcast.db, the BoltDB database is used if empty")
 	flag.StringVar(&args.StoragePath, "storage-dir", os.Getenv("STORAGE_PATH"), "Path to the directory where to store downloaded files")
+	flag.StringVar(&args.Password, "password", os.Getenv("ADMIN_PASSWORD"), "Password to access the web UI, authentication is disabled if empty")
+	flag.IntVar(&args.MaxDownloads, "max-downloads", envInt("MAX_DOWNLOADS", 2), "Maximum number of concurrent downloads")
+	flag.IntVar(&args.MaxTranscodes, "max-transcodes", envInt("MAX_TRANSCODES", 1), "Maximum number of concurrent ffmpeg processes")
+	flag.IntVar(&args.MaxAttempts, "max-attempts", envInt("MAX_DOWNLOAD_ATTEMPTS", DefaultMaxAttempts), "Maximum number of attempts to download a file")
+	flag.DurationVar(&args.SubscriptionInterval, "subscription-interval", envDuration("SUBSCRIPTION_INTERVAL", DefaultSubscriptionInterval), "Interval between checks of subscribed channels and playlists")
+	flag.StringVar(&args.TranscodingProfile, "transcoding-profile", os.Getenv("TRANSCODING_PROFILE"), "Default transcoding profile, one of "+strings.Join(TranscodingProfileNames(), ", "))
+	flag.StringVar(&args.TranscriptionCommand, "transcribe-cmd", os.Getenv("TRANSCRIBE_CMD"), "Speech-to-text command used to transcribe items, i.e. whisper-cli -m model.bin -ovtt -of {output} -f {input}")
+	flag.IntVar(&args.MaxFeedItems, "max-feed-items", envInt("MAX_FEED_ITEMS", 0), "Maximum number of latest episodes included into podcast feeds, 0 means no limit")
 	flag.BoolVar(&args.DevMode, "dev", false, "Development mode (read assets from ./assets on each request)")
 	flag.Parse()
 
-	if args.Title == "" {
-		args.Title = DefaultPodcastTitle
-	}
-
 	if p, ok := os.LookupEnv("PORT"); ok {
 		args.ListenAddr = ":" + p
 	}
 
-	if args.ListenAddr == "" {
+	if args.ListenAddr == "" && cmd == "" {
 		log.Fatalln("missing LISTEN_ADDR")
 	}
 
@@ -60,35 +99,132 @@ func main() {
 		log.Fatalln("missing STORAGE_PATH")
 	}
 
-	db, err := bolt.Open(args.DBPath, 0600, nil)
+	if args.TranscodingProfile == "" {
+		args.TranscodingProfile = OriginalProfile
+	}
+
+	if err := ValidateTranscodingProfile(args.TranscodingProfile); err != nil {
+		log.Fatalln(err)
+	}
+
+	var dbOpts *bolt.Options
+	if cmd != "" {
+		// the database is locked while the server is running
+		dbOpts = &bolt.Options{Timeout: time.Second}
+	}
+
+	db, err := bolt.Open(args.DBPath, 0600, dbOpts)
+	if err == bolt.ErrTimeout {
+		log.Fatalln("database", args.DBPath, "is in use, stop the server or use the /api/v1/export endpoint instead")
+	}
+
 	if err != nil {
 		log.Fatalln("failed to open BoltDB file ", args.DBPath, " :", err)
 	}
 
-	storage := newBoltStorage("feed", db)
+	if err := migrateBoltDB(db); err != nil {
+		log.Fatalln("failed to migrate the database:", err)
+	}
 
-	jobQueue := NewDownloadJobQueue(db)
-	go NewDownloadWorker(
-		jobQueue,
-		storage,
-		NewHTTPDownloader("", nil),
-		NewFFMpeg(),
-	).Run(context.Background(), 10*time.Second)
+	backend, err := openStorageBackend(args.StorageDSN, db)
+	if err != nil {
+		log.Fatalln("failed to open storage:", err)
+	}
+
+	jobQueue := NewDownloadJobQueue(backend.Jobs())
+	feeds := NewFeedRegistry(db, backend, args.StoragePath, jobQueue)
+
+	if err := ensureDefaultFeed(feeds, PodcastMetadata{
+		Title:       args.Title,
+		Description: "These videos could have been a podcast...",
+	}); err != nil {
+		log.Fatalln("failed to initialize default feed:", err)
+	}
+
+	subs := NewSubscriptionStore(db)
+	archiver := NewArchiver(db, feeds, subs, args.StoragePath)
+
+	if cmd != "" {
+		err := runArchiveCommand(cmd, flag.Args(), archiver)
+
+		if err := backend.Close(); err != nil {
+			log.Println("failed to close the storage:", err)
+		}
+
+		if err := db.Close(); err != nil {
+			log.Println("failed to close the database:", err)
+		}
+
+		if err != nil {
+			log.Fatalf("failed to %s: %s", cmd, err)
+		}
+
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	svc := NewFeedService(
-		storage,
-		args.StoragePath,
+	ytProvider := &YouTubeProvider{}
+
+	worker := NewDownloadWorker(
 		jobQueue,
+		feeds,
 		NewHTTPDownloader("", nil),
 		NewFFMpeg(),
+		args.MaxDownloads,
+		args.MaxTranscodes,
+		args.MaxAttempts,
+		args.TranscodingProfile,
 	)
+	worker.RegisterResolver(YouTubeItem, ytProvider)
 
-	srv := NewFeedServer(PodcastMetadata{
-		Title:       args.Title,
-		Description: "These videos could have been a podcast...",
-	}, svc)
+	if args.TranscriptionCommand != "" {
+		stt, err := NewCommandTranscriber(args.TranscriptionCommand)
+		if err != nil {
+			log.Fatalln("failed to initialize transcriber:", err)
+		}
+
+		worker.UseTranscriber(stt)
+	}
 
-	srv.RegisterProvider("/yt", &YouTubeProvider{})
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		worker.Run(ctx, time.Minute)
+	}()
+
+	poller := NewSubscriptionPoller(subs, feeds, ytProvider, args.SubscriptionInterval)
+
+	pollerDone := make(chan struct{})
+	go func() {
+		defer close(pollerDone)
+		poller.Run(ctx)
+	}()
+
+	auth, err := NewAuthenticator(db, args.Password)
+	if err != nil {
+		log.Fatalln("failed to initialize authentication:", err)
+	}
+
+	if !auth.Enabled() {
+		log.Println("ADMIN_PASSWORD is not set, authentication is disabled")
+	}
+
+	srv := NewFeedServer(feeds, jobQueue, subs, auth)
+	srv.SetFeedDefaults(PodcastMetadata{
+		Author:   args.Author,
+		Owner:    args.Owner,
+		Email:    args.Email,
+		Language: args.Language,
+		Category: args.Category,
+		Explicit: args.Explicit,
+	})
+	srv.SetMaxFeedItems(args.MaxFeedItems)
+	srv.UseArchiver(archiver)
+
+	srv.RegisterProvider("/yt", ytProvider)
+	srv.RegisterProvider("/rss", &FeedImportProvider{})
 
 	cachePath := path.Join(os.TempDir(), "youcast")
 	if err := os.MkdirAll(cachePath, os.ModePerm); err != nil && !os.IsExist(err) {
@@ -102,6 +238,7 @@ func main() {
 			log.Printf("failed to initialize telegram provider: %s", err)
 		} else {
 			srv.RegisterProvider("/tg", p)
+			p.UseFeeds(feeds, NewTelegramChatStore(db))
 
 			for _, idStr := range strings.Split(os.Getenv("TELEGRAM_ALLOWED_USERS"), ",") {
 				id, err := strconv.Atoi(strings.TrimSpace(idStr))
@@ -113,26 +250,20 @@ func main() {
 				p.WhitelistUser(id)
 			}
 
-			tgUpdates, err := p.Updates(context.Background())
+			tgUpdates, err := p.Updates(ctx)
 			if err != nil {
 				log.Printf("failed to start telegram updates consumption loop: %s", err)
 			} else {
 				go func() {
 					for audio := range tgUpdates {
-						meta, err := audio.Metadata(context.Background())
-						if err != nil {
-							log.Printf("failed to fetch %s data: %s", p.Name(), err)
-							continue
-						}
-
-						u, err := audio.DownloadURL(context.Background())
+						svc, err := feeds.Service(audio.Feed)
 						if err != nil {
-							log.Printf("failed to fetch download URL for %s: %s", p.Name(), err)
+							log.Printf("failed to fetch feed %s: %s", audio.Feed, err)
 							continue
 						}
 
-						if err := svc.AddItem(NewPodcastItem(meta, time.Now()), u); err != nil {
-							log.Printf("failed to add %s item to the feed: %s", p.Name(), err)
+						if _, err := svc.AddSource(ctx, audio); err != nil {
+							log.Printf("failed to add %s item to the %s feed: %s", p.Name(), audio.Feed, err)
 							continue
 						}
 					}
@@ -141,8 +272,118 @@ func main() {
 		}
 	}
 
+	server := &http.Server{
+		Addr:    args.ListenAddr,
+		Handler: CORSMiddleware(auth.Middleware(ProfileMiddleware(srv.ServeMux()))),
+	}
+
+	go func() {
+		<-ctx.Done()
+		stop() // the second signal terminates the process immediately
+
+		log.Println("shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("failed to shut down server gracefully:", err)
+		}
+	}()
+
 	log.Println("starting server on", args.ListenAddr, "...")
-	if err := http.ListenAndServe(args.ListenAddr, CORSMiddleware(ProfileMiddleware(srv.ServeMux()))); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalln(err)
 	}
+
+	<-workerDone
+	<-pollerDone
+
+	if err := backend.Close(); err != nil {
+		log.Println("failed to close the storage:", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Println("failed to close the database:", err)
+	}
+}
+
+// envString returns the value of an environment variable or def if it's not set.
+func envString(name, def string) string {
+	if s, ok := os.LookupEnv(name); ok {
+		return s
+	}
+
+	return def
+}
+
+// envBool returns the value of a boolean environment variable or def if it's not set or malformed.
+func envBool(name string, def bool) bool {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Printf("malformed %s value %q, using %t", name, s, def)
+		return def
+	}
+
+	return v
+}
+
+// envInt returns the value of an integer environment variable or def if it's not set or malformed.
+func envInt(name string, def int) int {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Printf("malformed %s value %q, using %d", name, s, def)
+		return def
+	}
+
+	return n
+}
+
+// envDuration returns the value of a duration environment variable or def if it's not set or malformed.
+func envDuration(name string, def time.Duration) time.Duration {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Printf("malformed %s value %q, using %s", name, s, def)
+		return def
+	}
+
+	return d
+}
+
+// ensureDefaultFeed registers the default feed on the first run. The title of an existing feed is only
+// updated if it has been set explicitly, so that the title changed via the web UI or the API is kept.
+func ensureDefaultFeed(feeds *FeedRegistry, meta PodcastMetadata) error {
+	feed, err := feeds.Get(DefaultFeed)
+	switch err {
+	case ErrFeedNotFound:
+		if meta.Title == "" {
+			meta.Title = DefaultPodcastTitle
+		}
+
+		return feeds.Create(PodcastFeed{Slug: DefaultFeed, PodcastMetadata: meta})
+	case nil:
+		if meta.Title == "" || feed.Title == meta.Title {
+			return nil
+		}
+
+		feed.Title = meta.Title
+
+		return feeds.Update(feed)
+	default:
+		return err
+	}
 }
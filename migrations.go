@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+// boltMigration upgrades the BoltDB schema by one version.
+type boltMigration struct {
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+}
+
+// boltMigrations lists schema migrations in the order they are applied. The schema version of a database is
+// the number of migrations that have been applied to it, so new migrations must only be appended to the list.
+var boltMigrations = []boltMigration{
+	{"store media file names instead of media URLs", migrateItemMediaURLs},
+	{"mark items added before statuses were introduced as ready", migrateLegacyItemStatuses},
+	{"assign download jobs added before multiple feeds were supported to the default feed", migrateLegacyJobFeeds},
+	{"move items of the default feed to the feed:default bucket", migrateDefaultFeedBucket},
+	{"key download jobs by item ID and feed", migrateJobKeys},
+}
+
+// migrateBoltDB brings the database schema up to date running each pending migration in its own transaction.
+// It returns an error if the database has been created by a newer version of YouCast.
+func migrateBoltDB(db *bolt.DB) error {
+	version, err := boltSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > len(boltMigrations) {
+		return fmt.Errorf("database schema version %d is newer than %d supported by YouCast %s", version, len(boltMigrations), Version)
+	}
+
+	for ; version < len(boltMigrations); version++ {
+		m := boltMigrations[version]
+
+		log.Printf("migrating database schema to version %d: %s", version+1, m.Description)
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+
+			b, err := tx.CreateBucketIfNotExists(metaBucket)
+			if err != nil {
+				return fmt.Errorf("failed to open meta bucket: %w", err)
+			}
+
+			return b.Put(schemaVersionKey, []byte(strconv.Itoa(version+1)))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate database schema to version %d: %w", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+// boltSchemaVersion returns the schema version of the database, databases created before schema versioning
+// was introduced have version 0.
+func boltSchemaVersion(db *bolt.DB) (int, error) {
+	var version int
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get(schemaVersionKey)
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		if version, err = strconv.Atoi(string(v)); err != nil {
+			return fmt.Errorf("malformed database schema version %q: %w", v, err)
+		}
+
+		return nil
+	})
+
+	return version, err
+}
+
+// isItemBucket returns true if the bucket holds podcast items. Items of the default feed used to be stored in
+// the "feed" bucket, the rest of feeds have their items in "feed:<slug>" buckets.
+func isItemBucket(name []byte) bool {
+	return string(name) == "feed" || strings.HasPrefix(string(name), "feed:")
+}
+
+// updateBoltItems applies fn to all stored podcast items saving the ones fn returns true for.
+func updateBoltItems(tx *bolt.Tx, fn func(*boltPodcastItem) bool) error {
+	var buckets []string
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if isItemBucket(name) {
+			buckets = append(buckets, string(name))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range buckets {
+		b := tx.Bucket([]byte(name))
+		updated := make(map[string][]byte)
+
+		err := b.ForEach(func(k, v []byte) error {
+			var it boltPodcastItem
+			if err := json.Unmarshal(v, &it); err != nil {
+				return fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, name, err)
+			}
+
+			if !fn(&it) {
+				return nil
+			}
+
+			data, err := json.Marshal(it)
+			if err != nil {
+				return fmt.Errorf("failed to marshal podcast item %q in %q: %w", k, name, err)
+			}
+
+			updated[string(k)] = data
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// buckets cannot be modified while iterating over them
+		for k, v := range updated {
+			if err := b.Put([]byte(k), v); err != nil {
+				return fmt.Errorf("failed to store podcast item %q in %q: %w", k, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateItemMediaURLs replaces media URLs stored by early versions with media file names.
+func migrateItemMediaURLs(tx *bolt.Tx) error {
+	return updateBoltItems(tx, func(it *boltPodcastItem) bool {
+		if it.MediaURL == "" {
+			return false
+		}
+
+		if it.FileName == "" {
+			it.FileName = path.Base(it.MediaURL)
+		}
+
+		it.MediaURL = ""
+
+		return true
+	})
+}
+
+// migrateLegacyItemStatuses marks items stored before download statuses were introduced as ready, since
+// only downloaded items used to be added to the feed.
+func migrateLegacyItemStatuses(tx *bolt.Tx) error {
+	return updateBoltItems(tx, func(it *boltPodcastItem) bool {
+		if it.Status != 0 {
+			return false
+		}
+
+		it.Status = ItemReady
+
+		return true
+	})
+}
+
+// migrateLegacyJobFeeds assigns download jobs that don't reference any feed to the default one.
+func migrateLegacyJobFeeds(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("downloads"))
+	if b == nil {
+		return nil
+	}
+
+	updated := make(map[string][]byte)
+
+	err := b.ForEach(func(k, v []byte) error {
+		var j boltJob
+		if err := json.Unmarshal(v, &j); err != nil {
+			return fmt.Errorf("failed to unmarshal job %q: %w", k, err)
+		}
+
+		if j.Feed == "" {
+			j.Feed = DefaultFeed
+			updated[string(k)] = j.MarshalBinary()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range updated {
+		if err := b.Put([]byte(k), v); err != nil {
+			return fmt.Errorf("failed to store job %q: %w", k, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateDefaultFeedBucket moves items of the default feed from the "feed" bucket into "feed:default",
+// so that items of all feeds are stored in the same way.
+func migrateDefaultFeedBucket(tx *bolt.Tx) error {
+	oldName, newName := []byte("feed"), []byte(feedBucket(DefaultFeed))
+
+	old := tx.Bucket(oldName)
+	if old == nil {
+		return nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists(newName)
+	if err != nil {
+		return fmt.Errorf("failed to create %q bucket: %w", newName, err)
+	}
+
+	err = old.ForEach(func(k, v []byte) error {
+		return b.Put(k, v)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move items to %q: %w", newName, err)
+	}
+
+	if err := tx.DeleteBucket(oldName); err != nil {
+		return fmt.Errorf("failed to remove %q bucket: %w", oldName, err)
+	}
+
+	if revs := tx.Bucket(revisionsBucket); revs != nil {
+		if v := revs.Get(oldName); v != nil {
+			v = append([]byte(nil), v...) // the value is not valid once the bucket is modified
+
+			if err := revs.Put(newName, v); err != nil {
+				return fmt.Errorf("failed to move revision to %q: %w", newName, err)
+			}
+
+			if err := revs.Delete(oldName); err != nil {
+				return fmt.Errorf("failed to remove revision of %q: %w", oldName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateJobKeys adds the feed slug to the keys of download jobs that used to be keyed by item ID only, so that
+// items with the same ID in different feeds don't share their jobs.
+func migrateJobKeys(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte("downloads"))
+	if b == nil {
+		return nil
+	}
+
+	legacy := make(map[string]boltJob)
+
+	err := b.ForEach(func(k, v []byte) error {
+		if strings.Contains(string(k), " ") {
+			return nil
+		}
+
+		var j boltJob
+		if err := json.Unmarshal(v, &j); err != nil {
+			return fmt.Errorf("failed to unmarshal job %q: %w", k, err)
+		}
+
+		legacy[string(k)] = j
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for itemID, j := range legacy {
+		if err := b.Delete([]byte(itemID)); err != nil {
+			return fmt.Errorf("failed to remove job %q: %w", itemID, err)
+		}
+
+		if err := b.Put(boltJobKey(j.Feed, itemID), j.MarshalBinary()); err != nil {
+			return fmt.Errorf("failed to store job %q: %w", itemID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func openTestBoltDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// putBoltRecords stores raw records into buckets, the bucket and the key are separated with a slash.
+func putBoltRecords(t *testing.T, db *bolt.DB, records map[string]string) {
+	t.Helper()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for k, v := range records {
+			bucket, key, _ := strings.Cut(k, "/")
+
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put([]byte(key), []byte(v)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBoltDB(t *testing.T) {
+	db := openTestBoltDB(t)
+
+	putBoltRecords(t, db, map[string]string{
+		"feed/2017-01-02T10:00:00Z":       `{"Type":1,"Title":"Legacy","MediaURL":"https://example.com/downloads/legacy.mp3"}`,
+		"feed/2024-03-01T10:00:00Z":       `{"Type":3,"Title":"Downloaded","FileName":"new.m4a","Status":2}`,
+		"feed:other/2024-03-01T10:00:00Z": `{"Type":3,"Title":"Other","MediaURL":"https://example.com/downloads/other.mp3","FileName":"kept.mp3","Status":1}`,
+		"downloads/2024-03-01T10:00:00Z":  `{"Status":1,"SourceURI":"https://example.com/new.m4a"}`,
+		"revisions/feed":                  `{"Number":7,"ModifiedAt":"2024-03-01T10:00:00Z"}`,
+	})
+
+	if err := migrateBoltDB(db); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if version, err := boltSchemaVersion(db); err != nil || version != len(boltMigrations) {
+		t.Errorf("expected schema version %d, got %d (%v)", len(boltMigrations), version, err)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("feed")) != nil {
+			t.Errorf("expected the feed bucket to be removed")
+		}
+
+		if v := tx.Bucket(revisionsBucket).Get([]byte("feed")); v != nil {
+			t.Errorf("expected the revision of the feed bucket to be removed, got %s", v)
+		}
+
+		return nil
+	})
+
+	st := newBoltStorage(feedBucket(DefaultFeed), db)
+
+	items, err := st.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type itemState struct {
+		Title, FileName string
+		Status          Status
+	}
+
+	var actual []itemState
+	for _, item := range items {
+		actual = append(actual, itemState{item.Title, item.FileName, item.Status})
+	}
+
+	expected := []itemState{
+		{"Downloaded", "new.m4a", ItemDownloaded},
+		{"Legacy", "legacy.mp3", ItemReady},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected default feed items %+v, got %+v", expected, actual)
+	}
+
+	other, err := newBoltStorage(feedBucket("other"), db).Item("2024-03-01T10:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.FileName != "kept.mp3" || other.Status != ItemAdded {
+		t.Errorf("expected the item of another feed to keep its file name and status, got %q, %s", other.FileName, other.Status)
+	}
+
+	rev, err := st.Revision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := (Revision{7, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}); rev.Number != expected.Number || !rev.ModifiedAt.Equal(expected.ModifiedAt) {
+		t.Errorf("expected the default feed revision to be moved, got %+v", rev)
+	}
+
+	jobs, err := newBoltJobStore(db).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedJobs := []DownloadJob{{Feed: DefaultFeed, ItemID: "2024-03-01T10:00:00Z", Status: StatusAdded, SourceURI: "https://example.com/new.m4a"}}
+	if !reflect.DeepEqual(jobs, expectedJobs) {
+		t.Errorf("expected jobs %+v, got %+v", expectedJobs, jobs)
+	}
+
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("downloads")).Get(boltJobKey(DefaultFeed, "2024-03-01T10:00:00Z")); v == nil {
+			t.Errorf("expected the job to be keyed by item ID and feed")
+		}
+
+		return nil
+	})
+
+	// migrations are not applied again
+	putBoltRecords(t, db, map[string]string{
+		"feed:default/2024-03-02T10:00:00Z": `{"Type":3,"Title":"Added later"}`,
+	})
+
+	if err := migrateBoltDB(db); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if item, err := st.Item("2024-03-02T10:00:00Z"); err != nil || item.Status != 0 {
+		t.Errorf("expected items added after the migration to be kept as is, got %s (%v)", item.Status, err)
+	}
+}
+
+func TestMigrateBoltDB_NewDatabase(t *testing.T) {
+	db := openTestBoltDB(t)
+
+	if err := migrateBoltDB(db); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if version, err := boltSchemaVersion(db); err != nil || version != len(boltMigrations) {
+		t.Errorf("expected schema version %d, got %d (%v)", len(boltMigrations), version, err)
+	}
+}
+
+func TestMigrateBoltDB_Errors(t *testing.T) {
+	for name, version := range map[string]string{
+		"newer schema":     strconv.Itoa(len(boltMigrations) + 1),
+		"malformed schema": "v1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestBoltDB(t)
+
+			putBoltRecords(t, db, map[string]string{
+				"meta/schema_version":       version,
+				"feed/2017-01-02T10:00:00Z": `{"Type":1,"Title":"Legacy","MediaURL":"https://example.com/downloads/legacy.mp3"}`,
+			})
+
+			if err := migrateBoltDB(db); err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			db.View(func(tx *bolt.Tx) error {
+				if v := tx.Bucket(metaBucket).Get(schemaVersionKey); string(v) != version {
+					t.Errorf("expected schema version to remain %q, got %q", version, v)
+				}
+
+				if tx.Bucket([]byte("feed")) == nil {
+					t.Errorf("expected data not to be migrated")
+				}
+
+				return nil
+			})
+		})
+	}
+}
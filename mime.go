@@ -9,6 +9,7 @@ var mimeTypes = map[string]string{
 	"audio/mpeg":  ".mp3",
 	"audio/mp4":   ".m4a",
 	"audio/x-m4a": ".m4a",
+	"audio/ogg":   ".opus",
 }
 
 func init() {
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// OPMLRenderer renders the list of feeds served by the instance as an OPML 2.0 document,
+// see http://opml.org/spec2.opml
+type OPMLRenderer struct {
+	// BaseURL is the scheme and the host feed paths are relative to.
+	BaseURL string
+	// Query is appended to each feed URL, i.e. to pass the subscriber token.
+	Query string
+}
+
+// ContentType returns the content type of the rendered document.
+func (OPMLRenderer) ContentType() string {
+	return "text/x-opml; charset=utf-8"
+}
+
+// Render renders the list of feeds to the given writer.
+func (r OPMLRenderer) Render(w io.Writer, feed Feed) error {
+	doc := opmlDocument{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       feed.Title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, f := range feed.Feeds {
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Type:        "rss",
+			Text:        f.Title,
+			Title:       f.Title,
+			Description: f.Description,
+			XMLURL:      r.BaseURL + f.Path() + r.Query,
+			HTMLURL:     f.Link,
+			Language:    f.Language,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated"`
+}
+
+type opmlOutline struct {
+	Type        string `xml:"type,attr"`
+	Text        string `xml:"text,attr"`
+	Title       string `xml:"title,attr"`
+	Description string `xml:"description,attr,omitempty"`
+	XMLURL      string `xml:"xmlUrl,attr"`
+	HTMLURL     string `xml:"htmlUrl,attr,omitempty"`
+	Language    string `xml:"language,attr,omitempty"`
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RSSRenderer renders a podcast feed as RSS 2.0 with iTunes and Podcasting 2.0 extensions.
+type RSSRenderer struct{}
+
+// ContentType returns the content type of the rendered feed.
+func (RSSRenderer) ContentType() string {
+	return "application/rss+xml; charset=utf-8"
+}
+
+// Render renders the feed to the given writer.
+func (RSSRenderer) Render(w io.Writer, feed Feed) error {
+	ch := rssChannel{
+		AtomLink: rssAtomLink{
+			Href: feed.SelfURL,
+			Rel:  "self",
+			Type: "application/rss+xml",
+		},
+		Title:       feed.Title,
+		Link:        feed.URL,
+		Description: feed.Description,
+		Language:    feed.Language,
+		Generator:   "YouCast " + Version,
+		Image: rssImage{
+			URL:   feed.IconURL,
+			Title: feed.Title,
+			Link:  feed.URL,
+		},
+		IAuthor:   feed.Author,
+		IImage:    rssITunesImage{feed.IconURL},
+		IExplicit: strconv.FormatBool(feed.Explicit),
+		IType:     "episodic",
+	}
+
+	if ch.Description == "" {
+		ch.Description = feed.Title // the description is required
+	}
+
+	if !feed.PubDate.IsZero() {
+		ch.PubDate = feed.PubDate.Format(time.RFC1123Z)
+		ch.LastBuildDate = ch.PubDate
+	}
+
+	owner := feed.Owner
+	if owner == "" {
+		owner = feed.Author
+	}
+
+	if owner != "" || feed.Email != "" {
+		ch.IOwner = &rssITunesOwner{Name: owner, Email: feed.Email}
+	}
+
+	if feed.Email != "" {
+		ch.ManagingEditor = feed.Email
+		if owner != "" {
+			ch.ManagingEditor += " (" + owner + ")"
+		}
+	}
+
+	if feed.Category != "" {
+		cat, sub, _ := strings.Cut(feed.Category, ">")
+		cat, sub = strings.TrimSpace(cat), strings.TrimSpace(sub)
+
+		ch.Category = cat
+		ch.ICategory = &rssITunesCategory{Text: cat}
+
+		if sub != "" {
+			ch.ICategory.Sub = &rssITunesCategory{Text: sub}
+		}
+	}
+
+	for _, it := range feed.Items {
+		if !it.Playable() {
+			continue // skip incomplete items
+		}
+
+		item := rssItem{
+			GUID:         rssGUID{ID: it.ID()},
+			Title:        it.Title,
+			Link:         it.OriginalURL,
+			Description:  itemSummary(it),
+			PubDate:      it.AddedAt.Format(time.RFC1123Z),
+			Enclosure:    rssEnclosure{it.MediaURL, it.ContentLength, enclosureType(it.MIMEType)},
+			ITitle:       it.Title,
+			IAuthor:      it.Author,
+			IDuration:    strconv.FormatInt(int64(it.Duration/time.Second), 10),
+			IEpisodeType: "full",
+		}
+
+		if item.Description == "" {
+			item.Description = itemDescription(it)
+		}
+
+		if it.ImageURL != "" {
+			item.IImage = &rssITunesImage{it.ImageURL}
+		}
+
+		if it.ChaptersURL != "" {
+			item.Chapters = &rssChapters{URL: it.ChaptersURL, Type: "application/json+chapters"}
+		}
+
+		for _, t := range it.Transcripts {
+			rssT := rssTranscript{URL: t.URL, Type: t.Type, Language: it.TranscriptLanguage}
+			if t.Type == "text/vtt" || t.Type == "application/x-subrip" {
+				rssT.Rel = "captions"
+			}
+
+			item.Transcripts = append(item.Transcripts, rssT)
+		}
+
+		ch.Items = append(ch.Items, item)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	return enc.Encode(rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ITunesNS:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		PodcastNS: "https://podcastindex.org/namespace/1.0",
+		Channel:   ch,
+	})
+}
+
+// enclosureType returns the MIME type of the media file to be used as an enclosure type. Non-standard
+// aliases are replaced with the registered types.
+func enclosureType(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	switch mimeType = strings.TrimSpace(mimeType); mimeType {
+	case "audio/mp4a.20.2", "audio/x-m4a", "audio/m4a":
+		return "audio/mp4"
+	case "audio/mp3", "audio/x-mp3", "audio/mpeg3":
+		return "audio/mpeg"
+	case "audio/opus":
+		return "audio/ogg"
+	case "video/x-m4v":
+		return "video/mp4"
+	case "":
+		return "audio/mpeg"
+	default:
+		return mimeType
+	}
+}
+
+// rssFeed is an RSS 2.0 feed, see https://www.rssboard.org/rss-specification,
+// https://help.apple.com/itc/podcasts_connect/#/itcb54353390 and https://podcastindex.org/namespace/1.0
+type rssFeed struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	AtomNS    string   `xml:"xmlns:atom,attr"`
+	ITunesNS  string   `xml:"xmlns:itunes,attr"`
+	PodcastNS string   `xml:"xmlns:podcast,attr"`
+	Channel   rssChannel
+}
+
+type rssChannel struct {
+	XMLName        xml.Name    `xml:"channel"`
+	AtomLink       rssAtomLink `xml:"atom:link"`
+	Title          string      `xml:"title"`
+	Link           string      `xml:"link"`
+	Description    string      `xml:"description"`
+	Language       string      `xml:"language,omitempty"`
+	Category       string      `xml:"category,omitempty"`
+	Generator      string      `xml:"generator"`
+	ManagingEditor string      `xml:"managingEditor,omitempty"`
+	PubDate        string      `xml:"pubDate,omitempty"`
+	LastBuildDate  string      `xml:"lastBuildDate,omitempty"`
+	Image          rssImage    `xml:"image"`
+
+	IAuthor   string             `xml:"itunes:author,omitempty"`
+	IOwner    *rssITunesOwner    `xml:"itunes:owner"`
+	IImage    rssITunesImage     `xml:"itunes:image"`
+	ICategory *rssITunesCategory `xml:"itunes:category"`
+	IExplicit string             `xml:"itunes:explicit"`
+	IType     string             `xml:"itunes:type"`
+
+	Items []rssItem `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type rssITunesOwner struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email,omitempty"`
+}
+
+type rssITunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssITunesCategory struct {
+	Text string             `xml:"text,attr"`
+	Sub  *rssITunesCategory `xml:"itunes:category"`
+}
+
+type rssItem struct {
+	GUID        rssGUID      `xml:"guid"`
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link,omitempty"`
+	Description string       `xml:"description"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+
+	ITitle       string          `xml:"itunes:title"`
+	IAuthor      string          `xml:"itunes:author,omitempty"`
+	IImage       *rssITunesImage `xml:"itunes:image"`
+	IDuration    string          `xml:"itunes:duration"`
+	IEpisodeType string          `xml:"itunes:episodeType"`
+
+	Chapters    *rssChapters    `xml:"podcast:chapters"`
+	Transcripts []rssTranscript `xml:"podcast:transcript"`
+}
+
+// rssGUID is an item GUID. Item IDs are not URLs, so they're marked as such.
+type rssGUID struct {
+	ID          string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssChapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssTranscript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr,omitempty"`
+	Rel      string `xml:"rel,attr,omitempty"`
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andrewslotin/youcast/assets"
-	"github.com/eduncan911/podcast"
 )
 
 // PodcastMetadata contains metadata for the podcast feed.
@@ -19,6 +26,45 @@ type PodcastMetadata struct {
 	Title       string
 	Link        string
 	Description string
+	IconURL     string
+	Author      string
+	// Owner and Email are the contact details of the feed owner.
+	Owner string
+	Email string
+	// Language is the feed language code, i.e. "en" or "en-us".
+	Language string
+	// Category is the iTunes category of the feed optionally followed by a subcategory,
+	// i.e. "Society & Culture > Documentary".
+	Category string
+	Explicit bool
+}
+
+// WithDefaults returns the metadata with empty author, owner, contact, language and category fields
+// taken from defaults. The feed is considered explicit if either of them is.
+func (meta PodcastMetadata) WithDefaults(defaults PodcastMetadata) PodcastMetadata {
+	if meta.Author == "" {
+		meta.Author = defaults.Author
+	}
+
+	if meta.Owner == "" {
+		meta.Owner = defaults.Owner
+	}
+
+	if meta.Email == "" {
+		meta.Email = defaults.Email
+	}
+
+	if meta.Language == "" {
+		meta.Language = defaults.Language
+	}
+
+	if meta.Category == "" {
+		meta.Category = defaults.Category
+	}
+
+	meta.Explicit = meta.Explicit || defaults.Explicit
+
+	return meta
 }
 
 // Metadata contains metadata for a podcast item
@@ -31,34 +77,141 @@ type Metadata struct {
 	Duration      time.Duration
 	MIMEType      string
 	ContentLength int64
+	// StartOffset is the position in the original media the podcast item starts at.
+	StartOffset time.Duration
+	// ImageURL is the location of the episode artwork.
+	ImageURL string
+	// Image is the episode artwork embedded into the media file, it takes precedence over ImageURL.
+	Image         []byte
+	ImageMIMEType string
+	// TranscodingProfile is the name of the profile to transcode the item with, the feed profile is used if empty.
+	TranscodingProfile string
+	Chapters           []Chapter
+	// PublishedAt is the original publication time of an imported episode, the item is added with
+	// the current time if it's empty.
+	PublishedAt time.Time
 }
 
+// ErrInvalidRequest is returned by providers when the request does not contain a valid audio source.
+var ErrInvalidRequest = errors.New("invalid request")
+
 type audioSource interface {
 	Metadata(context.Context) (Metadata, error)
 	DownloadURL(context.Context) (string, error)
 }
 
+// audioSourceList is implemented by audio sources that reference several items, i.e. playlists. Such sources
+// are added to the feed as a list of items each having its own audio source.
+type audioSourceList interface {
+	audioSource
+	// Sources returns the referenced audio sources in the order they should be added to the feed. Sources
+	// with the original URL for which exists returns true are omitted if the list is set to skip existing items.
+	Sources(ctx context.Context, exists func(originalURL string) bool) ([]audioSource, error)
+}
+
 type audioSourceProvider interface {
 	Name() string
 	HandleRequest(http.ResponseWriter, *http.Request) audioSource
 }
 
+// audioSourceParser is implemented by providers that can extract an audio source from the request
+// without writing the response, which allows them to be used via API.
+type audioSourceParser interface {
+	ParseRequest(*http.Request) (audioSource, error)
+}
+
+// sourceResolver is implemented by providers that can re-create an audio source from the original URL
+// of an item, i.e. to get a fresh download URL once the previous one has expired.
+type sourceResolver interface {
+	ResolveSource(originalURL string) (audioSource, error)
+}
+
+// profiledSource is an audio source that sets the transcoding profile of the added item.
+type profiledSource struct {
+	audioSource
+	profile string
+}
+
+// Metadata returns the source metadata with the transcoding profile set.
+func (src profiledSource) Metadata(ctx context.Context) (Metadata, error) {
+	meta, err := src.audioSource.Metadata(ctx)
+	meta.TranscodingProfile = src.profile
+
+	return meta, err
+}
+
+// profiledSourceList is an audio source list that sets the transcoding profile of each added item.
+type profiledSourceList struct {
+	audioSourceList
+	profile string
+}
+
+// Sources returns the list entries with the transcoding profile set.
+func (list profiledSourceList) Sources(ctx context.Context, exists func(string) bool) ([]audioSource, error) {
+	srcs, err := list.audioSourceList.Sources(ctx, exists)
+	for i, src := range srcs {
+		srcs[i] = profiledSource{src, list.profile}
+	}
+
+	return srcs, err
+}
+
+// withTranscodingProfile returns an audio source that adds items with given transcoding profile. The source
+// is returned as is if the profile is empty.
+func withTranscodingProfile(src audioSource, profile string) audioSource {
+	if profile == "" {
+		return src
+	}
+
+	if list, ok := src.(audioSourceList); ok {
+		return profiledSourceList{list, profile}
+	}
+
+	return profiledSource{src, profile}
+}
+
 // FeedServer is an HTTP server that serves podcast feeds and manages podcast items.
 type FeedServer struct {
-	svc       *FeedService
-	meta      PodcastMetadata
+	feeds     *FeedRegistry
+	q         *DownloadJobQueue
+	subs      *SubscriptionStore
+	auth      *Authenticator
 	providers map[string]audioSourceProvider
+	defaults  PodcastMetadata
+	maxItems  int
+	cache     *feedCache
+	archiver  *Archiver
 }
 
 // NewFeedServer creates a new FeedServer instance.
-func NewFeedServer(meta PodcastMetadata, svc *FeedService) *FeedServer {
+func NewFeedServer(feeds *FeedRegistry, q *DownloadJobQueue, subs *SubscriptionStore, auth *Authenticator) *FeedServer {
 	return &FeedServer{
-		svc:       svc,
-		meta:      meta,
+		feeds:     feeds,
+		q:         q,
+		subs:      subs,
+		auth:      auth,
 		providers: make(map[string]audioSourceProvider),
+		cache:     newFeedCache(),
 	}
 }
 
+// SetFeedDefaults sets the metadata used for feeds that don't specify their author, owner, contact details,
+// language or category.
+func (srv *FeedServer) SetFeedDefaults(meta PodcastMetadata) {
+	srv.defaults = meta
+}
+
+// SetMaxFeedItems limits the number of latest episodes included into podcast feeds. Feeds include all
+// episodes if n is 0.
+func (srv *FeedServer) SetMaxFeedItems(n int) {
+	srv.maxItems = n
+}
+
+// UseArchiver enables the instance data export via API.
+func (srv *FeedServer) UseArchiver(a *Archiver) {
+	srv.archiver = a
+}
+
 // ServeMux returns a ServeMux instance that can be used to serve the podcast feed.
 func (srv *FeedServer) ServeMux() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -66,11 +219,22 @@ func (srv *FeedServer) ServeMux() *http.ServeMux {
 	mux.HandleFunc("/", srv.ServeFeed)
 	mux.HandleFunc("/add/", srv.HandleAddItem)
 	mux.HandleFunc("/feed", srv.ServeFeed)
+	mux.HandleFunc("/feed.json", srv.ServeFeed)
+	mux.HandleFunc("/feeds.opml", srv.ServeOPML)
 	mux.HandleFunc("/feed/", srv.HandleItem)
+	mux.HandleFunc("/feeds", srv.HandleFeed)
+	mux.HandleFunc("/feeds/", srv.HandleFeed)
+	mux.HandleFunc("/tokens", srv.HandleToken)
+	mux.HandleFunc("/tokens/", srv.HandleToken)
+	mux.HandleFunc("/subscriptions", srv.HandleSubscription)
+	mux.HandleFunc("/subscriptions/", srv.HandleSubscription)
 	mux.HandleFunc("/favicon.ico", AssetHandler(assets.Icon, "image/png"))
 	mux.HandleFunc("/style.css", AssetHandler(assets.Stylesheet, "text/css"))
 	mux.HandleFunc("/script.js", AssetHandler(assets.JavaScript, "text/javascript"))
 	mux.HandleFunc("/downloads/", srv.ServeMedia)
+	mux.HandleFunc("/chapters/", srv.ServeChapters)
+	mux.HandleFunc("/transcripts/", srv.ServeTranscript)
+	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", srv.APIMux()))
 
 	return mux
 }
@@ -81,67 +245,316 @@ func (srv *FeedServer) RegisterProvider(subPath string, p audioSourceProvider) {
 	srv.providers[subPath] = p
 }
 
-// ServeFeed serves the podcast feed.
+// feedView renders a podcast feed in a certain format.
+type feedView interface {
+	ContentType() string
+	Render(io.Writer, Feed) error
+}
+
+// negotiateFeedView returns the feed renderer for the format requested via the Accept header. Feeds are
+// rendered as RSS unless JSON Feed is explicitly preferred.
+func negotiateFeedView(req *http.Request) feedView {
+	switch preferredType(req.Header.Get("Accept"), "application/rss+xml", "application/feed+json", "application/json") {
+	case "application/feed+json", "application/json":
+		return JSONFeedRenderer{}
+	default:
+		return RSSRenderer{}
+	}
+}
+
+// ServeFeed serves the podcast feed. The web UI served at / displays the feed specified by
+// the feed= parameter, /feed serves the default feed as RSS or JSON Feed depending on the Accept
+// header, and /feed.json always serves it as JSON Feed.
 func (srv *FeedServer) ServeFeed(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/feed":
+		srv.serveFeed(w, req, DefaultFeed, negotiateFeedView(req))
+	case "/feed.json":
+		srv.serveFeed(w, req, DefaultFeed, JSONFeedRenderer{})
+	default:
+		tmpl := Templates
+		if args.DevMode {
+			tmpl = ParseTemplates(os.DirFS("./assets"))
+		}
+
+		srv.serveFeed(w, req, req.FormValue("feed"), HTMLRenderer{
+			Template: tmpl.Lookup("index.html.tmpl"),
+		})
+	}
+}
+
+func (srv *FeedServer) serveFeed(w http.ResponseWriter, req *http.Request, slug string, view feedView) {
+	meta, err := srv.feeds.Get(slug)
+	if err != nil {
+		if err == ErrFeedNotFound {
+			http.NotFound(w, req)
+			return
+		}
+
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	svc, err := srv.feeds.Service(meta.Slug)
+	if err != nil {
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// The web UI is rendered on each request one page at a time, while subscription feeds are cached
+	// until the feed revision changes.
+	if _, ok := view.(HTMLRenderer); ok {
+		limit := queryLimit(req)
+		if limit == 0 || limit > MaxItemsPerPage {
+			limit = DefaultItemsPerPage
+		}
+
+		offset, _ := strconv.Atoi(req.FormValue("offset"))
+
+		items, next, err := svc.ItemsPage(req.FormValue("after"), limit)
+		if err != nil {
+			log.Println("failed to fetch podcast items: ", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		feed, err := srv.buildFeed(req, meta, items)
+		if err != nil {
+			log.Println("failed to build feed", meta.Slug, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		feed.Offset = max(offset, 0)
+		if next != "" {
+			q := url.Values{
+				"feed":   {meta.Slug},
+				"after":  {next},
+				"offset": {strconv.Itoa(feed.Offset + len(items))},
+			}
+
+			if req.FormValue("limit") != "" {
+				q.Set("limit", strconv.Itoa(limit))
+			}
+
+			feed.NextPageURL = "/?" + q.Encode()
+		}
+
+		w.Header().Set("Content-Type", view.ContentType())
+		if err := view.Render(w, feed); err != nil {
+			log.Println("failed to render feed to", view.ContentType(), ":", err)
+		}
+
+		return
+	}
+
+	rev, err := srv.feeds.Revision(meta.Slug)
+	if err != nil {
+		log.Println("failed to fetch revision of feed", meta.Slug, ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	key := meta.Slug + " " + view.ContentType() + " " + reqScheme(req) + "://" + req.Host + req.URL.RequestURI()
+
+	rendered, ok := srv.cache.Get(key, rev)
+	if !ok {
+		limit := queryLimit(req)
+		if limit == 0 || (srv.maxItems > 0 && limit > srv.maxItems) {
+			limit = srv.maxItems
+		}
+
+		items, err := playableItems(svc, limit)
+		if err != nil {
+			log.Println("failed to fetch podcast items: ", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		feed, err := srv.buildFeed(req, meta, items)
+		if err != nil {
+			log.Println("failed to build feed", meta.Slug, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := view.Render(&buf, feed); err != nil {
+			log.Println("failed to render feed to", view.ContentType(), ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if rendered, err = newRenderedFeed(rev, buf.Bytes()); err != nil {
+			log.Println("failed to compress feed", meta.Slug, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		srv.cache.Put(key, rendered)
+	}
+
+	w.Header().Set("Content-Type", view.ContentType())
+	rendered.ServeHTTP(w, req)
+}
+
+// playableItems returns up to limit latest items that have been downloaded, or all of them if limit is 0.
+func playableItems(svc *FeedService, limit int) ([]PodcastItem, error) {
+	if limit == 0 {
+		return svc.Items()
+	}
+
+	var (
+		items []PodcastItem
+		after string
+	)
+
+	for {
+		page, next, err := svc.ItemsPage(after, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			if !item.Playable() {
+				continue
+			}
+
+			if items = append(items, item); len(items) == limit {
+				return items, nil
+			}
+		}
+
+		if next == "" {
+			return items, nil
+		}
+
+		after = next
+	}
+}
+
+// buildFeed returns the feed view with given items along with the data displayed in the web UI.
+func (srv *FeedServer) buildFeed(req *http.Request, meta PodcastFeed, items []PodcastItem) (Feed, error) {
+	var err error
+
 	scheme := reqScheme(req)
+	md := meta.PodcastMetadata.WithDefaults(srv.defaults)
 
 	feed := Feed{
-		URL:         srv.meta.Link,
-		IconURL:     scheme + "://" + req.Host + "/favicon.ico",
-		Title:       srv.meta.Title,
-		Description: srv.meta.Description,
+		Slug:        meta.Slug,
+		Path:        meta.Path(),
+		URL:         meta.Link,
+		IconURL:     meta.IconURL,
+		SelfURL:     scheme + "://" + req.Host + req.URL.RequestURI(),
+		Title:       meta.Title,
+		Description: meta.Description,
+		Author:      md.Author,
+		Owner:       md.Owner,
+		Email:       md.Email,
+		Language:    md.Language,
+		Category:    md.Category,
+		Explicit:    md.Explicit,
+
+		TranscodingProfile: meta.TranscodingProfile,
+		PostProcessing:     meta.PostProcessing,
 	}
 
 	if feed.URL == "" {
 		feed.URL = scheme + "://" + req.Host
 	}
 
-	items, err := srv.svc.Items()
-	if err != nil {
-		log.Println("failed to fetch podcast items: ", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+	if feed.IconURL == "" {
+		feed.IconURL = scheme + "://" + req.Host + "/favicon.ico"
+	}
+
+	if feed.Feeds, err = srv.feeds.All(); err != nil {
+		return feed, fmt.Errorf("failed to fetch feeds: %w", err)
+	}
+
+	if feed.Subscriptions, err = srv.subs.All(meta.Slug); err != nil {
+		return feed, fmt.Errorf("failed to fetch subscriptions: %w", err)
 	}
 
+	if feed.AuthEnabled = srv.auth.Enabled(); feed.AuthEnabled {
+		tokens, err := srv.auth.Tokens.All()
+		if err != nil {
+			return feed, fmt.Errorf("failed to fetch access tokens: %w", err)
+		}
+
+		for _, t := range tokens {
+			if t.Kind == SubscriberToken {
+				feed.Tokens = append(feed.Tokens, t)
+			}
+		}
+	}
+
+	mediaQuery := srv.tokenQuery(req)
+
 	for _, item := range items {
-		feed.Items = append(feed.Items, DownloadablePodcastItem{
+		it := DownloadablePodcastItem{
 			PodcastItem: item,
-			MediaURL:    scheme + "://" + req.Host + "/downloads/" + item.FileName,
-		})
+			MediaURL:    scheme + "://" + req.Host + "/downloads/" + item.FileName + mediaQuery,
+		}
+
+		if item.ImageFileName != "" {
+			it.ImageURL = scheme + "://" + req.Host + "/downloads/" + item.ImageFileName + mediaQuery
+		}
+
+		if len(item.Chapters) > 0 {
+			it.ChaptersURL = scheme + "://" + req.Host + chaptersPath(meta.Slug, item.ID()) + mediaQuery
+		}
+
+		if item.TranscriptFileName != "" {
+			for _, f := range TranscriptFormats {
+				it.Transcripts = append(it.Transcripts, TranscriptLink{
+					Format: strings.TrimPrefix(f.Ext, "."),
+					URL:    scheme + "://" + req.Host + transcriptPath(meta.Slug, item.ID(), f.Ext) + mediaQuery,
+					Type:   f.ContentType,
+				})
+			}
+		}
+
+		feed.Items = append(feed.Items, it)
 	}
 
 	if len(items) > 0 {
 		feed.PubDate = items[len(items)-1].AddedAt
 	}
 
-	var view interface {
-		ContentType() string
-		Render(io.Writer, Feed) error
+	return feed, nil
+}
+
+// ServeOPML serves the list of all feeds as an OPML document to subscribe to them at once.
+func (srv *FeedServer) ServeOPML(w http.ResponseWriter, req *http.Request) {
+	feeds, err := srv.feeds.All()
+	if err != nil {
+		log.Println("failed to fetch feeds: ", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
-	switch req.URL.Path {
-	case "/feed":
-		view = AtomRenderer{}
-	default:
-		tmpl := Templates
-		if args.DevMode {
-			tmpl = ParseTemplates(os.DirFS("./assets"))
-		}
-		view = HTMLRenderer{
-			Template: tmpl.Lookup("index.html.tmpl"),
-		}
+	feed := Feed{Feeds: feeds}
+	if len(feeds) > 0 {
+		feed.Title = feeds[0].Title // the default feed goes first
+	}
+
+	view := OPMLRenderer{
+		BaseURL: reqScheme(req) + "://" + req.Host,
+		Query:   srv.tokenQuery(req),
 	}
 
 	w.Header().Set("Content-Type", view.ContentType())
 	if err := view.Render(w, feed); err != nil {
-		log.Println("failed to render feed to", view.ContentType(), ":", err)
+		log.Println("failed to render feeds to", view.ContentType(), ":", err)
 	}
 }
 
 // ServeMedia serves the podcast media files.
 func (srv *FeedServer) ServeMedia(w http.ResponseWriter, req *http.Request) {
 	fileName := path.Base(req.URL.Path)
-	filePath := path.Join(srv.svc.storagePath, fileName)
+	filePath := path.Join(srv.feeds.storagePath, fileName)
 
 	fi, err := os.Stat(filePath)
 	if err != nil {
@@ -168,7 +581,109 @@ func (srv *FeedServer) ServeMedia(w http.ResponseWriter, req *http.Request) {
 	http.ServeContent(w, req, fileName, fi.ModTime(), fd)
 }
 
-// HandleItem handles requests to add a new podcast item.
+// ServeChapters serves item chapters in Podcasting 2.0 JSON format at /chapters/<slug>/<item id>.json.
+// Chapters of the default feed items are also served at /chapters/<item id>.json.
+func (srv *FeedServer) ServeChapters(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/chapters/")
+	if !strings.HasSuffix(p, ".json") {
+		http.NotFound(w, req)
+		return
+	}
+
+	slug, itemID := parseItemPath(strings.TrimSuffix(p, ".json"))
+	if itemID == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	item, err := srv.feeds.Item(slug, itemID)
+	if err != nil {
+		if err != ErrItemNotFound {
+			log.Println("failed to fetch podcast item", itemID, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.NotFound(w, req)
+		return
+	}
+
+	if len(item.Chapters) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json+chapters")
+	if err := json.NewEncoder(w).Encode(newPodcastChapters(item.Chapters)); err != nil {
+		log.Println("failed to write chapters of", itemID, ":", err)
+	}
+}
+
+// ServeTranscript serves item transcripts at /transcripts/<slug>/<item id>.<ext>, where ext is one of vtt, srt or txt.
+// Transcripts of the default feed items are also served at /transcripts/<item id>.<ext>.
+func (srv *FeedServer) ServeTranscript(w http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/transcripts/")
+
+	ext := path.Ext(p)
+	contentType, ok := transcriptContentType(ext)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	slug, itemID := parseItemPath(strings.TrimSuffix(p, ext))
+	if itemID == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	item, err := srv.feeds.Item(slug, itemID)
+	if err != nil {
+		if err != ErrItemNotFound {
+			log.Println("failed to fetch podcast item", itemID, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.NotFound(w, req)
+		return
+	}
+
+	if item.TranscriptFileName == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	fileName := strings.TrimSuffix(item.TranscriptFileName, path.Ext(item.TranscriptFileName)) + ext
+	filePath := path.Join(srv.feeds.storagePath, fileName)
+
+	fd, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, req)
+			return
+		}
+
+		log.Printf("failed to read %s: %s", filePath, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+	defer fd.Close()
+
+	fi, err := fd.Stat()
+	if err != nil {
+		log.Printf("failed to stat %s: %s", filePath, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	http.ServeContent(w, req, fileName, fi.ModTime(), fd)
+}
+
+// HandleAddItem handles requests to add a new podcast item to the feed specified by the feed= parameter.
 func (srv *FeedServer) HandleAddItem(w http.ResponseWriter, req *http.Request) {
 	p, ok := srv.providers[strings.TrimPrefix(req.URL.Path, "/add")]
 	if !ok {
@@ -176,52 +691,121 @@ func (srv *FeedServer) HandleAddItem(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	svc, err := srv.feeds.Service(req.FormValue("feed"))
+	if err != nil {
+		if err == ErrFeedNotFound {
+			http.Error(w, "No such feed", http.StatusNotFound)
+			return
+		}
+
+		log.Println("failed to fetch feed", req.FormValue("feed"), ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	profile := req.FormValue("profile")
+	if err := ValidateTranscodingProfile(profile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	audio := p.HandleRequest(w, req)
 	if audio == nil {
 		return
 	}
 
+	audio = withTranscodingProfile(audio, profile)
+
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		defer cancel()
+		if list, ok := audio.(audioSourceList); ok {
+			items, err := svc.AddSourceList(context.Background(), list)
+			if err != nil {
+				log.Printf("failed to add %s items to the feed: %s", p.Name(), err)
+				return
+			}
 
-		meta, err := audio.Metadata(ctx)
-		if err != nil {
-			log.Printf("failed to fetch %s data: %s", p.Name(), err)
-			return
-		}
+			log.Printf("added %d %s item(s) to %s", len(items), p.Name(), svc.Feed())
 
-		u, err := audio.DownloadURL(ctx)
-		if err != nil {
-			log.Printf("failed to fetch download URL for %s: %s", p.Name(), err)
 			return
 		}
 
-		if err := srv.svc.AddItem(NewPodcastItem(meta, time.Now()), u); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if _, err := svc.AddSource(ctx, audio); err != nil {
 			log.Printf("failed to add %s item to the feed: %s", p.Name(), err)
 			return
 		}
 	}()
 }
 
-// HandleItem handles requests to update or remove a podcast item.
+// HandleItem serves feeds at /feed/<slug> and handles requests to update or remove a podcast item
+// sent to /feed/<slug>/<item id>. Items of the default feed can also be addressed as /feed/<item id>.
 func (srv *FeedServer) HandleItem(w http.ResponseWriter, req *http.Request) {
+	slug, itemID := parseItemPath(strings.TrimPrefix(req.URL.Path, "/feed/"))
+
 	switch {
+	case req.Method == http.MethodGet && itemID == "" && strings.HasSuffix(slug, ".json"):
+		srv.serveFeed(w, req, strings.TrimSuffix(slug, ".json"), JSONFeedRenderer{})
+	case req.Method == http.MethodGet && itemID == "":
+		srv.serveFeed(w, req, slug, negotiateFeedView(req))
 	case req.Method == http.MethodDelete:
 		fallthrough
 	case req.Method == http.MethodPost && strings.ToLower(req.FormValue("action")) == "delete":
-		srv.HandleRemoveItem(w, req)
+		srv.HandleRemoveItem(w, req, slug, itemID)
 	case req.Method == http.MethodPatch:
 		fallthrough
 	case req.Method == http.MethodPost && strings.ToLower(req.FormValue("action")) == "patch":
-		srv.HandleUpdateItem(w, req)
+		srv.HandleUpdateItem(w, req, slug, itemID)
+	case req.Method == http.MethodPost && strings.ToLower(req.FormValue("action")) == "retry":
+		srv.HandleRetryItem(w, req, slug, itemID)
+	case req.Method == http.MethodPost && strings.ToLower(req.FormValue("action")) == "cancel":
+		srv.HandleCancelItem(w, req, slug, itemID)
 	}
 }
 
+// HandleRetryItem handles requests to retry a failed or cancelled podcast item download.
+func (srv *FeedServer) HandleRetryItem(w http.ResponseWriter, req *http.Request, slug, itemID string) {
+	svc, err := srv.feeds.Service(slug)
+	if err != nil {
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.NotFound(w, req)
+		return
+	}
+
+	if err := svc.RetryItem(itemID); err != nil {
+		log.Println("failed to retry podcast item", itemID, ":", err)
+	}
+
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+}
+
+// HandleCancelItem handles requests to cancel a podcast item download.
+func (srv *FeedServer) HandleCancelItem(w http.ResponseWriter, req *http.Request, slug, itemID string) {
+	svc, err := srv.feeds.Service(slug)
+	if err != nil {
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.NotFound(w, req)
+		return
+	}
+
+	if err := svc.CancelItem(itemID); err != nil {
+		log.Println("failed to cancel podcast item", itemID, ":", err)
+	}
+
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+}
+
 // HandleRemoveItem handles requests to remove a podcast item.
-func (srv *FeedServer) HandleRemoveItem(w http.ResponseWriter, req *http.Request) {
-	itemID := req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
-	if err := srv.svc.RemoveItem(itemID); err != nil {
+func (srv *FeedServer) HandleRemoveItem(w http.ResponseWriter, req *http.Request, slug, itemID string) {
+	svc, err := srv.feeds.Service(slug)
+	if err != nil {
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.NotFound(w, req)
+		return
+	}
+
+	if err := svc.RemoveItem(itemID); err != nil {
 		log.Println("failed to remove podcast item", itemID, ":", err)
 	}
 
@@ -229,8 +813,13 @@ func (srv *FeedServer) HandleRemoveItem(w http.ResponseWriter, req *http.Request
 }
 
 // HandleUpdateItem handles requests to update a podcast item.
-func (srv *FeedServer) HandleUpdateItem(w http.ResponseWriter, req *http.Request) {
-	itemID := req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
+func (srv *FeedServer) HandleUpdateItem(w http.ResponseWriter, req *http.Request, slug, itemID string) {
+	svc, err := srv.feeds.Service(slug)
+	if err != nil {
+		log.Println("failed to fetch feed", slug, ":", err)
+		http.NotFound(w, req)
+		return
+	}
 
 	desc := Description{
 		Title: strings.TrimSpace(req.FormValue("title")),
@@ -242,13 +831,330 @@ func (srv *FeedServer) HandleUpdateItem(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	if err := srv.svc.UpdateItem(itemID, desc); err != nil {
+	if _, err := svc.UpdateItem(itemID, desc); err != nil {
 		log.Println("failed to update podcast item", itemID, ":", err)
 	}
 
 	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
 }
 
+// HandleFeed handles requests to create a new feed sent to /feeds and requests to update or
+// remove a feed sent to /feeds/<slug>.
+func (srv *FeedServer) HandleFeed(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet && req.URL.Path == "/feeds" {
+		srv.ServeOPML(w, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	feed := PodcastFeed{
+		Slug: strings.Trim(strings.TrimPrefix(req.URL.Path, "/feeds"), "/"),
+		PodcastMetadata: PodcastMetadata{
+			Title:       strings.TrimSpace(req.FormValue("title")),
+			Description: strings.TrimSpace(req.FormValue("description")),
+			IconURL:     strings.TrimSpace(req.FormValue("icon")),
+			Author:      strings.TrimSpace(req.FormValue("author")),
+			Owner:       strings.TrimSpace(req.FormValue("owner")),
+			Email:       strings.TrimSpace(req.FormValue("email")),
+			Language:    strings.TrimSpace(req.FormValue("language")),
+			Category:    strings.TrimSpace(req.FormValue("category")),
+			Explicit:    req.FormValue("explicit") != "",
+		},
+		TranscodingProfile: req.FormValue("profile"),
+		PostProcessing: PostProcessing{
+			Loudnorm:        req.FormValue("loudnorm") != "",
+			TrimSilence:     req.FormValue("trim_silence") != "",
+			CompressSilence: req.FormValue("compress_silence") != "",
+		},
+	}
+
+	if err := ValidateTranscodingProfile(feed.TranscodingProfile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch strings.ToLower(req.FormValue("action")) {
+	case "delete":
+		if err := srv.feeds.Remove(feed.Slug); err != nil {
+			log.Println("failed to remove feed", feed.Slug, ":", err)
+		}
+
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+		return
+	case "patch":
+		if feed.Title == "" {
+			http.Error(w, "Missing title", http.StatusBadRequest)
+			return
+		}
+
+		if err := srv.feeds.Update(feed); err != nil {
+			log.Println("failed to update feed", feed.Slug, ":", err)
+		}
+	default:
+		feed.Slug = strings.ToLower(strings.TrimSpace(req.FormValue("slug")))
+		if feed.Title == "" {
+			http.Error(w, "Missing title", http.StatusBadRequest)
+			return
+		}
+
+		switch err := srv.feeds.Create(feed); err {
+		case nil:
+		case ErrFeedExists, ErrInvalidFeedSlug:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			log.Println("failed to create feed", feed.Slug, ":", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, req, "/?feed="+url.QueryEscape(feed.Slug), http.StatusSeeOther)
+}
+
+// HandleToken handles requests to issue a new access token sent to /tokens and requests to revoke
+// a token sent to /tokens/<token>.
+func (srv *FeedServer) HandleToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token := strings.Trim(strings.TrimPrefix(req.URL.Path, "/tokens"), "/"); token != "" {
+		if strings.ToLower(req.FormValue("action")) != "delete" {
+			http.Error(w, "Unsupported action", http.StatusBadRequest)
+			return
+		}
+
+		if err := srv.auth.Tokens.Revoke(token); err != nil {
+			log.Println("failed to revoke access token:", err)
+		}
+
+		http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(req.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	kind, err := ParseTokenKind(req.FormValue("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := srv.auth.Tokens.Create(name, kind); err != nil {
+		log.Println("failed to create access token:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+}
+
+// HandleSubscription handles requests to subscribe a feed to a YouTube channel or playlist or to mirror
+// a podcast feed, to check a subscription for new videos and to unsubscribe.
+func (srv *FeedServer) HandleSubscription(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id := strings.Trim(strings.TrimPrefix(req.URL.Path, "/subscriptions"), "/"); id != "" {
+		var err error
+		switch strings.ToLower(req.FormValue("action")) {
+		case "delete":
+			err = srv.subs.Remove(id)
+		case "check":
+			err = srv.subs.Check(id)
+		default:
+			http.Error(w, "Unsupported action", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			log.Println("failed to update subscription", id, ":", err)
+		}
+
+		http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+		return
+	}
+
+	feed, err := srv.feeds.Get(req.FormValue("feed"))
+	if err != nil {
+		if err == ErrFeedNotFound {
+			http.Error(w, "No such feed", http.StatusNotFound)
+			return
+		}
+
+		log.Println("failed to fetch feed", req.FormValue("feed"), ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	kind, sourceID, err := ParseSubscriptionSource(req.FormValue("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := Subscription{
+		Feed:     feed.Slug,
+		Kind:     kind,
+		SourceID: sourceID,
+		SubscriptionFilter: SubscriptionFilter{
+			TitlePattern:  strings.TrimSpace(req.FormValue("title_pattern")),
+			ExcludeShorts: req.FormValue("exclude_shorts") != "",
+		},
+		TranscodingProfile: req.FormValue("profile"),
+	}
+
+	if s := strings.TrimSpace(req.FormValue("keep_latest")); s != "" {
+		if sub.KeepLatest, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "Malformed keep_latest", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s := strings.TrimSpace(req.FormValue("max_age")); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Malformed max_age", http.StatusBadRequest)
+			return
+		}
+
+		sub.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+
+	for name, d := range map[string]*time.Duration{"min_duration": &sub.MinDuration, "max_duration": &sub.MaxDuration} {
+		if s := strings.TrimSpace(req.FormValue(name)); s != "" {
+			mins, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "Malformed "+name, http.StatusBadRequest)
+				return
+			}
+
+			*d = time.Duration(mins) * time.Minute
+		}
+	}
+
+	if _, err := srv.subs.Create(sub); err != nil {
+		if errors.Is(err, ErrInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Println("failed to create subscription:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+}
+
+// chaptersPath returns the URL path of the item chapters.
+func chaptersPath(slug, itemID string) string {
+	if slug == DefaultFeed {
+		return "/chapters/" + itemID + ".json"
+	}
+
+	return "/chapters/" + slug + "/" + itemID + ".json"
+}
+
+// transcriptPath returns the URL path of the item transcript in the format with given extension.
+func transcriptPath(slug, itemID, ext string) string {
+	if slug == DefaultFeed {
+		return "/transcripts/" + itemID + ext
+	}
+
+	return "/transcripts/" + slug + "/" + itemID + ext
+}
+
+// parseItemPath splits the path of an item URL relative to /feed/ into a feed slug and an item ID.
+func parseItemPath(p string) (slug, itemID string) {
+	p = strings.Trim(p, "/")
+	if ind := strings.IndexByte(p, '/'); ind > -1 {
+		return p[:ind], p[ind+1:]
+	}
+
+	// item IDs are timestamps, and feed slugs can't contain colons
+	if strings.ContainsRune(p, ':') {
+		return DefaultFeed, p
+	}
+
+	return p, ""
+}
+
+// queryLimit returns the number of items requested with the limit= parameter, or 0 if it's missing or invalid.
+func queryLimit(req *http.Request) int {
+	n, err := strconv.Atoi(req.FormValue("limit"))
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// tokenQuery returns the query string passing the subscriber token the request has been made with. Podcast apps
+// request media files and feeds with the same token they used to fetch the feed or the feed list. Other tokens
+// are left out, so that they never end up in feed links.
+func (srv *FeedServer) tokenQuery(req *http.Request) string {
+	if token := req.URL.Query().Get("token"); srv.auth.tokenKind(token) == SubscriberToken {
+		return "?token=" + url.QueryEscape(token)
+	}
+
+	return ""
+}
+
+// preferredType returns the first of the offered content types that best matches the Accept header value. The first
+// offered type is returned if the header is empty or matches none of them.
+func preferredType(accept string, offers ...string) string {
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+
+	var ranges []mediaRange
+	for _, s := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > 0 {
+			ranges = append(ranges, mediaRange{typ, q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		for _, offer := range offers {
+			if r.typ == offer || r.typ == "*/*" || (strings.HasSuffix(r.typ, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.typ, "*"))) {
+				return offer
+			}
+		}
+	}
+
+	return offers[0]
+}
+
 // AssetHandler returns a http.HandlerFunc that serves the given asset with the
 // given content type.
 func AssetHandler(asset []byte, contentType string) http.HandlerFunc {
@@ -265,18 +1171,3 @@ func reqScheme(req *http.Request) string {
 
 	return "http"
 }
-
-func mimeTypeToEnclosureType(mime string) podcast.EnclosureType {
-	kv := strings.SplitN(mime, ";", 2)
-	switch kv[0] {
-	case "audio/mp4", "audio/mp4a.20.2", "audio/x-m4a":
-		return podcast.M4A
-	case "video/mp4", "video/x-m4v":
-		return podcast.M4V
-	case "audio/mpeg":
-		return podcast.MP3
-	default:
-		log.Printf("unknown MIME type %s, falling back to mp3", mime)
-		return podcast.MP3
-	}
-}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPreferredType(t *testing.T) {
+	offers := []string{"application/rss+xml", "application/feed+json", "application/json"}
+
+	for _, tc := range []struct {
+		Accept   string
+		Expected string
+	}{
+		{"", "application/rss+xml"},
+		{"*/*", "application/rss+xml"},
+		{"application/json", "application/json"},
+		{"application/feed+json", "application/feed+json"},
+		{"application/feed+json; charset=utf-8", "application/feed+json"},
+		{"text/html, application/json", "application/json"},
+		{"application/json;q=0.5, application/rss+xml;q=0.9", "application/rss+xml"},
+		{"application/rss+xml;q=0.5, application/feed+json", "application/feed+json"},
+		{"application/rss+xml;q=0, application/json", "application/json"},
+		{"application/*;q=0.8, text/html", "application/rss+xml"},
+		{"text/*", "application/rss+xml"},
+		{"text/html", "application/rss+xml"},
+		{"application/json;q=abc, application/feed+json;q=0.1", "application/feed+json"},
+		{"invalid;;, application/json", "application/json"},
+	} {
+		if actual := preferredType(tc.Accept, offers...); actual != tc.Expected {
+			t.Errorf("preferredType(%q) = %q, expected %q", tc.Accept, actual, tc.Expected)
+		}
+	}
+}
+
+func TestFeedServer_TokenQuery(t *testing.T) {
+	a := newTestAuthenticator(t, "secret")
+
+	subscriber, err := a.Tokens.Create("podcast app", SubscriberToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := a.Tokens.Create("script", APIToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &FeedServer{auth: a}
+
+	for _, tc := range []struct {
+		Target   string
+		Expected string
+	}{
+		{"/feed", ""},
+		{"/feed?token=" + subscriber.Token, "?token=" + subscriber.Token},
+		{"/feed?token=" + api.Token, ""},
+		{"/feed?token=unknown", ""},
+	} {
+		if actual := srv.tokenQuery(httptest.NewRequest(http.MethodGet, tc.Target, nil)); actual != tc.Expected {
+			t.Errorf("tokenQuery(%q) = %q, expected %q", tc.Target, actual, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// sqliteTimeFormat is a fixed-width UTC time format that keeps stored timestamps sortable
+// and understood by SQLite date and time functions.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS items (
+	feed                 TEXT    NOT NULL,
+	id                   TEXT    NOT NULL,
+	type                 INTEGER NOT NULL DEFAULT 0,
+	title                TEXT    NOT NULL DEFAULT '',
+	author               TEXT    NOT NULL DEFAULT '',
+	description          TEXT    NOT NULL DEFAULT '',
+	original_url         TEXT    NOT NULL DEFAULT '',
+	source_url           TEXT    NOT NULL DEFAULT '',
+	file_name            TEXT    NOT NULL DEFAULT '',
+	image_file_name      TEXT    NOT NULL DEFAULT '',
+	duration_ns          INTEGER NOT NULL DEFAULT 0,
+	start_offset_ns      INTEGER NOT NULL DEFAULT 0,
+	mime_type            TEXT    NOT NULL DEFAULT '',
+	content_length       INTEGER NOT NULL DEFAULT 0,
+	bit_rate             INTEGER NOT NULL DEFAULT 0,
+	codec                TEXT    NOT NULL DEFAULT '',
+	channels             INTEGER NOT NULL DEFAULT 0,
+	chapters             TEXT    NOT NULL DEFAULT '',
+	transcript_file_name TEXT    NOT NULL DEFAULT '',
+	transcript_language  TEXT    NOT NULL DEFAULT '',
+	transcoding_profile  TEXT    NOT NULL DEFAULT '',
+	loudnorm             INTEGER NOT NULL DEFAULT 0,
+	trim_silence         INTEGER NOT NULL DEFAULT 0,
+	compress_silence     INTEGER NOT NULL DEFAULT 0,
+	status               INTEGER NOT NULL DEFAULT 0,
+	error                TEXT    NOT NULL DEFAULT '',
+	PRIMARY KEY (feed, id)
+);
+
+CREATE INDEX IF NOT EXISTS items_original_url ON items (original_url);
+CREATE INDEX IF NOT EXISTS items_status ON items (feed, status);
+CREATE INDEX IF NOT EXISTS items_type ON items (feed, type);
+
+CREATE TABLE IF NOT EXISTS revisions (
+	feed        TEXT    NOT NULL PRIMARY KEY,
+	number      INTEGER NOT NULL,
+	modified_at TEXT    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	feed            TEXT    NOT NULL,
+	item_id         TEXT    NOT NULL,
+	status          INTEGER NOT NULL,
+	source_uri      TEXT    NOT NULL DEFAULT '',
+	target_uri      TEXT    NOT NULL DEFAULT '',
+	active          INTEGER NOT NULL DEFAULT 0,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT    NOT NULL DEFAULT '',
+	next_attempt_at TEXT    NOT NULL DEFAULT '',
+	refresh         INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (feed, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS jobs_status ON jobs (active, status);
+`
+
+// sqliteBackend stores items of all feeds and download jobs in an SQLite database.
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+// openSQLiteBackend opens the SQLite database file at path creating the schema if needed. The path may be
+// followed by driver parameters, i.e. "youcast.db?_pragma=synchronous(NORMAL)".
+func openSQLiteBackend(path string) (*sqliteBackend, error) {
+	if path == "" || strings.HasPrefix(path, "?") {
+		return nil, errors.New("sqlite storage requires a database path, i.e. sqlite:/var/lib/youcast/youcast.db")
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	// WAL allows readers to run concurrently with the writer, while immediate transactions
+	// serialize read-modify-write updates
+	db, err := sql.Open("sqlite", path+sep+"_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+	}
+
+	return &sqliteBackend{db}, nil
+}
+
+// Items returns the item store of the feed with given slug.
+func (b *sqliteBackend) Items(slug string) itemStore {
+	return &sqliteStorage{feed: slug, db: b.db}
+}
+
+// Jobs returns the download job store.
+func (b *sqliteBackend) Jobs() jobStore {
+	return &sqliteJobStore{db: b.db}
+}
+
+// Close closes the database.
+func (b *sqliteBackend) Close() error {
+	return b.db.Close()
+}
+
+// copyBoltData copies items of existing feeds and download jobs stored in the BoltDB database into the SQLite
+// database unless it already has any data, so that switching the storage backend keeps existing items. Feeds
+// are copied along with their revisions, which keeps the database non-empty once all items are removed. It
+// returns the number of copied items.
+func (b *sqliteBackend) copyBoltData(db *bolt.DB) (int, error) {
+	var empty bool
+	if err := b.db.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM items) AND NOT EXISTS (SELECT 1 FROM revisions)
+		AND NOT EXISTS (SELECT 1 FROM jobs)`).Scan(&empty); err != nil {
+		return 0, fmt.Errorf("failed to check whether the database is empty: %w", err)
+	}
+
+	if !empty {
+		return 0, nil
+	}
+
+	type feedItems struct {
+		slug  string
+		rev   Revision
+		items []PodcastItem
+	}
+
+	var feeds []feedItems
+
+	err := db.View(func(tx *bolt.Tx) error {
+		all, err := readFeeds(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, feed := range all {
+			st := newBoltStorage(feedBucket(feed.Slug), db)
+
+			items, err := st.items(tx)
+			if err != nil {
+				return err
+			}
+
+			if len(items) == 0 {
+				continue
+			}
+
+			rev, err := readRevision(tx, st.Bucket)
+			if err != nil {
+				return err
+			}
+
+			feeds = append(feeds, feedItems{feed.Slug, rev, items})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read items: %w", err)
+	}
+
+	jobs, err := newBoltJobStore(db).All()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read download jobs: %w", err)
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var n int
+	for _, f := range feeds {
+		st := &sqliteStorage{feed: f.slug, db: b.db}
+
+		for _, item := range f.items {
+			args, err := st.itemArgs(item)
+			if err != nil {
+				return 0, err
+			}
+
+			if _, err := tx.Exec(`INSERT INTO items (feed, `+sqliteItemColumns+`)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...); err != nil {
+				return 0, fmt.Errorf("failed to store podcast item into %s: %w", f.slug, err)
+			}
+		}
+
+		n += len(f.items)
+
+		if f.rev.Number == 0 {
+			f.rev = Revision{Number: 1, ModifiedAt: time.Now()}
+		}
+
+		if _, err := tx.Exec(`INSERT INTO revisions (feed, number, modified_at) VALUES (?, ?, ?)`,
+			f.slug, f.rev.Number, f.rev.ModifiedAt.UTC().Format(sqliteTimeFormat)); err != nil {
+			return 0, fmt.Errorf("failed to store revision of %s: %w", f.slug, err)
+		}
+	}
+
+	for _, job := range jobs {
+		var nextAttemptAt string
+		if !job.NextAttemptAt.IsZero() {
+			nextAttemptAt = job.NextAttemptAt.UTC().Format(sqliteTimeFormat)
+		}
+
+		if _, err := tx.Exec(`INSERT INTO jobs (`+sqliteJobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			job.ItemID, job.Feed, job.Status, job.SourceURI, job.TargetURI, job.Attempts, job.LastError, nextAttemptAt, job.Refresh); err != nil {
+			return 0, fmt.Errorf("failed to store job %s: %w", job.ItemID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return n, nil
+}
+
+const sqliteItemColumns = `id, type, title, author, description, original_url, source_url, file_name, image_file_name,
+	duration_ns, start_offset_ns, mime_type, content_length, bit_rate, codec, channels, chapters, transcript_file_name,
+	transcript_language, transcoding_profile, loudnorm, trim_silence, compress_silence, status, error`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSQLiteItem(row rowScanner) (PodcastItem, error) {
+	var (
+		item         PodcastItem
+		id, chapters string
+	)
+
+	err := row.Scan(
+		&id, &item.Type, &item.Title, &item.Author, &item.Body, &item.OriginalURL, &item.SourceURL, &item.FileName,
+		&item.ImageFileName, &item.Duration, &item.StartOffset, &item.MIMEType, &item.ContentLength, &item.BitRate,
+		&item.Codec, &item.Channels, &chapters, &item.TranscriptFileName, &item.TranscriptLanguage,
+		&item.TranscodingProfile, &item.PostProcessing.Loudnorm, &item.PostProcessing.TrimSilence,
+		&item.PostProcessing.CompressSilence, &item.Status, &item.Error,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return item, ErrItemNotFound
+		}
+
+		return item, fmt.Errorf("failed to read podcast item: %w", err)
+	}
+
+	if item.AddedAt, err = time.Parse(time.RFC3339Nano, id); err != nil {
+		return item, fmt.Errorf("failed to parse podcast item id %q: %w", id, err)
+	}
+
+	if chapters != "" {
+		if err := json.Unmarshal([]byte(chapters), &item.Chapters); err != nil {
+			return item, fmt.Errorf("failed to unmarshal chapters of %q: %w", id, err)
+		}
+	}
+
+	return item, nil
+}
+
+// sqliteStorage stores items of a single feed in the items table.
+type sqliteStorage struct {
+	feed string
+	db   *sql.DB
+}
+
+// Add inserts the item replacing the one with the same ID.
+func (s *sqliteStorage) Add(item PodcastItem) error {
+	args, err := s.itemArgs(item)
+	if err != nil {
+		return err
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO items (feed, `+sqliteItemColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to store podcast item into %s: %w", s.feed, err)
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteStorage) Remove(itemID string) (PodcastItem, error) {
+	var item PodcastItem
+
+	err := s.tx(func(tx *sql.Tx) error {
+		var err error
+		if item, err = scanSQLiteItem(tx.QueryRow(`SELECT `+sqliteItemColumns+` FROM items WHERE feed = ? AND id = ?`, s.feed, itemID)); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM items WHERE feed = ? AND id = ?`, s.feed, itemID); err != nil {
+			return fmt.Errorf("failed to remove podcast item: %w", err)
+		}
+
+		return nil
+	})
+
+	return item, err
+}
+
+func (s *sqliteStorage) UpdateDescription(itemID string, desc Description) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.Description = desc
+	})
+}
+
+func (s *sqliteStorage) UpdateStatus(itemID string, newStatus Status) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.Status = newStatus
+		if newStatus == ItemAdded || newStatus == ItemReady || newStatus == ItemCancelled {
+			it.Error = ""
+		}
+	})
+}
+
+// UpdateError stores the error message of the last failed attempt to process an item.
+func (s *sqliteStorage) UpdateError(itemID string, msg string) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.Error = msg
+	})
+}
+
+// UpdateImage sets the name of the file containing the item artwork.
+func (s *sqliteStorage) UpdateImage(itemID string, fileName string) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.ImageFileName = fileName
+	})
+}
+
+// UpdateMedia sets the name, the MIME type and the size of the item media file after it has been transcoded.
+func (s *sqliteStorage) UpdateMedia(itemID, fileName, mimeType string, contentLength int64) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.FileName, it.MIMEType, it.ContentLength = fileName, mimeType, contentLength
+	})
+}
+
+// UpdatePostProcessing records audio filters that have been applied to the item media.
+func (s *sqliteStorage) UpdatePostProcessing(itemID string, pp PostProcessing) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.PostProcessing = pp
+	})
+}
+
+// UpdateMediaInfo stores the actual duration, size and audio format of the item media file along with the embedded
+// chapters unless the item already has them.
+func (s *sqliteStorage) UpdateMediaInfo(itemID string, info MediaInfo) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		if info.Duration > 0 {
+			it.Duration = info.Duration
+		}
+
+		it.ContentLength, it.BitRate, it.Codec, it.Channels = info.Size, info.BitRate, info.Codec, info.Channels
+
+		if len(it.Chapters) == 0 {
+			it.Chapters = info.Chapters
+		}
+	})
+}
+
+// UpdateTranscript sets the name of the item transcript file and the transcript language if known.
+func (s *sqliteStorage) UpdateTranscript(itemID, fileName, lang string) (PodcastItem, error) {
+	return s.update(itemID, func(it *PodcastItem) {
+		it.TranscriptFileName, it.TranscriptLanguage = fileName, lang
+	})
+}
+
+func (s *sqliteStorage) Item(itemID string) (PodcastItem, error) {
+	return scanSQLiteItem(s.db.QueryRow(`SELECT `+sqliteItemColumns+` FROM items WHERE feed = ? AND id = ?`, s.feed, itemID))
+}
+
+func (s *sqliteStorage) Items() ([]PodcastItem, error) {
+	items, _, err := s.ItemsPage("", 0)
+
+	return items, err
+}
+
+// ItemsPage returns up to limit items added before the one with ID after, newest first, along with the cursor
+// to fetch the next page with.
+func (s *sqliteStorage) ItemsPage(after string, limit int) ([]PodcastItem, string, error) {
+	n := -1 // no limit
+	if limit > 0 {
+		n = limit + 1 // fetch one more item to find out whether there is a next page
+	}
+
+	items, err := s.query(`SELECT `+sqliteItemColumns+` FROM items WHERE feed = ? AND (? = '' OR id < ?)
+		ORDER BY id DESC LIMIT ?`, s.feed, after, after, n)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+		next = items[limit-1].ID()
+	}
+
+	return items, next, nil
+}
+
+// Find returns items matching the filter, newest first.
+func (s *sqliteStorage) Find(f ItemFilter) ([]PodcastItem, error) {
+	q, args := `SELECT `+sqliteItemColumns+` FROM items WHERE feed = ?`, []any{s.feed}
+
+	if f.OriginalURL != "" {
+		q, args = q+` AND original_url = ?`, append(args, f.OriginalURL)
+	}
+
+	if f.Status != 0 {
+		q, args = q+` AND status = ?`, append(args, f.Status)
+	}
+
+	if f.Type != 0 {
+		q, args = q+` AND type = ?`, append(args, f.Type)
+	}
+
+	return s.query(q+` ORDER BY id DESC`, args...)
+}
+
+// Revision returns the current revision of the feed.
+func (s *sqliteStorage) Revision() (Revision, error) {
+	var (
+		rev        Revision
+		modifiedAt string
+	)
+
+	err := s.db.QueryRow(`SELECT number, modified_at FROM revisions WHERE feed = ?`, s.feed).Scan(&rev.Number, &modifiedAt)
+	if err == sql.ErrNoRows {
+		return rev, nil
+	}
+
+	if err != nil {
+		return rev, fmt.Errorf("failed to read revision of %s: %w", s.feed, err)
+	}
+
+	if rev.ModifiedAt, err = time.Parse(sqliteTimeFormat, modifiedAt); err != nil {
+		return rev, fmt.Errorf("failed to parse revision of %s: %w", s.feed, err)
+	}
+
+	return rev, nil
+}
+
+// Touch bumps the feed revision.
+func (s *sqliteStorage) Touch() error {
+	return s.tx(func(*sql.Tx) error { return nil })
+}
+
+// Drop removes all items of the feed along with its revision.
+func (s *sqliteStorage) Drop() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM items WHERE feed = ?`, s.feed); err != nil {
+		return fmt.Errorf("failed to remove items of %s: %w", s.feed, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM revisions WHERE feed = ?`, s.feed); err != nil {
+		return fmt.Errorf("failed to remove revision of %s: %w", s.feed, err)
+	}
+
+	return tx.Commit()
+}
+
+// update applies fn to the stored item and saves the result.
+func (s *sqliteStorage) update(itemID string, fn func(*PodcastItem)) (PodcastItem, error) {
+	var item PodcastItem
+
+	err := s.tx(func(tx *sql.Tx) error {
+		var err error
+		if item, err = scanSQLiteItem(tx.QueryRow(`SELECT `+sqliteItemColumns+` FROM items WHERE feed = ? AND id = ?`, s.feed, itemID)); err != nil {
+			return err
+		}
+
+		fn(&item)
+
+		args, err := s.itemArgs(item)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`REPLACE INTO items (feed, `+sqliteItemColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...); err != nil {
+			return fmt.Errorf("failed to store podcast item: %w", err)
+		}
+
+		return nil
+	})
+
+	return item, err
+}
+
+// tx runs fn in a transaction and bumps the feed revision once it succeeds.
+func (s *sqliteStorage) tx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO revisions (feed, number, modified_at) VALUES (?, 1, ?)
+		ON CONFLICT (feed) DO UPDATE SET number = number + 1, modified_at = excluded.modified_at`,
+		s.feed, time.Now().UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return fmt.Errorf("failed to store revision of %s: %w", s.feed, err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) query(q string, args ...any) ([]PodcastItem, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query podcast items of %s: %w", s.feed, err)
+	}
+	defer rows.Close()
+
+	var items []PodcastItem
+	for rows.Next() {
+		item, err := scanSQLiteItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// itemArgs returns the values of item columns prefixed with the feed slug.
+func (s *sqliteStorage) itemArgs(item PodcastItem) ([]any, error) {
+	var chapters string
+	if len(item.Chapters) > 0 {
+		b, err := json.Marshal(item.Chapters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal chapters: %w", err)
+		}
+
+		chapters = string(b)
+	}
+
+	return []any{
+		s.feed, item.ID(), item.Type, item.Title, item.Author, item.Body, item.OriginalURL, item.SourceURL, item.FileName,
+		item.ImageFileName, item.Duration, item.StartOffset, item.MIMEType, item.ContentLength, item.BitRate,
+		item.Codec, item.Channels, chapters, item.TranscriptFileName, item.TranscriptLanguage,
+		item.TranscodingProfile, item.PostProcessing.Loudnorm, item.PostProcessing.TrimSilence,
+		item.PostProcessing.CompressSilence, item.Status, item.Error,
+	}, nil
+}
+
+const sqliteJobColumns = `item_id, feed, status, source_uri, target_uri, attempts, last_error, next_attempt_at, refresh`
+
+// sqliteJobStore keeps download jobs in the jobs table.
+type sqliteJobStore struct {
+	db *sql.DB
+}
+
+func scanSQLiteJob(row rowScanner) (DownloadJob, error) {
+	var (
+		job           DownloadJob
+		nextAttemptAt string
+	)
+
+	err := row.Scan(&job.ItemID, &job.Feed, &job.Status, &job.SourceURI, &job.TargetURI, &job.Attempts, &job.LastError,
+		&nextAttemptAt, &job.Refresh)
+	if err != nil {
+		return job, err
+	}
+
+	if nextAttemptAt != "" {
+		if job.NextAttemptAt, err = time.Parse(sqliteTimeFormat, nextAttemptAt); err != nil {
+			return job, fmt.Errorf("failed to parse next attempt time of %s: %w", job.ItemID, err)
+		}
+	}
+
+	return job, nil
+}
+
+// Put stores the job resetting its active status.
+func (s *sqliteJobStore) Put(job DownloadJob) error {
+	var nextAttemptAt string
+	if !job.NextAttemptAt.IsZero() {
+		nextAttemptAt = job.NextAttemptAt.UTC().Format(sqliteTimeFormat)
+	}
+
+	_, err := s.db.Exec(`REPLACE INTO jobs (`+sqliteJobColumns+`, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0)`,
+		job.ItemID, job.Feed, job.Status, job.SourceURI, job.TargetURI, job.Attempts, job.LastError, nextAttemptAt, job.Refresh)
+	if err != nil {
+		return fmt.Errorf("failed to store job %s: %w", job.ItemID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the job of an item.
+func (s *sqliteJobStore) Delete(feed, itemID string) error {
+	if _, err := s.db.Exec(`DELETE FROM jobs WHERE feed = ? AND item_id = ?`, feed, itemID); err != nil {
+		return fmt.Errorf("failed to remove job %s: %w", itemID, err)
+	}
+
+	return nil
+}
+
+// Claim marks the first matching inactive job as active and returns it.
+func (s *sqliteJobStore) Claim(now time.Time, statuses []DownloadStatus) (DownloadJob, error) {
+	q, args := `SELECT `+sqliteJobColumns+` FROM jobs WHERE active = 0 AND (next_attempt_at = '' OR next_attempt_at <= ?)`,
+		[]any{now.UTC().Format(sqliteTimeFormat)}
+
+	if len(statuses) > 0 {
+		q += ` AND status IN (?` + strings.Repeat(`, ?`, len(statuses)-1) + `)`
+		for _, st := range statuses {
+			args = append(args, st)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return DownloadJob{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	job, err := scanSQLiteJob(tx.QueryRow(q+` ORDER BY item_id, feed LIMIT 1`, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return job, ErrNoInactiveJobs
+		}
+
+		return job, fmt.Errorf("failed to fetch next job: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE jobs SET active = 1 WHERE feed = ? AND item_id = ?`, job.Feed, job.ItemID); err != nil {
+		return job, fmt.Errorf("failed to activate job %s: %w", job.ItemID, err)
+	}
+
+	return job, tx.Commit()
+}
+
+// Release removes the job of an item unless it is active.
+func (s *sqliteJobStore) Release(feed, itemID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var active bool
+	if err := tx.QueryRow(`SELECT active FROM jobs WHERE feed = ? AND item_id = ?`, feed, itemID).Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrJobNotFound
+		}
+
+		return false, fmt.Errorf("failed to fetch job %s: %w", itemID, err)
+	}
+
+	if active {
+		return true, nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM jobs WHERE feed = ? AND item_id = ?`, feed, itemID); err != nil {
+		return false, fmt.Errorf("failed to remove job %s: %w", itemID, err)
+	}
+
+	return false, tx.Commit()
+}
+
+// All returns all stored jobs.
+func (s *sqliteJobStore) All() ([]DownloadJob, error) {
+	rows, err := s.db.Query(`SELECT ` + sqliteJobColumns + ` FROM jobs ORDER BY item_id, feed`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []DownloadJob
+	for rows.Next() {
+		job, err := scanSQLiteJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
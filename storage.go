@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -21,6 +22,7 @@ const (
 	YouTubeItem PodcastItemType = iota + 1
 	TelegramItem
 	UploadedItem
+	ImportedItem
 )
 
 func (it PodcastItemType) String() string {
@@ -31,6 +33,8 @@ func (it PodcastItemType) String() string {
 		return "Telegram"
 	case UploadedItem:
 		return "Uploaded"
+	case ImportedItem:
+		return "Imported"
 	default:
 		return "Unknown"
 	}
@@ -42,6 +46,12 @@ type Description struct {
 	Body  string
 }
 
+// Chapter is a chapter of a podcast item.
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
 // Status is a status of a podcast item.
 type Status uint8
 
@@ -51,20 +61,55 @@ const (
 	ItemDownloaded
 	ItemReady
 	ItemDownloadFailed
+	ItemCancelled
 )
 
+// String returns a string representation of the status.
+func (st Status) String() string {
+	switch st {
+	case ItemAdded:
+		return "added"
+	case ItemDownloaded:
+		return "downloaded"
+	case ItemReady:
+		return "ready"
+	case ItemDownloadFailed:
+		return "failed"
+	case ItemCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
+
 // PodcastItem is a podcast item.
 type PodcastItem struct {
 	Description
 	Type          PodcastItemType
 	Author        string
 	OriginalURL   string
+	SourceURL     string
 	FileName      string
+	ImageFileName string
 	Duration      time.Duration
+	StartOffset   time.Duration
 	MIMEType      string
 	ContentLength int64
-	AddedAt       time.Time
-	Status        Status
+	BitRate       int64
+	Codec         string
+	Channels      int
+	Chapters      []Chapter
+	// TranscriptFileName is the name of the WebVTT transcript file, the same transcript is also stored
+	// in other supported formats next to it.
+	TranscriptFileName string
+	TranscriptLanguage string
+	// TranscodingProfile is the name of the profile used to transcode the item, the feed profile is used if empty.
+	TranscodingProfile string
+	// PostProcessing lists audio filters that have been applied while transcoding.
+	PostProcessing PostProcessing
+	AddedAt        time.Time
+	Status         Status
+	Error          string
 }
 
 // NewPodcastItem creates a new podcast item from the given metadata.
@@ -74,14 +119,17 @@ func NewPodcastItem(meta Metadata, addedAt time.Time) PodcastItem {
 			Title: meta.Title,
 			Body:  meta.Description,
 		},
-		Type:          meta.Type,
-		Author:        meta.Author,
-		OriginalURL:   meta.OriginalURL,
-		Duration:      meta.Duration,
-		MIMEType:      meta.MIMEType,
-		ContentLength: meta.ContentLength,
-		AddedAt:       addedAt,
-		Status:        ItemAdded,
+		Type:               meta.Type,
+		Author:             meta.Author,
+		OriginalURL:        meta.OriginalURL,
+		Duration:           meta.Duration,
+		StartOffset:        meta.StartOffset,
+		MIMEType:           meta.MIMEType,
+		ContentLength:      meta.ContentLength,
+		Chapters:           meta.Chapters,
+		TranscodingProfile: meta.TranscodingProfile,
+		AddedAt:            addedAt,
+		Status:             ItemAdded,
 	}
 }
 
@@ -100,36 +148,160 @@ func (item PodcastItem) Failed() bool {
 	return item.Status == ItemDownloadFailed
 }
 
+// Cancelled returns true if the podcast item download was cancelled.
+func (item PodcastItem) Cancelled() bool {
+	return item.Status == ItemCancelled
+}
+
+// MediaFormat returns a human-readable description of the item audio format, i.e. "aac, 64 kbps, mono".
+func (item PodcastItem) MediaFormat() string {
+	if item.Codec == "" {
+		return ""
+	}
+
+	parts := []string{item.Codec}
+	if item.BitRate > 0 {
+		parts = append(parts, strconv.FormatInt(item.BitRate/1000, 10)+" kbps")
+	}
+
+	switch item.Channels {
+	case 0:
+	case 1:
+		parts = append(parts, "mono")
+	case 2:
+		parts = append(parts, "stereo")
+	default:
+		parts = append(parts, strconv.Itoa(item.Channels)+" channels")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// InProgress returns true if the podcast item is being downloaded or processed.
+func (item PodcastItem) InProgress() bool {
+	return item.Status == ItemAdded || item.Status == ItemDownloaded
+}
+
 type boltPodcastItem struct {
 	Type          PodcastItemType `json:",omitempty"`
 	Title         string          `json:",omitempty"`
 	Author        string          `json:",omitempty"`
 	Description   string          `json:",omitempty"`
 	OriginalURL   string          `json:",omitempty"`
-	MediaURL      string          `json:",omitempty"` // obsolete
+	SourceURL     string          `json:",omitempty"`
+	MediaURL      string          `json:",omitempty"` // obsolete, see migrateItemMediaURLs
 	FileName      string          `json:",omitempty"`
+	ImageFileName string          `json:",omitempty"`
 	Duration      time.Duration   `json:",omitempty"`
+	StartOffset   time.Duration   `json:",omitempty"`
 	MIMEType      string          `json:",omitempty"`
 	ContentLength int64           `json:",omitempty"`
+	BitRate       int64           `json:",omitempty"`
+	Codec         string          `json:",omitempty"`
+	Channels      int             `json:",omitempty"`
+	Chapters      []Chapter       `json:",omitempty"`
+	Transcript    string          `json:",omitempty"`
+	Language      string          `json:",omitempty"`
+	Profile       string          `json:",omitempty"`
+	Loudnorm      bool            `json:",omitempty"`
+	TrimSilence   bool            `json:",omitempty"`
+	Compress      bool            `json:",omitempty"`
 	Status        Status          `json:",omitempty"`
+	Error         string          `json:",omitempty"`
 }
 
 func newBoltPodcastItem(item PodcastItem) boltPodcastItem {
 	return boltPodcastItem{
-		item.Type,
-		item.Title,
-		item.Author,
-		item.Body,
-		item.OriginalURL,
-		"",
-		item.FileName,
-		item.Duration,
-		item.MIMEType,
-		item.ContentLength,
-		item.Status,
+		Type:          item.Type,
+		Title:         item.Title,
+		Author:        item.Author,
+		Description:   item.Body,
+		OriginalURL:   item.OriginalURL,
+		SourceURL:     item.SourceURL,
+		FileName:      item.FileName,
+		ImageFileName: item.ImageFileName,
+		Duration:      item.Duration,
+		StartOffset:   item.StartOffset,
+		MIMEType:      item.MIMEType,
+		ContentLength: item.ContentLength,
+		BitRate:       item.BitRate,
+		Codec:         item.Codec,
+		Channels:      item.Channels,
+		Chapters:      item.Chapters,
+		Transcript:    item.TranscriptFileName,
+		Language:      item.TranscriptLanguage,
+		Profile:       item.TranscodingProfile,
+		Loudnorm:      item.PostProcessing.Loudnorm,
+		TrimSilence:   item.PostProcessing.TrimSilence,
+		Compress:      item.PostProcessing.CompressSilence,
+		Status:        item.Status,
+		Error:         item.Error,
+	}
+}
+
+// PodcastItem converts the stored item into a PodcastItem added at given time.
+func (it boltPodcastItem) PodcastItem(addedAt time.Time) PodcastItem {
+	return PodcastItem{
+		Description:        Description{it.Title, it.Description},
+		Type:               it.Type,
+		Author:             it.Author,
+		OriginalURL:        it.OriginalURL,
+		SourceURL:          it.SourceURL,
+		FileName:           it.FileName,
+		ImageFileName:      it.ImageFileName,
+		Duration:           it.Duration,
+		StartOffset:        it.StartOffset,
+		MIMEType:           it.MIMEType,
+		ContentLength:      it.ContentLength,
+		BitRate:            it.BitRate,
+		Codec:              it.Codec,
+		Channels:           it.Channels,
+		Chapters:           it.Chapters,
+		TranscriptFileName: it.Transcript,
+		TranscriptLanguage: it.Language,
+		TranscodingProfile: it.Profile,
+		PostProcessing: PostProcessing{
+			Loudnorm:        it.Loudnorm,
+			TrimSilence:     it.TrimSilence,
+			CompressSilence: it.Compress,
+		},
+		AddedAt: addedAt,
+		Status:  it.Status,
+		Error:   it.Error,
 	}
 }
 
+// ItemFilter selects podcast items by their original URL, status and type. Empty fields match any value.
+type ItemFilter struct {
+	OriginalURL string
+	Status      Status
+	Type        PodcastItemType
+}
+
+// Match returns true if the item matches the filter.
+func (f ItemFilter) Match(item PodcastItem) bool {
+	return (f.OriginalURL == "" || item.OriginalURL == f.OriginalURL) &&
+		(f.Status == 0 || item.Status == f.Status) &&
+		(f.Type == 0 || item.Type == f.Type)
+}
+
+// itemStore stores podcast items of a single feed.
+type itemStore interface {
+	storage
+	UpdateError(itemID, msg string) (PodcastItem, error)
+	UpdateImage(itemID, fileName string) (PodcastItem, error)
+	UpdateMedia(itemID, fileName, mimeType string, contentLength int64) (PodcastItem, error)
+	UpdatePostProcessing(itemID string, pp PostProcessing) (PodcastItem, error)
+	UpdateMediaInfo(itemID string, info MediaInfo) (PodcastItem, error)
+	UpdateTranscript(itemID, fileName, lang string) (PodcastItem, error)
+	// Revision returns the current revision of the feed.
+	Revision() (Revision, error)
+	// Touch bumps the feed revision without modifying items, i.e. when the feed metadata is changed.
+	Touch() error
+	// Drop removes all items along with the feed revision.
+	Drop() error
+}
+
 type boltStorage struct {
 	Bucket []byte
 	db     *bolt.DB
@@ -156,7 +328,7 @@ func (s *boltStorage) Add(item PodcastItem) error {
 			return fmt.Errorf("failed to store podcast item into %q: %w", s.Bucket, err)
 		}
 
-		return nil
+		return bumpRevision(tx, s.Bucket)
 	})
 }
 
@@ -175,43 +347,92 @@ func (s *boltStorage) Remove(itemID string) (PodcastItem, error) {
 			return ErrItemNotFound
 		}
 
-		addedAt, err := time.Parse(time.RFC3339Nano, string(k))
+		it, err := s.decode(k, v)
 		if err != nil {
-			return fmt.Errorf("failed to parse podcast item key %q in %q: %w", k, s.Bucket, err)
+			return err
 		}
 
 		if err := b.Delete(k); err != nil {
 			return fmt.Errorf("failed to remove podcast item: %w", err)
 		}
 
-		var it boltPodcastItem
-		if err := json.Unmarshal(v, &it); err != nil {
-			return fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, s.Bucket, err)
+		item = it
+
+		return bumpRevision(tx, s.Bucket)
+	})
+}
+
+func (s *boltStorage) UpdateDescription(itemID string, desc Description) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.Title, it.Description = desc.Title, desc.Body
+	})
+}
+
+func (s *boltStorage) UpdateStatus(itemID string, newStatus Status) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.Status = newStatus
+		if newStatus == ItemAdded || newStatus == ItemReady || newStatus == ItemCancelled {
+			it.Error = ""
 		}
+	})
+}
+
+// UpdateError stores the error message of the last failed attempt to process an item.
+func (s *boltStorage) UpdateError(itemID string, msg string) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.Error = msg
+	})
+}
+
+// UpdateImage sets the name of the file containing the item artwork.
+func (s *boltStorage) UpdateImage(itemID string, fileName string) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.ImageFileName = fileName
+	})
+}
+
+// UpdateMedia sets the name, the MIME type and the size of the item media file after it has been transcoded.
+func (s *boltStorage) UpdateMedia(itemID, fileName, mimeType string, contentLength int64) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.FileName, it.MIMEType, it.ContentLength = fileName, mimeType, contentLength
+	})
+}
+
+// UpdatePostProcessing records audio filters that have been applied to the item media.
+func (s *boltStorage) UpdatePostProcessing(itemID string, pp PostProcessing) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.Loudnorm, it.TrimSilence, it.Compress = pp.Loudnorm, pp.TrimSilence, pp.CompressSilence
+	})
+}
 
-		migrateMediaURL(&it)
-
-		item = PodcastItem{
-			Description{it.Title, it.Description},
-			it.Type,
-			it.Author,
-			it.OriginalURL,
-			it.FileName,
-			it.Duration,
-			it.MIMEType,
-			it.ContentLength,
-			addedAt,
-			it.Status,
+// UpdateMediaInfo stores the actual duration, size and audio format of the item media file along with the embedded
+// chapters unless the item already has them.
+func (s *boltStorage) UpdateMediaInfo(itemID string, info MediaInfo) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		if info.Duration > 0 {
+			it.Duration = info.Duration
 		}
 
-		return nil
+		it.ContentLength, it.BitRate, it.Codec, it.Channels = info.Size, info.BitRate, info.Codec, info.Channels
+
+		// chapters embedded into uploaded files are only known once the file has been processed
+		if len(it.Chapters) == 0 {
+			it.Chapters = info.Chapters
+		}
 	})
 }
 
-func (s *boltStorage) UpdateDescription(itemID string, desc Description) (PodcastItem, error) {
+// UpdateTranscript sets the name of the item transcript file and the transcript language if known.
+func (s *boltStorage) UpdateTranscript(itemID, fileName, lang string) (PodcastItem, error) {
+	return s.update(itemID, func(it *boltPodcastItem) {
+		it.Transcript, it.Language = fileName, lang
+	})
+}
+
+func (s *boltStorage) Item(itemID string) (PodcastItem, error) {
 	var item PodcastItem
 
-	return item, s.db.Update(func(tx *bolt.Tx) error {
+	return item, s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(s.Bucket)
 		if b == nil {
 			return ErrItemNotFound
@@ -223,46 +444,128 @@ func (s *boltStorage) UpdateDescription(itemID string, desc Description) (Podcas
 			return ErrItemNotFound
 		}
 
-		addedAt, err := time.Parse(time.RFC3339Nano, string(k))
+		it, err := s.decode(k, v)
 		if err != nil {
-			return fmt.Errorf("failed to parse podcast item key %q in %q: %w", k, s.Bucket, err)
+			return err
 		}
 
-		var it boltPodcastItem
-		if err := json.Unmarshal(v, &it); err != nil {
-			return fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, s.Bucket, err)
-		}
+		item = it
 
-		it.Title, it.Description = desc.Title, desc.Body
-		migrateMediaURL(&it)
+		return nil
+	})
+}
 
-		v, err = json.Marshal(it)
+func (s *boltStorage) Items() ([]PodcastItem, error) {
+	var items []PodcastItem
+	return items, s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		items, err = s.items(tx)
+
+		return err
+	})
+}
+
+// items returns all items of the feed within a transaction, newest first.
+func (s *boltStorage) items(tx *bolt.Tx) ([]PodcastItem, error) {
+	b := tx.Bucket(s.Bucket)
+	if b == nil {
+		return nil, nil
+	}
+
+	var items []PodcastItem
+
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		item, err := s.decode(k, v)
 		if err != nil {
-			return fmt.Errorf("failed to marshal podcast item %q in %q: %w", k, s.Bucket, err)
+			return nil, err
 		}
 
-		if err := b.Put(k, v); err != nil {
-			return fmt.Errorf("failed to store podcast item: %w", err)
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// ItemsPage returns up to limit items added before the one with ID after, newest first. The whole list
+// is paged through starting with an empty cursor and passing the returned one to fetch the next page.
+// The returned cursor is empty for the last page.
+func (s *boltStorage) ItemsPage(after string, limit int) ([]PodcastItem, string, error) {
+	var (
+		items []PodcastItem
+		next  string
+	)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.Bucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		k, v := c.Last()
+		if after != "" {
+			// position the cursor at the first key that is less than the cursor value
+			if k, v = c.Seek([]byte(after)); k == nil {
+				k, v = c.Last()
+			}
+
+			for k != nil && string(k) >= after {
+				k, v = c.Prev()
+			}
 		}
 
-		item = PodcastItem{
-			Description{it.Title, it.Description},
-			it.Type,
-			it.Author,
-			it.OriginalURL,
-			it.FileName,
-			it.Duration,
-			it.MIMEType,
-			it.ContentLength,
-			addedAt,
-			it.Status,
+		for ; k != nil; k, v = c.Prev() {
+			if limit > 0 && len(items) == limit {
+				next = items[len(items)-1].ID()
+				break
+			}
+
+			item, err := s.decode(k, v)
+			if err != nil {
+				return err
+			}
+
+			items = append(items, item)
 		}
 
 		return nil
 	})
+
+	return items, next, err
 }
 
-func (s *boltStorage) UpdateStatus(itemID string, newStatus Status) (PodcastItem, error) {
+// Find returns items matching the filter, newest first.
+func (s *boltStorage) Find(f ItemFilter) ([]PodcastItem, error) {
+	var items []PodcastItem
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.Bucket)
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			item, err := s.decode(k, v)
+			if err != nil {
+				return err
+			}
+
+			if f.Match(item) {
+				items = append(items, item)
+			}
+		}
+
+		return nil
+	})
+
+	return items, err
+}
+
+// update applies fn to the stored item and saves the result.
+func (s *boltStorage) update(itemID string, fn func(*boltPodcastItem)) (PodcastItem, error) {
 	var item PodcastItem
 
 	return item, s.db.Update(func(tx *bolt.Tx) error {
@@ -287,81 +590,127 @@ func (s *boltStorage) UpdateStatus(itemID string, newStatus Status) (PodcastItem
 			return fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, s.Bucket, err)
 		}
 
-		it.Status = newStatus
-		migrateMediaURL(&it)
+		fn(&it)
 
 		v, err = json.Marshal(it)
 		if err != nil {
-			return fmt.Errorf("failed to marsha podcast item %q in %q: %w", k, s.Bucket, err)
+			return fmt.Errorf("failed to marshal podcast item %q in %q: %w", k, s.Bucket, err)
 		}
 
 		if err := b.Put(k, v); err != nil {
 			return fmt.Errorf("failed to store podcast item: %w", err)
 		}
 
-		item = PodcastItem{
-			Description{it.Title, it.Description},
-			it.Type,
-			it.Author,
-			it.OriginalURL,
-			it.FileName,
-			it.Duration,
-			it.MIMEType,
-			it.ContentLength,
-			addedAt,
-			it.Status,
-		}
+		item = it.PodcastItem(addedAt)
 
-		return nil
+		return bumpRevision(tx, s.Bucket)
 	})
 }
 
-func (s *boltStorage) Items() ([]PodcastItem, error) {
-	var items []PodcastItem
-	return items, s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(s.Bucket)
-		if b == nil {
-			return nil
-		}
+// Revision returns the current revision of the stored items.
+func (s *boltStorage) Revision() (Revision, error) {
+	var rev Revision
 
-		c := b.Cursor()
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			addedAt, err := time.Parse(time.RFC3339Nano, string(k))
-			if err != nil {
-				return fmt.Errorf("failed to parse podcast item key %q in %q: %w", k, s.Bucket, err)
-			}
+	return rev, s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rev, err = readRevision(tx, s.Bucket)
 
-			var it boltPodcastItem
-			if err := json.Unmarshal(v, &it); err != nil {
-				return fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, s.Bucket, err)
-			}
+		return err
+	})
+}
 
-			if it.Status == 0 { // legacy items, assume they are ready
-				it.Status = ItemReady
-			}
+// Touch bumps the revision of the stored items.
+func (s *boltStorage) Touch() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return bumpRevision(tx, s.Bucket)
+	})
+}
+
+// Drop deletes the bucket along with the revision of the stored items.
+func (s *boltStorage) Drop() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(s.Bucket); err != nil && err != bolt.ErrBucketNotFound {
+			return fmt.Errorf("failed to remove %q: %w", s.Bucket, err)
+		}
 
-			migrateMediaURL(&it)
-
-			items = append(items, PodcastItem{
-				Description{it.Title, it.Description},
-				it.Type,
-				it.Author,
-				it.OriginalURL,
-				it.FileName,
-				it.Duration,
-				it.MIMEType,
-				it.ContentLength,
-				addedAt,
-				it.Status,
-			})
+		if b := tx.Bucket(revisionsBucket); b != nil {
+			if err := b.Delete(s.Bucket); err != nil {
+				return fmt.Errorf("failed to remove revision of %q: %w", s.Bucket, err)
+			}
 		}
 
 		return nil
 	})
 }
 
-func migrateMediaURL(it *boltPodcastItem) {
-	if it.FileName == "" {
-		it.FileName = path.Base(it.MediaURL)
+// decode unmarshals a stored item, legacy records are upgraded by schema migrations beforehand.
+func (s *boltStorage) decode(k, v []byte) (PodcastItem, error) {
+	addedAt, err := time.Parse(time.RFC3339Nano, string(k))
+	if err != nil {
+		return PodcastItem{}, fmt.Errorf("failed to parse podcast item key %q in %q: %w", k, s.Bucket, err)
+	}
+
+	var it boltPodcastItem
+	if err := json.Unmarshal(v, &it); err != nil {
+		return PodcastItem{}, fmt.Errorf("failed to unmarshal podcast item %q in %q: %w", k, s.Bucket, err)
+	}
+
+	return it.PodcastItem(addedAt), nil
+}
+
+// Revision identifies the state of a feed. It is incremented each time the feed or its items are modified.
+type Revision struct {
+	Number     uint64    `json:",omitempty"`
+	ModifiedAt time.Time `json:",omitzero"`
+}
+
+var revisionsBucket = []byte("revisions")
+
+// readRevision returns the revision of an item bucket. Buckets that have never been modified since revisions
+// were introduced have zero revision.
+func readRevision(tx *bolt.Tx, bucket []byte) (Revision, error) {
+	var rev Revision
+
+	b := tx.Bucket(revisionsBucket)
+	if b == nil {
+		return rev, nil
+	}
+
+	v := b.Get(bucket)
+	if v == nil {
+		return rev, nil
+	}
+
+	if err := json.Unmarshal(v, &rev); err != nil {
+		return rev, fmt.Errorf("failed to unmarshal revision of %q: %w", bucket, err)
+	}
+
+	return rev, nil
+}
+
+// bumpRevision increments the revision of an item bucket within the transaction.
+func bumpRevision(tx *bolt.Tx, bucket []byte) error {
+	rev, err := readRevision(tx, bucket)
+	if err != nil {
+		return err
+	}
+
+	rev.Number++
+	rev.ModifiedAt = time.Now().UTC()
+
+	v, err := json.Marshal(rev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revision of %q: %w", bucket, err)
+	}
+
+	b, err := tx.CreateBucketIfNotExists(revisionsBucket)
+	if err != nil {
+		return fmt.Errorf("failed to open revisions bucket: %w", err)
+	}
+
+	if err := b.Put(bucket, v); err != nil {
+		return fmt.Errorf("failed to store revision of %q: %w", bucket, err)
 	}
+
+	return nil
 }
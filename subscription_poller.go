@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultSubscriptionInterval is the default interval between subscription checks.
+	DefaultSubscriptionInterval = time.Hour
+
+	// maxShortDuration is the maximum duration of a YouTube Short.
+	maxShortDuration = 3 * time.Minute
+)
+
+type playlistSource interface {
+	PlaylistEntries(ctx context.Context, playlistID string) (string, []YouTubePlaylistEntry, error)
+	IsShort(ctx context.Context, videoID string) (bool, error)
+}
+
+// SubscriptionPoller periodically checks subscribed channels and playlists and adds new videos to the feeds. Mirrored
+// podcast feeds are checked for new episodes the same way.
+type SubscriptionPoller struct {
+	subs     *SubscriptionStore
+	feeds    *FeedRegistry
+	yt       playlistSource
+	interval time.Duration
+}
+
+// NewSubscriptionPoller returns a new instance of SubscriptionPoller that checks each subscription every interval.
+func NewSubscriptionPoller(subs *SubscriptionStore, feeds *FeedRegistry, yt playlistSource, interval time.Duration) *SubscriptionPoller {
+	if interval <= 0 {
+		interval = DefaultSubscriptionInterval
+	}
+
+	return &SubscriptionPoller{
+		subs:     subs,
+		feeds:    feeds,
+		yt:       yt,
+		interval: interval,
+	}
+}
+
+// Run checks subscriptions that are due until the context is cancelled. New subscriptions and the ones
+// requested to be checked via SubscriptionStore.Check are picked up immediately.
+func (p *SubscriptionPoller) Run(ctx context.Context) {
+	log.Printf("starting subscription poller with %s interval", p.interval)
+	defer log.Print("subscription poller stopped")
+
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		p.checkDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.subs.Notify():
+		case <-t.C:
+		}
+	}
+}
+
+func (p *SubscriptionPoller) checkDue(ctx context.Context) {
+	subs, err := p.subs.All("")
+	if err != nil {
+		log.Printf("failed to fetch subscriptions: %s", err)
+		return
+	}
+
+	for _, sub := range subs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Now().Before(sub.NextCheckAt) {
+			continue
+		}
+
+		due := sub.NextCheckAt
+
+		if err := p.check(ctx, &sub); err != nil {
+			if err == ErrFeedNotFound {
+				log.Printf("feed %s of subscription %s was removed, unsubscribing", sub.Feed, sub.ID)
+
+				if err := p.subs.Remove(sub.ID); err != nil {
+					log.Printf("failed to remove subscription %s: %s", sub.ID, err)
+				}
+
+				continue
+			}
+
+			log.Printf("failed to check subscription to %s: %s", sub.Name(), err)
+			sub.LastError = err.Error()
+		}
+
+		sub.NextCheckAt = time.Now().Add(p.interval)
+
+		if err := p.subs.Update(sub, due); err != nil && err != ErrSubscriptionNotFound {
+			log.Printf("failed to update subscription %s: %s", sub.ID, err)
+		}
+	}
+}
+
+// check adds videos that have not been seen yet to the feed. The first check only remembers the videos that
+// are already present in the channel or playlist, so that only new uploads are added. Videos that failed to be
+// added are retried during the next check.
+func (p *SubscriptionPoller) check(ctx context.Context, sub *Subscription) error {
+	svc, err := p.feeds.Service(sub.Feed)
+	if err != nil {
+		return err
+	}
+
+	if sub.Kind == MirrorSubscription {
+		return p.checkMirror(ctx, sub, svc)
+	}
+
+	title, entries, err := p.yt.PlaylistEntries(ctx, sub.PlaylistID())
+	if err != nil {
+		return err
+	}
+
+	if sub.Kind == ChannelSubscription { // uploads are listed starting from the newest one
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	seen := make(map[string]struct{}, len(sub.Seen))
+	for _, id := range sub.Seen {
+		seen[id] = struct{}{}
+	}
+
+	var (
+		baseline = sub.CheckedAt.IsZero()
+		added    int
+		lastErr  error
+		newSeen  = make([]string, 0, len(entries))
+	)
+	for _, e := range entries {
+		if _, ok := seen[e.VideoID]; ok || baseline {
+			newSeen = append(newSeen, e.VideoID)
+			continue
+		}
+
+		ok, err := p.match(ctx, sub.SubscriptionFilter, e)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if ok {
+			if _, err := svc.AddSource(ctx, withTranscodingProfile(NewYouTubeVideo(e.VideoID, 0), sub.TranscodingProfile)); err != nil {
+				lastErr = fmt.Errorf("failed to add %s: %w", e.OriginalURL(), err)
+				continue
+			}
+
+			added++
+		}
+
+		newSeen = append(newSeen, e.VideoID)
+	}
+
+	log.Printf("checked subscription to %s: %d new item(s) added to %s", title, added, sub.Feed)
+
+	// videos that are no longer listed are forgotten to keep the list short
+	sub.Title, sub.Seen, sub.LastError = title, newSeen, ""
+	sub.CheckedAt = time.Now()
+
+	return lastErr
+}
+
+// checkMirror adds episodes of the mirrored feed that have not been seen yet to the feed and removes the ones that
+// are no longer retained. Unlike channels and playlists, mirrors add episodes that are already published during
+// the first check, limited by the retention rules. Episodes that failed to be added are retried during the next check.
+func (p *SubscriptionPoller) checkMirror(ctx context.Context, sub *Subscription, svc *FeedService) error {
+	data, err := fetchFeedDocument(ctx, sub.SourceID)
+	if err != nil {
+		return err
+	}
+
+	feed, err := parseFeedDocument(data)
+	if err != nil {
+		return err
+	}
+
+	if len(feed.Feeds) > 0 {
+		return errors.New("OPML documents cannot be mirrored, subscribe to each listed feed instead")
+	}
+
+	episodes := feed.Episodes
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].PublishedAt.Before(episodes[j].PublishedAt)
+	})
+
+	seen := make(map[string]struct{}, len(sub.Seen))
+	for _, u := range sub.Seen {
+		seen[u] = struct{}{}
+	}
+
+	var (
+		now     = time.Now()
+		pending []PodcastEpisode
+		newSeen = make([]string, 0, len(episodes))
+	)
+	for _, ep := range episodes {
+		if _, ok := seen[ep.URL]; ok || !sub.Match(ep.Title, ep.Duration) || sub.Expired(ep.PublishedAt, now) {
+			newSeen = append(newSeen, ep.URL)
+			continue
+		}
+
+		pending = append(pending, ep)
+	}
+
+	// episodes that would be removed right away are not downloaded
+	if n := sub.KeepLatest; n > 0 && len(pending) > n {
+		for _, ep := range pending[:len(pending)-n] {
+			newSeen = append(newSeen, ep.URL)
+		}
+
+		pending = pending[len(pending)-n:]
+	}
+
+	var (
+		added   int
+		lastErr error
+	)
+	for _, ep := range pending {
+		item, err := svc.AddSource(ctx, withTranscodingProfile(ep, sub.TranscodingProfile))
+		if err != nil {
+			lastErr = fmt.Errorf("failed to add %s: %w", ep.URL, err)
+			continue
+		}
+
+		sub.Mirrored = append(sub.Mirrored, item.ID())
+		newSeen = append(newSeen, ep.URL)
+		added++
+	}
+
+	removed, err := p.applyRetention(svc, sub, now)
+	if err != nil {
+		lastErr = err
+	}
+
+	log.Printf("checked mirror of %s: %d new item(s) added to %s, %d removed", feed.Title, added, sub.Feed, removed)
+
+	// episodes that are no longer listed are forgotten to keep the list short
+	sub.Title, sub.Seen, sub.LastError = feed.Title, newSeen, ""
+	sub.CheckedAt = now
+
+	return lastErr
+}
+
+// applyRetention removes the items added by the mirror that are older than the retention rules allow. Items that
+// have been removed from the feed otherwise are forgotten. It returns the number of removed items.
+func (p *SubscriptionPoller) applyRetention(svc *FeedService, sub *Subscription, now time.Time) (int, error) {
+	items := make([]PodcastItem, 0, len(sub.Mirrored))
+	for _, id := range sub.Mirrored {
+		item, err := svc.Item(id)
+		if err == ErrItemNotFound {
+			continue
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch mirrored item %s: %w", id, err)
+		}
+
+		items = append(items, item)
+	}
+
+	// items are added at the time episodes have been published
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].AddedAt.Before(items[j].AddedAt)
+	})
+
+	var (
+		removed int
+		lastErr error
+		kept    = make([]string, 0, len(items))
+	)
+	for i, item := range items {
+		if (sub.KeepLatest == 0 || len(items)-i <= sub.KeepLatest) && !sub.Expired(item.AddedAt, now) {
+			kept = append(kept, item.ID())
+			continue
+		}
+
+		if err := svc.RemoveItem(item.ID()); err != nil && err != ErrItemNotFound {
+			lastErr = fmt.Errorf("failed to remove %s: %w", item.ID(), err)
+			kept = append(kept, item.ID())
+
+			continue
+		}
+
+		removed++
+	}
+
+	sub.Mirrored = kept
+
+	return removed, lastErr
+}
+
+// match returns true if the video passes the subscription filter.
+func (p *SubscriptionPoller) match(ctx context.Context, f SubscriptionFilter, e YouTubePlaylistEntry) (bool, error) {
+	if !f.Match(e.Title, e.Duration) {
+		return false, nil
+	}
+
+	if !f.ExcludeShorts || e.Duration > maxShortDuration {
+		return true, nil
+	}
+
+	short, err := p.yt.IsShort(ctx, e.VideoID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether %s is a short: %w", e.OriginalURL(), err)
+	}
+
+	return !short, nil
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrSubscriptionNotFound is returned when a subscription is not found in the storage.
+	ErrSubscriptionNotFound = errors.New("no such subscription")
+	// ErrUnsupportedSubscription is returned when a subscription source is neither a YouTube channel or playlist,
+	// nor a podcast feed.
+	ErrUnsupportedSubscription = errors.New("subscription source must be a YouTube channel, playlist or podcast feed URL")
+)
+
+var (
+	youTubeChannelIDRe  = regexp.MustCompile(`^UC[A-Za-z0-9_-]{22}$`)
+	youTubePlaylistIDRe = regexp.MustCompile(`^(PL|UU|OL|FL|LL)[A-Za-z0-9_-]{10,}$`)
+)
+
+// SubscriptionKind is a kind of a subscription source.
+type SubscriptionKind uint8
+
+// Supported subscription kinds.
+const (
+	ChannelSubscription SubscriptionKind = iota + 1
+	PlaylistSubscription
+	// MirrorSubscription re-hosts episodes of an external RSS or Atom podcast feed.
+	MirrorSubscription
+)
+
+// String returns a string representation of the subscription kind.
+func (k SubscriptionKind) String() string {
+	switch k {
+	case ChannelSubscription:
+		return "channel"
+	case PlaylistSubscription:
+		return "playlist"
+	case MirrorSubscription:
+		return "mirror"
+	default:
+		return "unknown"
+	}
+}
+
+// SubscriptionFilter defines which videos are added to the feed.
+type SubscriptionFilter struct {
+	// TitlePattern is a regular expression the video title must match.
+	TitlePattern string
+	// MinDuration and MaxDuration limit the video duration if set.
+	MinDuration, MaxDuration time.Duration
+	// ExcludeShorts skips YouTube Shorts.
+	ExcludeShorts bool
+}
+
+// Validate returns an error if the filter is malformed.
+func (f SubscriptionFilter) Validate() error {
+	if _, err := regexp.Compile(f.TitlePattern); err != nil {
+		return fmt.Errorf("malformed title pattern: %w", err)
+	}
+
+	if f.MinDuration < 0 || f.MaxDuration < 0 {
+		return errors.New("duration limits cannot be negative")
+	}
+
+	if f.MaxDuration > 0 && f.MinDuration > f.MaxDuration {
+		return errors.New("min duration cannot exceed max duration")
+	}
+
+	return nil
+}
+
+// Match returns true if a video with given title and duration passes the filter. Videos with unknown duration
+// pass the duration limits.
+func (f SubscriptionFilter) Match(title string, d time.Duration) bool {
+	if f.TitlePattern != "" {
+		re, err := regexp.Compile(f.TitlePattern)
+		if err != nil || !re.MatchString(title) {
+			return false
+		}
+	}
+
+	if d == 0 {
+		return true
+	}
+
+	return d >= f.MinDuration && (f.MaxDuration == 0 || d <= f.MaxDuration)
+}
+
+// SubscriptionRetention defines how long episodes added by a mirror are kept in the feed.
+type SubscriptionRetention struct {
+	// KeepLatest is the number of the latest episodes to keep, zero means all of them.
+	KeepLatest int
+	// MaxAge is the time since publication after which episodes are removed, zero means they are never removed.
+	MaxAge time.Duration
+}
+
+// Validate returns an error if the retention rules are malformed.
+func (r SubscriptionRetention) Validate() error {
+	if r.KeepLatest < 0 || r.MaxAge < 0 {
+		return errors.New("retention limits cannot be negative")
+	}
+
+	return nil
+}
+
+// Expired returns true if an episode published at given time should no longer be kept. Episodes with unknown
+// publication time never expire.
+func (r SubscriptionRetention) Expired(publishedAt, now time.Time) bool {
+	return r.MaxAge > 0 && !publishedAt.IsZero() && now.Sub(publishedAt) > r.MaxAge
+}
+
+// Subscription is a YouTube channel or playlist new videos of which are automatically added to a feed, or a mirror
+// of a podcast feed.
+type Subscription struct {
+	ID       string
+	Feed     string
+	Kind     SubscriptionKind
+	SourceID string
+	Title    string
+	SubscriptionFilter
+	SubscriptionRetention
+	// TranscodingProfile is the name of the profile to transcode added items with, the feed profile is used if empty.
+	TranscodingProfile string
+
+	CreatedAt   time.Time
+	CheckedAt   time.Time
+	NextCheckAt time.Time
+	LastError   string
+
+	// Seen holds IDs of videos or enclosure URLs of episodes that have been either added to the feed or filtered out.
+	Seen []string
+	// Mirrored holds IDs of items added by a mirror, retention rules are applied to them.
+	Mirrored []string
+}
+
+// ParseSubscriptionSource returns the kind and the ID of a subscription source referenced by s, which is
+// either a channel link (youtube.com/channel/<id>), a playlist link, a bare channel or playlist ID, or a podcast
+// feed URL to mirror. The ID of a mirror is the feed URL.
+func ParseSubscriptionSource(s string) (SubscriptionKind, string, error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case youTubeChannelIDRe.MatchString(s):
+		return ChannelSubscription, s, nil
+	case youTubePlaylistIDRe.MatchString(s):
+		return PlaylistSubscription, s, nil
+	}
+
+	if id := extractYouTubePlaylistID(s); id != "" {
+		return PlaylistSubscription, id, nil
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return 0, "", ErrUnsupportedSubscription
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) > 1 && segments[0] == "channel" && youTubeChannelIDRe.MatchString(segments[1]) {
+		return ChannelSubscription, segments[1], nil
+	}
+
+	switch host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."); {
+	case host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+	case host == "youtu.be", host == "youtube.com", strings.HasSuffix(host, ".youtube.com"):
+		// other YouTube links, i.e. channel handles, are not supported
+	default:
+		return MirrorSubscription, u.String(), nil
+	}
+
+	return 0, "", ErrUnsupportedSubscription
+}
+
+// URL returns the link to the subscribed channel, playlist or mirrored feed.
+func (sub Subscription) URL() string {
+	switch sub.Kind {
+	case ChannelSubscription:
+		return "https://youtube.com/channel/" + sub.SourceID
+	case MirrorSubscription:
+		return sub.SourceID
+	}
+
+	return "https://youtube.com/playlist?list=" + sub.SourceID
+}
+
+// PlaylistID returns the ID of the playlist to poll. Channels are polled via their uploads playlist.
+func (sub Subscription) PlaylistID() string {
+	if sub.Kind == ChannelSubscription {
+		return "UU" + strings.TrimPrefix(sub.SourceID, "UC")
+	}
+
+	return sub.SourceID
+}
+
+// Name returns the title of the subscription falling back to its URL if the title is not known yet.
+func (sub Subscription) Name() string {
+	if sub.Title != "" {
+		return sub.Title
+	}
+
+	return sub.URL()
+}
+
+type boltSubscription struct {
+	Feed               string           `json:",omitempty"`
+	Kind               SubscriptionKind `json:",omitempty"`
+	SourceID           string           `json:",omitempty"`
+	Title              string           `json:",omitempty"`
+	TitlePattern       string           `json:",omitempty"`
+	MinDuration        time.Duration    `json:",omitempty"`
+	MaxDuration        time.Duration    `json:",omitempty"`
+	ExcludeShorts      bool             `json:",omitempty"`
+	KeepLatest         int              `json:",omitempty"`
+	MaxAge             time.Duration    `json:",omitempty"`
+	TranscodingProfile string           `json:",omitempty"`
+	CreatedAt          time.Time
+	CheckedAt          time.Time `json:",omitzero"`
+	NextCheckAt        time.Time `json:",omitzero"`
+	LastError          string    `json:",omitempty"`
+	Seen               []string  `json:",omitempty"`
+	Mirrored           []string  `json:",omitempty"`
+}
+
+func newBoltSubscription(sub Subscription) boltSubscription {
+	return boltSubscription{
+		Feed:               sub.Feed,
+		Kind:               sub.Kind,
+		SourceID:           sub.SourceID,
+		Title:              sub.Title,
+		TitlePattern:       sub.TitlePattern,
+		MinDuration:        sub.MinDuration,
+		MaxDuration:        sub.MaxDuration,
+		ExcludeShorts:      sub.ExcludeShorts,
+		KeepLatest:         sub.KeepLatest,
+		MaxAge:             sub.MaxAge,
+		TranscodingProfile: sub.TranscodingProfile,
+		CreatedAt:          sub.CreatedAt,
+		CheckedAt:          sub.CheckedAt,
+		NextCheckAt:        sub.NextCheckAt,
+		LastError:          sub.LastError,
+		Seen:               sub.Seen,
+		Mirrored:           sub.Mirrored,
+	}
+}
+
+// Subscription converts the stored subscription into a Subscription with given ID.
+func (bs boltSubscription) Subscription(id string) Subscription {
+	return Subscription{
+		ID:       id,
+		Feed:     bs.Feed,
+		Kind:     bs.Kind,
+		SourceID: bs.SourceID,
+		Title:    bs.Title,
+		SubscriptionFilter: SubscriptionFilter{
+			TitlePattern:  bs.TitlePattern,
+			MinDuration:   bs.MinDuration,
+			MaxDuration:   bs.MaxDuration,
+			ExcludeShorts: bs.ExcludeShorts,
+		},
+		SubscriptionRetention: SubscriptionRetention{
+			KeepLatest: bs.KeepLatest,
+			MaxAge:     bs.MaxAge,
+		},
+		TranscodingProfile: bs.TranscodingProfile,
+		CreatedAt:          bs.CreatedAt,
+		CheckedAt:          bs.CheckedAt,
+		NextCheckAt:        bs.NextCheckAt,
+		LastError:          bs.LastError,
+		Seen:               bs.Seen,
+		Mirrored:           bs.Mirrored,
+	}
+}
+
+// SubscriptionStore keeps channel and playlist subscriptions and mirrors in BoltDB.
+type SubscriptionStore struct {
+	db     *bolt.DB
+	notify chan struct{}
+}
+
+// NewSubscriptionStore returns a new instance of SubscriptionStore.
+func NewSubscriptionStore(db *bolt.DB) *SubscriptionStore {
+	return &SubscriptionStore{
+		db:     db,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Notify returns a channel that receives a value whenever a subscription needs to be checked.
+func (s *SubscriptionStore) Notify() <-chan struct{} {
+	return s.notify
+}
+
+func (s *SubscriptionStore) wakeUp() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Create stores a new subscription assigning it a random ID.
+func (s *SubscriptionStore) Create(sub Subscription) (Subscription, error) {
+	if err := sub.SubscriptionFilter.Validate(); err != nil {
+		return Subscription{}, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+
+	if err := sub.SubscriptionRetention.Validate(); err != nil {
+		return Subscription{}, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+
+	if sub.Kind != MirrorSubscription && sub.SubscriptionRetention != (SubscriptionRetention{}) {
+		return Subscription{}, fmt.Errorf("%w: retention rules are only supported by mirrors", ErrInvalidRequest)
+	}
+
+	if err := ValidateTranscodingProfile(sub.TranscodingProfile); err != nil {
+		return Subscription{}, fmt.Errorf("%w: %s", ErrInvalidRequest, err)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Subscription{}, fmt.Errorf("failed to generate subscription id: %w", err)
+	}
+
+	sub.ID, sub.CreatedAt = hex.EncodeToString(b), time.Now()
+	sub.CheckedAt, sub.NextCheckAt, sub.LastError, sub.Seen, sub.Mirrored = time.Time{}, time.Time{}, "", nil, nil
+
+	if err := s.put(sub); err != nil {
+		return Subscription{}, err
+	}
+
+	s.wakeUp()
+
+	return sub, nil
+}
+
+// Update stores the state of a subscription after a check leaving its settings intact. The check is expected
+// to have been scheduled at due, and if the subscription has been rescheduled with Check in the meantime, the new
+// schedule is kept. Update returns ErrSubscriptionNotFound if the subscription has been removed during the check.
+func (s *SubscriptionStore) Update(sub Subscription, due time.Time) error {
+	return s.modify(sub.ID, func(stored *Subscription) {
+		if stored.NextCheckAt.Equal(due) {
+			stored.NextCheckAt = sub.NextCheckAt
+		}
+
+		stored.Title, stored.Seen, stored.Mirrored = sub.Title, sub.Seen, sub.Mirrored
+		stored.CheckedAt, stored.LastError = sub.CheckedAt, sub.LastError
+	})
+}
+
+// Import stores a subscription exported from another instance keeping its ID and the list of seen videos.
+// It returns false if a subscription with the same ID already exists.
+func (s *SubscriptionStore) Import(sub Subscription) (bool, error) {
+	switch _, err := s.Get(sub.ID); err {
+	case nil:
+		return false, nil
+	case ErrSubscriptionNotFound:
+	default:
+		return false, err
+	}
+
+	if err := s.put(sub); err != nil {
+		return false, err
+	}
+
+	s.wakeUp()
+
+	return true, nil
+}
+
+// Check schedules the subscription to be checked as soon as possible.
+func (s *SubscriptionStore) Check(id string) error {
+	err := s.modify(id, func(sub *Subscription) {
+		sub.NextCheckAt = time.Now()
+	})
+	if err != nil {
+		return err
+	}
+
+	s.wakeUp()
+
+	return nil
+}
+
+// Get returns a subscription by its ID.
+func (s *SubscriptionStore) Get(id string) (Subscription, error) {
+	var sub Subscription
+
+	return sub, s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("subscriptions"))
+		if b == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		var bs boltSubscription
+		if err := json.Unmarshal(v, &bs); err != nil {
+			return fmt.Errorf("failed to unmarshal subscription %s: %w", id, err)
+		}
+
+		sub = bs.Subscription(id)
+
+		return nil
+	})
+}
+
+// All returns all stored subscriptions ordered by creation time. If feed is not empty,
+// only subscriptions of this feed are returned.
+func (s *SubscriptionStore) All(feed string) ([]Subscription, error) {
+	var subs []Subscription
+
+	return subs, s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		subs, err = readSubscriptions(tx, feed)
+
+		return err
+	})
+}
+
+// readSubscriptions returns subscriptions stored in the database within a transaction ordered by creation time.
+// If feed is not empty, only subscriptions of this feed are returned.
+func readSubscriptions(tx *bolt.Tx, feed string) ([]Subscription, error) {
+	b := tx.Bucket([]byte("subscriptions"))
+	if b == nil {
+		return nil, nil
+	}
+
+	var subs []Subscription
+
+	err := b.ForEach(func(k, v []byte) error {
+		var bs boltSubscription
+		if err := json.Unmarshal(v, &bs); err != nil {
+			return fmt.Errorf("failed to unmarshal subscription %s: %w", k, err)
+		}
+
+		if feed == "" || bs.Feed == feed {
+			subs = append(subs, bs.Subscription(string(k)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+
+	return subs, nil
+}
+
+// Remove deletes a subscription. Items that have already been added to the feed are kept.
+func (s *SubscriptionStore) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("subscriptions"))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// modify applies fn to a stored subscription within a single transaction.
+func (s *SubscriptionStore) modify(id string, fn func(*Subscription)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("subscriptions"))
+		if b == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSubscriptionNotFound
+		}
+
+		var bs boltSubscription
+		if err := json.Unmarshal(v, &bs); err != nil {
+			return fmt.Errorf("failed to unmarshal subscription %s: %w", id, err)
+		}
+
+		sub := bs.Subscription(id)
+		fn(&sub)
+
+		data, err := json.Marshal(newBoltSubscription(sub))
+		if err != nil {
+			return fmt.Errorf("failed to marshal subscription: %w", err)
+		}
+
+		return b.Put([]byte(id), data)
+	})
+}
+
+func (s *SubscriptionStore) put(sub Subscription) error {
+	data, err := json.Marshal(newBoltSubscription(sub))
+	if err != nil {
+		return fmt.Errorf("failed to marshal subscription: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("subscriptions"))
+		if err != nil {
+			return fmt.Errorf("failed to open subscriptions bucket: %w", err)
+		}
+
+		return b.Put([]byte(sub.ID), data)
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/boltdb/bolt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
@@ -24,6 +25,18 @@ type TelegramProvider struct {
 	api             *tgbotapi.BotAPI
 	mediaServiceURL *url.URL
 	allowedUsers    map[int]struct{}
+	feeds           feedLister
+	chats           chatFeedStore
+}
+
+type feedLister interface {
+	Get(string) (PodcastFeed, error)
+	All() ([]PodcastFeed, error)
+}
+
+type chatFeedStore interface {
+	ChatFeed(chatID int64) (string, error)
+	SetChatFeed(chatID int64, slug string) error
 }
 
 // NewTelegramProvider creates a new TelegramProvider instance.
@@ -70,6 +83,12 @@ func (tg *TelegramProvider) WhitelistUser(id int) {
 	}
 }
 
+// UseFeeds makes the bot accept /feed and /feeds commands to choose the feed where items sent
+// to a chat are added. The chosen feeds are kept in chats.
+func (tg *TelegramProvider) UseFeeds(feeds feedLister, chats chatFeedStore) {
+	tg.feeds, tg.chats = feeds, chats
+}
+
 // Name returns the name of the provider.
 func (tg *TelegramProvider) Name() string {
 	return "Telegram"
@@ -173,6 +192,7 @@ func (tg *TelegramProvider) HandleMessage(msg *tgbotapi.Message) (*TelegramMessa
 	}
 
 	return &TelegramMessage{
+		Feed:        tg.chatFeed(msg),
 		Audio:       msg.Audio,
 		Description: msg.Caption,
 		Link:        linkURL,
@@ -222,16 +242,99 @@ func (tg *TelegramProvider) Updates(ctx context.Context) (<-chan *TelegramMessag
 
 // HandleCommand handles an incoming command from Telegram.
 func (tg *TelegramProvider) HandleCommand(msg *tgbotapi.Message) {
-	switch strings.ToLower(msg.Text) {
+	cmd, arg := msg.Text, ""
+	if ind := strings.IndexByte(cmd, ' '); ind > -1 {
+		cmd, arg = cmd[:ind], strings.TrimSpace(cmd[ind+1:])
+	}
+
+	switch strings.ToLower(cmd) {
 	case "/start", "/help":
 		tg.sendResponse(msg, "Hello, I'm LaterCast bot! Just forward me audio files and I will add them to your feed.", false)
 	case "/status":
 		tg.sendResponse(msg, "Up and running!", false)
+	case "/feeds":
+		if tg.feeds == nil {
+			tg.sendResponse(msg, "Multiple feeds are not supported", false)
+			return
+		}
+
+		feeds, err := tg.feeds.All()
+		if err != nil {
+			log.Printf("failed to fetch feeds: %s", err)
+			tg.sendResponse(msg, "Could not fetch the list of feeds", false)
+			return
+		}
+
+		var buf strings.Builder
+		buf.WriteString("Available feeds, use /feed <name> to choose one:\n")
+		for _, feed := range feeds {
+			buf.WriteString("\n" + feed.Slug + " — " + feed.Title)
+		}
+
+		tg.sendResponse(msg, buf.String(), false)
+	case "/feed":
+		if tg.feeds == nil {
+			tg.sendResponse(msg, "Multiple feeds are not supported", false)
+			return
+		}
+
+		if arg == "" {
+			arg = DefaultFeed
+		}
+
+		feed, err := tg.feeds.Get(strings.ToLower(arg))
+		if err != nil {
+			tg.sendResponse(msg, "Unknown feed "+arg+", send /feeds to list available ones", false)
+			return
+		}
+
+		if err := tg.chats.SetChatFeed(msg.Chat.ID, feed.Slug); err != nil {
+			log.Printf("failed to store feed of telegram chat %d: %s", msg.Chat.ID, err)
+			tg.sendResponse(msg, "Could not choose the feed", false)
+			return
+		}
+
+		tg.sendResponse(msg, fmt.Sprintf(`Audio files will be added to "%s"`, feed.Title), false)
 	default:
 		tg.sendResponse(msg, "Unknown command, send /help", false)
 	}
 }
 
+// chatFeed returns the slug of the feed chosen for the chat. If the feed has been removed, the chat is
+// switched back to the default one.
+func (tg *TelegramProvider) chatFeed(msg *tgbotapi.Message) string {
+	if tg.feeds == nil {
+		return ""
+	}
+
+	slug, err := tg.chats.ChatFeed(msg.Chat.ID)
+	if err != nil {
+		log.Printf("failed to fetch feed of telegram chat %d: %s", msg.Chat.ID, err)
+		return ""
+	}
+
+	if slug == "" {
+		return ""
+	}
+
+	switch _, err := tg.feeds.Get(slug); err {
+	case nil:
+		return slug
+	case ErrFeedNotFound:
+		log.Printf("feed %s of telegram chat %d was removed, switching to the default one", slug, msg.Chat.ID)
+
+		if err := tg.chats.SetChatFeed(msg.Chat.ID, ""); err != nil {
+			log.Printf("failed to reset feed of telegram chat %d: %s", msg.Chat.ID, err)
+		}
+
+		tg.sendResponse(msg, "Feed "+slug+" has been removed, adding audio files to the default feed instead", false)
+	default:
+		log.Printf("failed to fetch feed %s: %s", slug, err)
+	}
+
+	return ""
+}
+
 func (tg *TelegramProvider) sendResponse(msg *tgbotapi.Message, text string, quoteSrc bool) {
 	resp := tgbotapi.NewMessage(msg.Chat.ID, text)
 	if quoteSrc {
@@ -245,6 +348,7 @@ func (tg *TelegramProvider) sendResponse(msg *tgbotapi.Message, text string, quo
 
 // TelegramMessage represents a Telegram message with an audio file.
 type TelegramMessage struct {
+	Feed        string
 	Audio       *tgbotapi.Audio
 	Description string
 	Link        string
@@ -269,3 +373,64 @@ func (tg *TelegramMessage) Metadata(ctx context.Context) (Metadata, error) {
 func (tg *TelegramMessage) DownloadURL(context.Context) (string, error) {
 	return tg.FileURL, nil
 }
+
+// TelegramChatStore keeps the feeds chosen for Telegram chats in the "telegram_chats" bucket of a BoltDB database.
+type TelegramChatStore struct {
+	db *bolt.DB
+}
+
+// NewTelegramChatStore returns a new instance of TelegramChatStore.
+func NewTelegramChatStore(db *bolt.DB) *TelegramChatStore {
+	return &TelegramChatStore{db}
+}
+
+type boltTelegramChat struct {
+	Feed string `json:",omitempty"`
+}
+
+// ChatFeed returns the slug of the feed chosen for the chat, or an empty string if there is none.
+func (s *TelegramChatStore) ChatFeed(chatID int64) (string, error) {
+	var chat boltTelegramChat
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("telegram_chats"))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(strconv.FormatInt(chatID, 10)))
+		if v == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(v, &chat); err != nil {
+			return fmt.Errorf("failed to unmarshal telegram chat %d: %w", chatID, err)
+		}
+
+		return nil
+	})
+
+	return chat.Feed, err
+}
+
+// SetChatFeed stores the feed chosen for the chat. An empty slug selects the default feed.
+func (s *TelegramChatStore) SetChatFeed(chatID int64, slug string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("telegram_chats"))
+		if err != nil {
+			return fmt.Errorf("failed to open telegram_chats bucket: %w", err)
+		}
+
+		k := []byte(strconv.FormatInt(chatID, 10))
+		if slug == "" {
+			return b.Delete(k)
+		}
+
+		data, err := json.Marshal(boltTelegramChat{Feed: slug})
+		if err != nil {
+			return fmt.Errorf("failed to marshal telegram chat %d: %w", chatID, err)
+		}
+
+		return b.Put(k, data)
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoSubtitles is returned by audio sources that have no subtitles for the media.
+var ErrNoSubtitles = errors.New("no subtitles available")
+
+// TranscriptFormats lists file extensions of the stored transcript files along with their content types.
+// The first one is the primary format referenced by the item.
+var TranscriptFormats = []struct {
+	Ext, ContentType string
+}{
+	{".vtt", "text/vtt"},
+	{".srt", "application/x-subrip"},
+	{".txt", "text/plain"},
+}
+
+// transcriptContentType returns the content type of a transcript file with given extension.
+func transcriptContentType(ext string) (string, bool) {
+	for _, f := range TranscriptFormats {
+		if f.Ext == ext {
+			return f.ContentType, true
+		}
+	}
+
+	return "", false
+}
+
+// TranscriptCue is a piece of text spoken between Start and End.
+type TranscriptCue struct {
+	Start, End time.Duration
+	Text       string
+}
+
+// Transcript is a list of transcript cues ordered by their start time.
+type Transcript []TranscriptCue
+
+// cueTimingRe matches the timing line of both WebVTT and SRT cues, i.e. "00:01.000 --> 00:04.250"
+// or "00:00:01,000 --> 00:00:04,250".
+var cueTimingRe = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+
+// vttEscaper escapes characters that have special meaning in WebVTT cue text.
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// cueTagRe matches WebVTT cue tags, such as <v Speaker>, <c> or inline timestamps.
+var cueTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// ParseTranscript parses a transcript in either WebVTT or SRT format. Cue identifiers, settings, styling
+// and notes are dropped.
+func ParseTranscript(data []byte) (Transcript, error) {
+	var (
+		t   Transcript
+		cue *TranscriptCue
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+
+		if line == "" {
+			cue = nil
+			continue
+		}
+
+		if m := cueTimingRe.FindStringSubmatch(line); m != nil {
+			start, ok1 := parseCueTime(m[1])
+			end, ok2 := parseCueTime(m[2])
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("malformed cue timing %q", line)
+			}
+
+			t = append(t, TranscriptCue{Start: start, End: end})
+			cue = &t[len(t)-1]
+
+			continue
+		}
+
+		if cue == nil {
+			continue // header, cue identifier or note
+		}
+
+		text := strings.TrimSpace(html.UnescapeString(cueTagRe.ReplaceAllString(line, "")))
+		if text == "" {
+			continue
+		}
+
+		if cue.Text != "" {
+			cue.Text += "\n"
+		}
+
+		cue.Text += text
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+
+	return t.compact(), nil
+}
+
+// compact drops empty cues.
+func (t Transcript) compact() Transcript {
+	var res Transcript
+	for _, cue := range t {
+		if cue.Text != "" {
+			res = append(res, cue)
+		}
+	}
+
+	return res
+}
+
+// Shift returns the transcript of media that is trimmed at the beginning by offset. Cues that end
+// before the offset are dropped.
+func (t Transcript) Shift(offset time.Duration) Transcript {
+	if offset <= 0 {
+		return t
+	}
+
+	var shifted Transcript
+	for _, cue := range t {
+		if cue.End <= offset {
+			continue
+		}
+
+		cue.Start, cue.End = max(cue.Start-offset, 0), cue.End-offset
+		shifted = append(shifted, cue)
+	}
+
+	return shifted
+}
+
+// WriteVTT writes the transcript in WebVTT format.
+func (t Transcript) WriteVTT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("WEBVTT\n")
+	for _, cue := range t {
+		fmt.Fprintf(bw, "\n%s --> %s\n%s\n", formatCueTime(cue.Start, '.'), formatCueTime(cue.End, '.'), vttEscaper.Replace(cue.Text))
+	}
+
+	return bw.Flush()
+}
+
+// WriteSRT writes the transcript in SubRip format.
+func (t Transcript) WriteSRT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for i, cue := range t {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n", i+1, formatCueTime(cue.Start, ','), formatCueTime(cue.End, ','), cue.Text)
+	}
+
+	return bw.Flush()
+}
+
+// WriteText writes the transcript as plain text, one cue per line.
+func (t Transcript) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, cue := range t {
+		bw.WriteString(strings.ReplaceAll(cue.Text, "\n", " ") + "\n")
+	}
+
+	return bw.Flush()
+}
+
+// writeTranscriptFiles stores the transcript in all supported formats as stem.vtt, stem.srt and stem.txt.
+func writeTranscriptFiles(stem string, t Transcript) error {
+	writers := map[string]func(io.Writer) error{
+		".vtt": t.WriteVTT,
+		".srt": t.WriteSRT,
+		".txt": t.WriteText,
+	}
+
+	for _, f := range TranscriptFormats {
+		var buf bytes.Buffer
+		if err := writers[f.Ext](&buf); err != nil {
+			return fmt.Errorf("failed to format %s transcript: %w", f.Ext, err)
+		}
+
+		if err := os.WriteFile(stem+f.Ext, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", stem+f.Ext, err)
+		}
+	}
+
+	return nil
+}
+
+// removeTranscriptFiles removes transcript files stored next to the primary one.
+func removeTranscriptFiles(primaryPath string) error {
+	stem := strings.TrimSuffix(primaryPath, path.Ext(primaryPath))
+	for _, f := range TranscriptFormats {
+		if err := os.Remove(stem + f.Ext); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", stem+f.Ext, err)
+		}
+	}
+
+	return nil
+}
+
+// parseCueTime parses a cue timestamp in [hh:]mm:ss.ttt format. SRT timestamps use comma as a decimal separator.
+func parseCueTime(s string) (time.Duration, bool) {
+	s = strings.Replace(s, ",", ".", 1)
+
+	ind := strings.LastIndexByte(s, ':')
+	clock, sec := s[:ind], s[ind+1:]
+
+	d, ok := parseClockTime(clock + ":00")
+	if !ok {
+		return 0, false
+	}
+
+	secs, err := strconv.ParseFloat(sec, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return d + time.Duration(secs*float64(time.Second)).Round(time.Millisecond), true
+}
+
+// formatCueTime formats a cue timestamp as hh:mm:ss.ttt using given decimal separator.
+func formatCueTime(d time.Duration, sep byte) string {
+	d = d.Round(time.Millisecond)
+
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d",
+		int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second), sep, int(d%time.Second/time.Millisecond))
+}
+
+// CommandTranscriber transcribes media files running a local speech-to-text command, such as whisper.cpp.
+type CommandTranscriber struct {
+	args []string
+}
+
+// NewCommandTranscriber returns a new instance of CommandTranscriber that runs the command. Arguments are separated
+// by whitespace, {input} is replaced with the path to a 16 kHz mono WAV file to transcribe, and {output} with the path
+// the command should write the transcript to without extension. The command is expected to write the transcript in
+// either WebVTT or SRT format to {output}.vtt, {output}.srt or to its stdout.
+func NewCommandTranscriber(cmd string) (*CommandTranscriber, error) {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return nil, errors.New("empty transcription command")
+	}
+
+	if !strings.Contains(cmd, "{input}") {
+		return nil, errors.New("transcription command must reference the input file as {input}")
+	}
+
+	return &CommandTranscriber{args: args}, nil
+}
+
+// Transcribe converts the media file at filePath into WAV using following command:
+// ffmpeg -i $filePath -vn -ar 16000 -ac 1 -c:a pcm_s16le $tempFile
+// and runs the transcription command on it.
+func (t *CommandTranscriber) Transcribe(ctx context.Context, filePath string) (Transcript, error) {
+	tmpDir, err := os.MkdirTemp("", "youcast-transcript-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inputPath, outputPath := path.Join(tmpDir, "input.wav"), path.Join(tmpDir, "transcript")
+
+	out, err := exec.CommandContext(
+		ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error", "-y", "-i", filePath, "-vn", "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le",
+		inputPath,
+	).CombinedOutput()
+	if err != nil {
+		log.Println("ffmpeg responded with", string(out))
+		return nil, fmt.Errorf("failed to convert file to WAV: %w", err)
+	}
+
+	args := make([]string, len(t.args))
+	for i, arg := range t.args {
+		args[i] = strings.NewReplacer("{input}", inputPath, "{output}", outputPath).Replace(arg)
+	}
+
+	stdout, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			log.Println(args[0], "responded with", string(exitErr.Stderr))
+		}
+
+		return nil, fmt.Errorf("failed to run transcription command: %w", err)
+	}
+
+	data := stdout
+	for _, ext := range [...]string{".vtt", ".srt"} {
+		if b, err := os.ReadFile(outputPath + ext); err == nil {
+			data = b
+			break
+		}
+	}
+
+	transcript, err := ParseTranscript(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transcript: %w", err)
+	}
+
+	if len(transcript) == 0 {
+		return nil, errors.New("transcription command produced no transcript")
+	}
+
+	return transcript, nil
+}
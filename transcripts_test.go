@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTranscript(t *testing.T) {
+	for name, tc := range map[string]struct {
+		Input    string
+		Expected Transcript
+	}{
+		"webvtt": {
+			Input: `WEBVTT Kind: captions
+Language: en
+
+NOTE This is a comment
+that spans two lines
+
+STYLE
+::cue { color: yellow }
+
+intro
+00:01.000 --> 00:04.250 align:start position:0%
+<v Speaker>Hello &amp; welcome</v>
+
+00:04.250 --> 00:00:07.5
+to the <c.highlight>show</c>
+<00:00:05.000>second line
+
+1:00:00.000 --> 1:00:02.000
+Last cue
+`,
+			Expected: Transcript{
+				{Start: time.Second, End: 4250 * time.Millisecond, Text: "Hello & welcome"},
+				{Start: 4250 * time.Millisecond, End: 7500 * time.Millisecond, Text: "to the show\nsecond line"},
+				{Start: time.Hour, End: time.Hour + 2*time.Second, Text: "Last cue"},
+			},
+		},
+		"srt": {
+			Input: "1\r\n00:00:01,000 --> 00:00:04,250\r\nHello\r\n\r\n2\r\n00:00:04,250 --> 00:00:07,500\r\n<i>world</i>\r\n  and more  \r\n",
+			Expected: Transcript{
+				{Start: time.Second, End: 4250 * time.Millisecond, Text: "Hello"},
+				{Start: 4250 * time.Millisecond, End: 7500 * time.Millisecond, Text: "world\nand more"},
+			},
+		},
+		"empty cues are dropped": {
+			Input: `WEBVTT
+
+00:00.000 --> 00:01.000
+
+00:01.000 --> 00:02.000
+<c></c>
+
+00:02.000 --> 00:03.000
+Text
+`,
+			Expected: Transcript{
+				{Start: 2 * time.Second, End: 3 * time.Second, Text: "Text"},
+			},
+		},
+		"text outside of cues is ignored": {
+			Input: "Some text\n\nmore text\n",
+		},
+		"empty": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseTranscript([]byte(tc.Input))
+			if err != nil {
+				t.Fatalf("ParseTranscript() returned an error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.Expected) {
+				t.Errorf("ParseTranscript():\nexpected %+v\n     got %+v", tc.Expected, got)
+			}
+		})
+	}
+}
+
+func TestParseTranscript_Errors(t *testing.T) {
+	for name, input := range map[string]string{
+		"hours overflow": "99999999999999999999:00:00.000 --> 99999999999999999999:00:01.000\nText\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got, err := ParseTranscript([]byte(input)); err == nil {
+				t.Errorf("ParseTranscript() = %+v, expected an error", got)
+			}
+		})
+	}
+}
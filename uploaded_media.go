@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -39,43 +41,49 @@ func (*UploadedMediaProvider) Name() string {
 
 // HandleRequest handles an HTTP request and returns an audio source.
 func (p *UploadedMediaProvider) HandleRequest(w http.ResponseWriter, req *http.Request) audioSource {
-	fd, header, err := req.FormFile("media")
+	meta, err := p.ParseRequest(req)
 	if err != nil {
-		log.Printf("failed to read uploaded file: %s", err)
+		log.Printf("failed to store uploaded file: %s", err)
+		if errors.Is(err, ErrInvalidRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 
 		return nil
 	}
 
+	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
+
+	return meta
+}
+
+// ParseRequest stores the file uploaded as media= form field and returns it as an audio source.
+func (p *UploadedMediaProvider) ParseRequest(req *http.Request) (audioSource, error) {
+	fd, header, err := req.FormFile("media")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read uploaded file: %s", ErrInvalidRequest, err)
+	}
+
 	tmpPath := path.Join(p.cachePath, header.Filename)
 
 	tmpFd, err := os.Create(tmpPath)
 	if err != nil {
-		log.Printf("failed to create temp file %s: %s", tmpPath, err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-
-		return nil
+		return nil, fmt.Errorf("failed to create temp file %s: %w", tmpPath, err)
 	}
 	defer tmpFd.Close()
 
 	if _, err := io.Copy(tmpFd, fd); err != nil {
-		log.Printf("failed to copy uploaded file to %s: %s", tmpPath, err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-
-		return nil
+		return nil, fmt.Errorf("failed to copy uploaded file to %s: %w", tmpPath, err)
 	}
 
 	if err := tmpFd.Sync(); err != nil {
-		log.Printf("failed to store uploaded file to %s: %s", tmpPath, err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-
-		return nil
+		return nil, fmt.Errorf("failed to store uploaded file to %s: %w", tmpPath, err)
 	}
 
 	log.Printf("stored uploaded file to %s", tmpPath)
 
-	http.Redirect(w, req, req.Referer(), http.StatusSeeOther)
-
 	meta := UploadedMedia{
 		FileName:    header.Filename,
 		Title:       header.Filename,
@@ -98,11 +106,15 @@ func (p *UploadedMediaProvider) HandleRequest(w http.ResponseWriter, req *http.R
 		if t := m.Album(); t != "" {
 			meta.Title = t + ": " + meta.Title
 		}
+
+		if pic := m.Picture(); pic != nil {
+			meta.Image, meta.ImageMIMEType = pic.Data, pic.MIMEType
+		}
 	} else {
 		log.Printf("failed to read uploaded file metadata: %s", err)
 	}
 
-	return meta
+	return meta, nil
 }
 
 // UploadedMedia is an audio source that represents an uploaded media file.
@@ -113,18 +125,23 @@ type UploadedMedia struct {
 	FileName string
 	MIMEType string
 
+	Image         []byte
+	ImageMIMEType string
+
 	downloadURL string
 }
 
 // Metadata returns the metadata of the audio source.
 func (m UploadedMedia) Metadata(ctx context.Context) (Metadata, error) {
 	return Metadata{
-		Type:        UploadedItem,
-		Author:      m.Author,
-		Title:       m.Title,
-		Description: m.Title,
-		Duration:    m.Duration,
-		MIMEType:    m.MIMEType,
+		Type:          UploadedItem,
+		Author:        m.Author,
+		Title:         m.Title,
+		Description:   m.Title,
+		Duration:      m.Duration,
+		MIMEType:      m.MIMEType,
+		Image:         m.Image,
+		ImageMIMEType: m.ImageMIMEType,
 	}, nil
 }
 
@@ -5,29 +5,58 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
-	"log"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/andrewslotin/youcast/assets"
-	"github.com/eduncan911/podcast"
 )
 
 // DownloadablePodcastItem is a podcast item that is ready to be downloaded.
 type DownloadablePodcastItem struct {
 	PodcastItem
-	MediaURL string
+	MediaURL    string
+	ImageURL    string
+	ChaptersURL string
+	Transcripts []TranscriptLink
+}
+
+// TranscriptLink references the item transcript in one of supported formats, i.e. "vtt".
+type TranscriptLink struct {
+	Format    string
+	URL, Type string
 }
 
 // Feed contains data for a podcast feed.
 type Feed struct {
+	Slug, Path         string
 	URL, IconURL       string
+	SelfURL            string
 	Title, Description string
+	Author, Owner      string
+	Email, Language    string
+	Category           string
+	Explicit           bool
 	PubDate            time.Time
 	Items              []DownloadablePodcastItem
+	// Offset is the number of items displayed on previous pages, and NextPageURL is the URL of
+	// the next page of the web UI, empty for the last one.
+	Offset             int
+	NextPageURL        string
+	Feeds              []PodcastFeed
+	AuthEnabled        bool
+	Tokens             []AccessToken
+	Subscriptions      []Subscription
+	TranscodingProfile string
+	PostProcessing     PostProcessing
 }
 
+// Number of items displayed on a page of the web UI.
+const (
+	DefaultItemsPerPage = 50
+	MaxItemsPerPage     = 500
+)
+
 // Templates contains parsed templates.
 var Templates = ParseTemplates(assets.Templates)
 
@@ -42,6 +71,13 @@ func ParseTemplates(fs fs.FS) *template.Template {
 
 				return s
 			},
+			"transcodingProfiles": TranscodingProfileNames,
+			"add": func(a, b int) int {
+				return a + b
+			},
+			"days": func(d time.Duration) int {
+				return int(d / (24 * time.Hour))
+			},
 			"formatDuration": func(d time.Duration) string {
 				d = d.Round(time.Second)
 
@@ -72,52 +108,6 @@ func (r HTMLRenderer) Render(w io.Writer, feed Feed) error {
 	return r.Template.Execute(w, feed)
 }
 
-// AtomRenderer renders a podcast feed as Atom.
-type AtomRenderer struct{}
-
-// ContentType returns the content type of the rendered feed.
-func (AtomRenderer) ContentType() string {
-	return "application/xml"
-}
-
-// Render renders the feed to the given writer.
-func (AtomRenderer) Render(w io.Writer, feed Feed) error {
-	var pubDate *time.Time
-	if !feed.PubDate.IsZero() {
-		pubDate = &feed.PubDate
-	}
-
-	p := podcast.New(feed.Title, feed.URL, feed.Description, pubDate, nil)
-	p.AddImage(feed.IconURL)
-
-	for _, it := range feed.Items {
-		if !it.Playable() {
-			continue // skip incomplete items
-		}
-
-		item := podcast.Item{
-			Title: it.Title,
-			Author: &podcast.Author{
-				Name:  it.Author,
-				Email: "user@example.com",
-			},
-			Description: itemDescription(it),
-			Link:        it.OriginalURL,
-		}
-
-		item.AddEnclosure(it.MediaURL, mimeTypeToEnclosureType(it.MIMEType), int64(it.ContentLength))
-		item.AddDuration(int64(it.Duration / time.Second))
-		item.AddPubDate(&it.AddedAt)
-		item.AddSummary(itemSummary(it))
-
-		if _, err := p.AddItem(item); err != nil {
-			log.Printf("failed to add %s: %s", it.OriginalURL, err)
-		}
-	}
-
-	return p.Encode(w)
-}
-
 func itemDescription(p DownloadablePodcastItem) string {
 	desc := p.Type.String()
 	if p.Author != "" {
@@ -133,7 +123,7 @@ func itemSummary(p DownloadablePodcastItem) string {
 	switch p.Type {
 	case YouTubeItem, TelegramItem:
 		buf.WriteString(`<a href="` + p.OriginalURL + `">` + p.Author + `</a>` + "\n\n")
-	case UploadedItem:
+	case UploadedItem, ImportedItem:
 	}
 
 	if p.Body != "" && p.Body != p.Title {
@@ -2,24 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kkdai/youtube/v2"
 )
 
-// ErrNoAudio is returned when no suitable audio formats are found for a YouTube video.
-var ErrNoAudio = errors.New("no audio formats found")
+var (
+	// ErrNoAudio is returned when no suitable audio formats are found for a YouTube video.
+	ErrNoAudio = errors.New("no audio formats found")
+	// ErrPlaylist is returned when a download URL is requested for a YouTube playlist.
+	ErrPlaylist = errors.New("playlists can only be downloaded video by video")
+)
+
+var youTubeIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
 
 // YouTubeVideo is a YouTube video that provides audio files to the podcast feed.
 type YouTubeVideo struct {
 	c       youtube.Client
 	videoID string
+	start   time.Duration
 	log     *log.Logger
 }
 
@@ -33,19 +45,13 @@ func (yt *YouTubeProvider) Name() string {
 
 // HandleRequest handles a request for a YouTube video.
 func (yt *YouTubeProvider) HandleRequest(w http.ResponseWriter, req *http.Request) audioSource {
-	u := req.FormValue("url")
-	if u == "" {
-		http.Error(w, "missing url= parameter", http.StatusBadRequest)
-		return nil
-	}
-
-	id, err := extractYouTubeID(u)
+	src, err := yt.ParseRequest(req)
 	if err != nil {
-		http.Error(w, "failed to parse YouTube video URL: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return nil
 	}
 
-	redirectURL := u
+	redirectURL := req.FormValue("url")
 	if ref := req.Referer(); ref != "" { // added via the UI form field
 		redirectURL = ref
 	}
@@ -53,27 +59,211 @@ func (yt *YouTubeProvider) HandleRequest(w http.ResponseWriter, req *http.Reques
 	// return the podcast item first, then redirect to the original URL
 	defer http.Redirect(w, req, redirectURL, http.StatusSeeOther)
 
-	return NewYouTubeVideo(id)
+	return src
+}
+
+// ParseRequest returns the YouTube video or playlist referenced by the url= parameter. Playlist imports
+// can be limited to the newest limit= entries, and skip_existing=1 skips videos already present in the feed.
+func (yt *YouTubeProvider) ParseRequest(req *http.Request) (audioSource, error) {
+	u := req.FormValue("url")
+	if u == "" {
+		return nil, fmt.Errorf("%w: missing url= parameter", ErrInvalidRequest)
+	}
+
+	if listID := extractYouTubePlaylistID(u); listID != "" {
+		pl := NewYouTubePlaylist(listID)
+
+		if s := req.FormValue("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("%w: malformed limit= parameter", ErrInvalidRequest)
+			}
+
+			pl.Limit = limit
+		}
+
+		switch strings.ToLower(req.FormValue("skip_existing")) {
+		case "1", "true", "on", "yes":
+			pl.SkipExisting = true
+		}
+
+		return pl, nil
+	}
+
+	id, start, err := extractYouTubeID(u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse YouTube video URL: %s", ErrInvalidRequest, err)
+	}
+
+	return NewYouTubeVideo(id, start), nil
+}
+
+// ResolveSource returns the YouTube video with given URL.
+func (yt *YouTubeProvider) ResolveSource(originalURL string) (audioSource, error) {
+	id, start, err := extractYouTubeID(originalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewYouTubeVideo(id, start), nil
+}
+
+// YouTubePlaylistEntry is a video listed in a YouTube playlist.
+type YouTubePlaylistEntry struct {
+	VideoID  string
+	Title    string
+	Duration time.Duration
+}
+
+// OriginalURL returns the link to the video.
+func (e YouTubePlaylistEntry) OriginalURL() string {
+	return "https://youtube.com/watch?v=" + e.VideoID
+}
+
+// PlaylistEntries returns the title and the videos of a YouTube playlist in the playlist order.
+func (yt *YouTubeProvider) PlaylistEntries(ctx context.Context, playlistID string) (string, []YouTubePlaylistEntry, error) {
+	var c youtube.Client
+
+	pl, err := c.GetPlaylistContext(ctx, playlistID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get playlist info: %w", err)
+	}
+
+	entries := make([]YouTubePlaylistEntry, 0, len(pl.Videos))
+	for _, v := range pl.Videos {
+		entries = append(entries, YouTubePlaylistEntry{v.ID, v.Title, v.Duration})
+	}
+
+	return pl.Title, entries, nil
 }
 
-func extractYouTubeID(s string) (string, error) {
+// IsShort returns true if the video is a YouTube Short. YouTube serves Shorts at /shorts/<id> and redirects
+// to /watch?v=<id> for all other videos.
+func (yt *YouTubeProvider) IsShort(ctx context.Context, videoID string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://www.youtube.com/shorts/"+videoID, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	c := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", req.URL, err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// extractYouTubeID returns the video ID and the start offset referenced by a YouTube link. Supported links are
+// youtube.com/watch?v=<id> (including m., music. and www. subdomains), youtu.be/<id>, youtube.com/shorts/<id>,
+// youtube.com/live/<id>, youtube.com/embed/<id>, youtube.com/v/<id> and bare video IDs. The start offset is
+// taken from either t= or start= parameter.
+func extractYouTubeID(s string) (string, time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if youTubeIDRe.MatchString(s) {
+		return s, 0, nil
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
 	u, err := url.Parse(s)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse YouTube link: %w", err)
+		return "", 0, fmt.Errorf("failed to parse YouTube link: %w", err)
+	}
+
+	var (
+		host     = strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		segments = strings.Split(strings.Trim(u.Path, "/"), "/")
+		id       string
+	)
+
+	switch host {
+	case "youtu.be":
+		id = segments[0]
+	case "youtube.com", "m.youtube.com", "music.youtube.com", "youtube-nocookie.com":
+		switch segments[0] {
+		case "watch":
+			id = u.Query().Get("v")
+		case "shorts", "live", "embed", "v":
+			if len(segments) > 1 {
+				id = segments[1]
+			}
+		}
+	}
+
+	if !youTubeIDRe.MatchString(id) {
+		return "", 0, fmt.Errorf("unsupported YouTube link %s", s)
+	}
+
+	q := u.Query()
+	if f, err := url.ParseQuery(u.Fragment); err == nil && q.Get("t") == "" { // youtu.be/<id>#t=1m30s
+		q.Set("t", f.Get("t"))
+	}
+
+	for _, k := range [...]string{"t", "start"} {
+		if v := q.Get(k); v != "" {
+			start, err := parseYouTubeTimestamp(v)
+			if err != nil {
+				return "", 0, fmt.Errorf("malformed %s= parameter in YouTube link %s: %w", k, s, err)
+			}
+
+			return id, start, nil
+		}
+	}
+
+	return id, 0, nil
+}
+
+// extractYouTubePlaylistID returns the ID of the playlist referenced by a youtube.com/playlist?list=<id> link.
+// Links to a video opened from a playlist, i.e. youtube.com/watch?v=<id>&list=<id>, are considered to be video links.
+func extractYouTubePlaylistID(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") {
+	case "youtube.com", "m.youtube.com", "music.youtube.com":
+		if strings.Trim(u.Path, "/") == "playlist" {
+			return u.Query().Get("list")
+		}
+	}
+
+	return ""
+}
+
+// parseYouTubeTimestamp parses a timestamp in either seconds (90) or duration (1m30s, 1h2m3s) format.
+func parseYouTubeTimestamp(s string) (time.Duration, error) {
+	if sec, err := strconv.Atoi(s); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, nil
 	}
 
-	id := u.Query().Get("v")
-	if id == "" {
-		return "", fmt.Errorf("unsupported YouTube link %s", s)
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
 	}
 
-	return id, nil
+	return d, nil
 }
 
-// NewYouTubeVideo creates a new YouTubeVideo instance.
-func NewYouTubeVideo(videoID string) *YouTubeVideo {
+// NewYouTubeVideo creates a new YouTubeVideo instance. The audio of the resulting podcast item starts at the given offset.
+func NewYouTubeVideo(videoID string, start time.Duration) *YouTubeVideo {
 	return &YouTubeVideo{
 		videoID: videoID,
+		start:   start,
 		log:     log.New(log.Writer(), videoID+": ", log.LstdFlags),
 	}
 }
@@ -97,23 +287,206 @@ func (y *YouTubeVideo) Metadata(ctx context.Context) (Metadata, error) {
 		mimeType = mimeType[:ind]
 	}
 
-	return Metadata{
+	meta := Metadata{
 		Type:          YouTubeItem,
 		OriginalURL:   "https://youtube.com/watch?v=" + y.videoID,
 		Title:         video.Title,
+		Description:   video.Description,
 		Author:        video.Author,
 		Duration:      video.Duration,
 		MIMEType:      mimeType,
 		ContentLength: bestAudio.ContentLength,
+		ImageURL:      pickBestThumbnail(video.Thumbnails),
+		Chapters:      ParseChapters(video.Description),
+	}
+
+	if y.start > 0 && y.start < video.Duration {
+		meta.OriginalURL += "&t=" + strconv.Itoa(int(y.start/time.Second)) + "s"
+		meta.Duration -= y.start
+		meta.StartOffset = y.start
+		meta.Chapters = ShiftChapters(meta.Chapters, y.start)
+	}
+
+	return meta, nil
+}
+
+// YouTubePlaylist is a YouTube playlist that provides a podcast item for each of its videos.
+type YouTubePlaylist struct {
+	c          youtube.Client
+	playlistID string
+
+	// Limit is the number of the newest playlist entries to add. Zero means all entries.
+	Limit int
+	// SkipExisting omits videos that are already present in the feed.
+	SkipExisting bool
+}
+
+// NewYouTubePlaylist creates a new YouTubePlaylist instance.
+func NewYouTubePlaylist(playlistID string) *YouTubePlaylist {
+	return &YouTubePlaylist{playlistID: playlistID}
+}
+
+// Metadata returns the metadata of the YouTube playlist.
+func (p *YouTubePlaylist) Metadata(ctx context.Context) (Metadata, error) {
+	pl, err := p.c.GetPlaylistContext(ctx, p.playlistID)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to get playlist info: %w", err)
+	}
+
+	return Metadata{
+		Type:        YouTubeItem,
+		OriginalURL: "https://youtube.com/playlist?list=" + p.playlistID,
+		Title:       pl.Title,
+		Description: pl.Description,
+		Author:      pl.Author,
 	}, nil
 }
 
+// DownloadURL always returns ErrPlaylist, since playlist entries are downloaded separately.
+func (p *YouTubePlaylist) DownloadURL(context.Context) (string, error) {
+	return "", ErrPlaylist
+}
+
+// Sources returns a YouTubeVideo for each playlist entry in the order they were added to the playlist. If the
+// limit is set, only the newest entries are returned.
+func (p *YouTubePlaylist) Sources(ctx context.Context, exists func(originalURL string) bool) ([]audioSource, error) {
+	pl, err := p.c.GetPlaylistContext(ctx, p.playlistID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist info: %w", err)
+	}
+
+	entries := newestPlaylistEntries(p.playlistID, pl.Videos, p.Limit)
+
+	srcs := make([]audioSource, 0, len(entries))
+	for _, entry := range entries {
+		if p.SkipExisting && exists(YouTubePlaylistEntry{VideoID: entry.ID}.OriginalURL()) {
+			log.Printf("%s: skipping %s that is already in the feed", p.playlistID, entry.ID)
+			continue
+		}
+
+		srcs = append(srcs, NewYouTubeVideo(entry.ID, 0))
+	}
+
+	return srcs, nil
+}
+
+// newestPlaylistEntries returns up to limit newest playlist entries, or all of them if the limit is zero, in
+// the order they were added. New videos are appended to the end of a playlist, while channel uploads playlists
+// list them starting from the newest one.
+func newestPlaylistEntries(playlistID string, entries []*youtube.PlaylistEntry, limit int) []*youtube.PlaylistEntry {
+	if strings.HasPrefix(playlistID, "UU") {
+		reversed := make([]*youtube.PlaylistEntry, len(entries))
+		for i, e := range entries {
+			reversed[len(entries)-1-i] = e
+		}
+
+		entries = reversed
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return entries
+}
+
 // DownloadURL returns the URL to download the YouTube video.
 func (y *YouTubeVideo) DownloadURL(ctx context.Context) (string, error) {
 	u, _, err := y.bestAudio(ctx)
 	return u, err
 }
 
+// Subtitles returns the transcript of the video made from its subtitles along with their language. Subtitles
+// uploaded by the author are preferred over automatically generated ones.
+func (y *YouTubeVideo) Subtitles(ctx context.Context) (Transcript, string, error) {
+	video, err := y.c.GetVideoContext(ctx, y.videoID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get video info: %w", err)
+	}
+
+	track, ok := pickSubtitles(video.CaptionTracks)
+	if !ok {
+		return nil, "", ErrNoSubtitles
+	}
+
+	u, err := url.Parse(track.BaseURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed subtitles URL: %w", err)
+	}
+
+	// subtitles are requested in the plain timed text format, since the WebVTT version of generated
+	// ones repeats each line twice
+	q := u.Query()
+	q.Del("fmt")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch subtitles: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch subtitles: server responded with %s", resp.Status)
+	}
+
+	var doc struct {
+		Texts []struct {
+			Start    float64 `xml:"start,attr"`
+			Duration float64 `xml:"dur,attr"`
+			Text     string  `xml:",chardata"`
+		} `xml:"text"`
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, "", fmt.Errorf("failed to parse subtitles: %w", err)
+	}
+
+	var t Transcript
+	for _, text := range doc.Texts {
+		start := time.Duration(text.Start * float64(time.Second)).Round(time.Millisecond)
+
+		t = append(t, TranscriptCue{
+			Start: start,
+			End:   start + time.Duration(text.Duration*float64(time.Second)).Round(time.Millisecond),
+			// the text is HTML-escaped once more on top of XML escaping
+			Text: strings.TrimSpace(html.UnescapeString(text.Text)),
+		})
+	}
+
+	if t = t.compact(); len(t) == 0 {
+		return nil, "", ErrNoSubtitles
+	}
+
+	return t.Shift(y.start), track.LanguageCode, nil
+}
+
+// pickSubtitles returns the first subtitles track uploaded by the author, or the automatically generated one
+// if there are none.
+func pickSubtitles(tracks []youtube.CaptionTrack) (youtube.CaptionTrack, bool) {
+	var (
+		generated youtube.CaptionTrack
+		found     bool
+	)
+
+	for _, track := range tracks {
+		if track.Kind != "asr" {
+			return track, true
+		}
+
+		if !found {
+			generated, found = track, true
+		}
+	}
+
+	return generated, found
+}
+
 func (y *YouTubeVideo) bestAudio(ctx context.Context) (string, youtube.Format, error) {
 	video, err := y.c.GetVideoContext(ctx, y.videoID)
 	if err != nil {
@@ -164,6 +537,22 @@ func pickBestAudio(formats youtube.FormatList) (youtube.Format, error) {
 	return youtube.Format{}, ErrNoAudio
 }
 
+// pickBestThumbnail returns the URL of the largest JPEG thumbnail, since podcast apps do not support WebP.
+func pickBestThumbnail(thumbnails youtube.Thumbnails) string {
+	var best youtube.Thumbnail
+	for _, th := range thumbnails {
+		if strings.Contains(th.URL, "_webp") || strings.Contains(th.URL, ".webp") {
+			continue
+		}
+
+		if th.Width*th.Height > best.Width*best.Height {
+			best = th
+		}
+	}
+
+	return best.URL
+}
+
 type audioQuality uint8
 
 const (
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+func TestExtractYouTubeID(t *testing.T) {
+	const id = "dQw4w9WgXcQ"
+
+	for _, tc := range []struct {
+		Input         string
+		ExpectedStart time.Duration
+	}{
+		{id, 0},
+		{" " + id + " ", 0},
+		{"https://www.youtube.com/watch?v=" + id, 0},
+		{"https://youtube.com/watch?v=" + id + "&list=PL0123456789", 0},
+		{"http://m.youtube.com/watch?v=" + id, 0},
+		{"https://music.youtube.com/watch?v=" + id + "&feature=share", 0},
+		{"https://WWW.YouTube.com/watch?v=" + id, 0},
+		{"youtube.com/watch?v=" + id, 0},
+		{"https://youtu.be/" + id, 0},
+		{"youtu.be/" + id + "?si=abc", 0},
+		{"https://www.youtube.com/shorts/" + id, 0},
+		{"https://www.youtube.com/live/" + id + "?feature=share", 0},
+		{"https://www.youtube.com/embed/" + id, 0},
+		{"https://www.youtube-nocookie.com/embed/" + id + "?start=42", 42 * time.Second},
+		{"https://www.youtube.com/v/" + id, 0},
+		{"https://www.youtube.com/watch?v=" + id + "&t=90", 90 * time.Second},
+		{"https://www.youtube.com/watch?v=" + id + "&t=90s", 90 * time.Second},
+		{"https://youtu.be/" + id + "?t=1m30s", 90 * time.Second},
+		{"https://youtu.be/" + id + "#t=1h2m3s", time.Hour + 2*time.Minute + 3*time.Second},
+		{"https://youtu.be/" + id + "?t=10#t=20", 10 * time.Second},
+		{"https://www.youtube.com/embed/" + id + "?start=0", 0},
+	} {
+		t.Run(tc.Input, func(t *testing.T) {
+			got, start, err := extractYouTubeID(tc.Input)
+			if err != nil {
+				t.Fatalf("extractYouTubeID(%q) returned an error: %s", tc.Input, err)
+			}
+
+			if got != id {
+				t.Errorf("extractYouTubeID(%q) = %q, expected %q", tc.Input, got, id)
+			}
+
+			if start != tc.ExpectedStart {
+				t.Errorf("extractYouTubeID(%q) start = %s, expected %s", tc.Input, start, tc.ExpectedStart)
+			}
+		})
+	}
+}
+
+func TestExtractYouTubeID_Errors(t *testing.T) {
+	for _, tc := range []string{
+		"",
+		"dQw4w9WgXc",
+		"dQw4w9WgXcQQ",
+		"https://www.youtube.com/watch",
+		"https://www.youtube.com/watch?v=dQw4w9WgXc",
+		"https://www.youtube.com/shorts/",
+		"https://www.youtube.com/playlist?list=PL0123456789",
+		"https://www.youtube.com/channel/UC0123456789abcdefghijkl",
+		"https://vimeo.com/dQw4w9WgXcQ",
+		"https://notyoutube.com/watch?v=dQw4w9WgXcQ",
+		"https://youtu.be/",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=-5",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=soon",
+		"https://youtu.be/dQw4w9WgXcQ?start=-1m",
+		"://youtu.be/dQw4w9WgXcQ",
+	} {
+		t.Run(tc, func(t *testing.T) {
+			if got, _, err := extractYouTubeID(tc); err == nil {
+				t.Errorf("extractYouTubeID(%q) = %q, expected an error", tc, got)
+			}
+		})
+	}
+}
+
+func TestNewestPlaylistEntries(t *testing.T) {
+	entries := func(ids ...string) []*youtube.PlaylistEntry {
+		var entries []*youtube.PlaylistEntry
+		for _, id := range ids {
+			entries = append(entries, &youtube.PlaylistEntry{ID: id})
+		}
+
+		return entries
+	}
+
+	for name, tc := range map[string]struct {
+		PlaylistID string
+		Entries    []*youtube.PlaylistEntry
+		Limit      int
+		Expected   []*youtube.PlaylistEntry
+	}{
+		"playlist":             {"PL0123456789ab", entries("a", "b", "c"), 0, entries("a", "b", "c")},
+		"playlist with limit":  {"PL0123456789ab", entries("a", "b", "c"), 2, entries("b", "c")},
+		"playlist above limit": {"PL0123456789ab", entries("a", "b"), 5, entries("a", "b")},
+		"uploads":              {"UU0123456789ab", entries("c", "b", "a"), 0, entries("a", "b", "c")},
+		"uploads with limit":   {"UU0123456789ab", entries("c", "b", "a"), 2, entries("b", "c")},
+		"empty uploads":        {"UU0123456789ab", nil, 2, []*youtube.PlaylistEntry{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := newestPlaylistEntries(tc.PlaylistID, tc.Entries, tc.Limit); !reflect.DeepEqual(actual, tc.Expected) {
+				t.Errorf("expected %v, got %v", tc.Expected, actual)
+			}
+		})
+	}
+}